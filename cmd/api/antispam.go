@@ -0,0 +1,292 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+const subscribeFormPurpose = "subscribe-form"
+
+// Reasons a subscribe attempt gets rejected, recorded for tuning the rules.
+const (
+	rejectHoneypot         = "honeypot"
+	rejectMissingToken     = "missing_form_token"
+	rejectTooFast          = "too_fast"
+	rejectStaleForm        = "stale_form"
+	rejectReusedToken      = "reused_form_token"
+	rejectIpRateLimit      = "ip_rate_limit"
+	rejectDomainRateLimit  = "domain_rate_limit"
+	rejectDisposableDomain = "disposable_domain"
+)
+
+var (
+	subscribeMinFillTime  = time.Duration(envInt("SUBSCRIBE_MIN_FILL_SECONDS", 3)) * time.Second
+	subscribeFormTTL      = time.Hour
+	subscribeIpLimit      = envInt("SUBSCRIBE_IP_LIMIT", 5)
+	subscribeIpWindow     = 10 * time.Minute
+	subscribeDomainLimit  = envInt("SUBSCRIBE_DOMAIN_LIMIT", 50)
+	subscribeDomainWindow = time.Hour
+)
+
+type subscribeRejection struct {
+	reason  string
+	status  int
+	message string
+}
+
+// rateLimiter counts hits per key in fixed windows. It lives in memory, which
+// is fine for a single instance and resets on deploy.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	hits  int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, windows: map[string]*rateWindow{}}
+}
+
+// Allow records a hit for key and reports whether it is still within limit.
+func (l *rateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	current, ok := l.windows[key]
+	if !ok || now.Sub(current.start) >= l.window {
+		current = &rateWindow{start: now}
+		l.windows[key] = current
+	}
+
+	current.hits++
+	return current.hits <= l.limit
+}
+
+// sweep forgets windows that have ended so the map doesn't grow forever.
+func (l *rateLimiter) sweep(ctx context.Context) {
+	ticker := time.NewTicker(l.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		l.mu.Lock()
+		for key, current := range l.windows {
+			if time.Since(current.start) >= l.window {
+				delete(l.windows, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (a *application) AllAntispamRoutes(r chi.Router) {
+	r.Use(requireAdmin)
+	r.Get("/domains", a.GetBlockedDomains)
+	r.Post("/domains", a.AddBlockedDomain)
+	r.Delete("/domains/{domain}", a.RemoveBlockedDomain)
+	r.Get("/rejections", a.GetSubscribeRejections)
+}
+
+// GetSubscribeFormToken hands the signup form a token stamped with when the
+// form was rendered, which /subscribe checks to catch instant bot posts.
+func (a *application) GetSubscribeFormToken(w http.ResponseWriter, r *http.Request) {
+	// The nonce makes every token different, so each can only be used once.
+	nonce, err := utils.RandomCode(16)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	token, err := utils.SignPurposeToken(strconv.FormatInt(time.Now().UnixMilli(), 10)+"."+nonce, subscribeFormPurpose, subscribeFormTTL)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"token": token})
+}
+
+// checkSubscribeSpam runs the cheap checks first and only touches the
+// database for the blocklist once everything else has passed.
+func (a *application) checkSubscribeSpam(r *http.Request, payload types.SubscribePayload) *subscribeRejection {
+	if payload.Website != "" {
+		return &subscribeRejection{reason: rejectHoneypot}
+	}
+
+	if payload.FormToken == "" {
+		return &subscribeRejection{rejectMissingToken, http.StatusBadRequest, "Please reload the page and try again"}
+	}
+
+	issued, err := utils.VerifyPurposeToken(payload.FormToken, subscribeFormPurpose)
+	if err != nil {
+		return &subscribeRejection{rejectStaleForm, http.StatusBadRequest, "This form has expired, please reload the page and try again"}
+	}
+
+	issued, nonce, _ := strings.Cut(issued, ".")
+	issuedAt, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil || nonce == "" {
+		return &subscribeRejection{rejectStaleForm, http.StatusBadRequest, "This form has expired, please reload the page and try again"}
+	}
+
+	if time.Since(time.UnixMilli(issuedAt)) < subscribeMinFillTime {
+		return &subscribeRejection{rejectTooFast, http.StatusBadRequest, "That was quick! Please try again"}
+	}
+
+	// Spent only once it's past the timing check, so a real person who was
+	// too quick can still send the same form again.
+	fresh, err := a.store.Antispam.UseFormToken(r.Context(), nonce, time.UnixMilli(issuedAt).Add(subscribeFormTTL))
+	if err != nil {
+		a.logger.Errorf("Spending subscribe form token: %v", err)
+	} else if !fresh {
+		return &subscribeRejection{rejectReusedToken, http.StatusBadRequest, "Please reload the page and try again"}
+	}
+
+	if !a.subscribeIpLimiter.Allow(clientIp(r)) {
+		return &subscribeRejection{rejectIpRateLimit, http.StatusTooManyRequests, "Too many signups from your network, please try again later"}
+	}
+
+	domain := emailDomain(payload.Email)
+	if !a.subscribeDomainLimiter.Allow(domain) {
+		return &subscribeRejection{rejectDomainRateLimit, http.StatusTooManyRequests, "Too many signups right now, please try again later"}
+	}
+
+	blocked, err := a.store.Antispam.IsDomainBlocked(r.Context(), domain)
+	if err != nil {
+		a.logger.Errorf("Checking blocked domain %s: %v", domain, err)
+	} else if blocked {
+		return &subscribeRejection{rejectDisposableDomain, http.StatusBadRequest, "Please use a permanent email address"}
+	}
+
+	return nil
+}
+
+// rejectSubscribe records the rejection and answers the client. Honeypot
+// hits get a fake success so bots don't learn what gave them away.
+func (a *application) rejectSubscribe(w http.ResponseWriter, r *http.Request, payload types.SubscribePayload, rejection *subscribeRejection) {
+	if err := a.store.Antispam.RecordRejection(r.Context(), types.SubscribeRejection{
+		Reason: rejection.reason,
+		Ip:     clientIp(r),
+		Email:  payload.Email,
+		Domain: emailDomain(payload.Email),
+	}); err != nil {
+		a.logger.Errorf("Recording subscribe rejection: %v", err)
+	}
+
+	if rejection.reason == rejectHoneypot {
+		utils.WriteJSON(w, http.StatusOK, fmt.Sprintf("%s with email: %s have joined the waitlist, check your inbox to confirm", payload.Name, payload.Email))
+		return
+	}
+
+	utils.WriteError(w, rejection.status, errors.New(rejection.message))
+}
+
+func (a *application) GetBlockedDomains(w http.ResponseWriter, r *http.Request) {
+	domains, err := a.store.Antispam.GetBlockedDomains(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, domains)
+}
+
+func (a *application) AddBlockedDomain(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var payload types.BlockedDomainDTO
+
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	domain, err := a.store.Antispam.AddBlockedDomain(ctx, payload)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, domain)
+}
+
+func (a *application) RemoveBlockedDomain(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	domain := chi.URLParam(r, "domain")
+
+	if err := a.store.Antispam.RemoveBlockedDomain(ctx, domain); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, fmt.Sprintf("%s is no longer blocked", domain))
+}
+
+// GetSubscribeRejections lists recent rejections, by default over the last
+// 7 days, along with a count per reason.
+func (a *application) GetSubscribeRejections(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days <= 0 {
+		days = 7
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	rejections, err := a.store.Antispam.GetRejections(ctx, r.URL.Query().Get("reason"), since, 200)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	summary, err := a.store.Antispam.GetRejectionSummary(ctx, since)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{
+		"summary":    summary,
+		"rejections": rejections,
+	})
+}
+
+// clientIp relies on realIP having already rewritten RemoteAddr for requests
+// from our proxies.
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func emailDomain(email string) string {
+	_, domain, _ := strings.Cut(strings.ToLower(email), "@")
+	return domain
+}
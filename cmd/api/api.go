@@ -17,16 +17,34 @@ import (
 )
 
 type application struct {
-	addr   string
-	logger *zap.SugaredLogger
-	store  *store.Store
+	addr         string
+	logger       *zap.SugaredLogger
+	store        *store.Store
+	sms          SMSSender
+	payments     PaymentProvider
+	campaignWake chan struct{}
+	publishWake  chan struct{}
+	blobs        BlobStore
+
+	subscribeIpLimiter     *rateLimiter
+	subscribeDomainLimiter *rateLimiter
+	trackIpLimiter         *rateLimiter
 }
 
 func NewApplication(logger *zap.SugaredLogger, store *store.Store) *application {
 	return &application{
-		addr:   ":8000",
-		logger: logger,
-		store:  store,
+		addr:         ":8000",
+		logger:       logger,
+		store:        store,
+		sms:          newSMSSender(logger),
+		payments:     newPaymentProvider(logger),
+		campaignWake: make(chan struct{}, 1),
+		publishWake:  make(chan struct{}, 1),
+		blobs:        newBlobStore(),
+
+		subscribeIpLimiter:     newRateLimiter(subscribeIpLimit, subscribeIpWindow),
+		subscribeDomainLimiter: newRateLimiter(subscribeDomainLimit, subscribeDomainWindow),
+		trackIpLimiter:         newRateLimiter(trackIpLimit, trackIpWindow),
 	}
 }
 
@@ -43,7 +61,7 @@ func (a *application) Run() error {
 
 	// A good base middleware stack
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(realIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
@@ -59,12 +77,34 @@ func (a *application) Run() error {
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		utils.WriteJSON(w, http.StatusOK, fmt.Sprintf("Welcome to Poohda"))
 	})
+	r.Get("/subscribe/token", a.GetSubscribeFormToken)
 	r.Post("/subscribe", a.SendMail)
 	r.Route("/auth", a.AllAuthRoutes)
 	r.Route("/categories", a.AllCategoryRoutes)
 	r.Route("/clothes", a.AllClothingRoutes)
 	r.Route("/waitlist", a.AllWaitlistRoutes)
+	r.Route("/email", a.AllEmailRoutes)
+	r.Route("/campaigns", a.AllCampaignRoutes)
+	r.Route("/antispam", a.AllAntispamRoutes)
 	r.Route("/orders", a.AllOrdersRoutes)
+	r.Route("/media", a.AllMediaRoutes)
+	r.Route("/currencies", a.AllCurrencyRoutes)
+	r.Route("/taxes", a.AllTaxRoutes)
+	r.Route("/returns", a.AllReturnRoutes)
+	r.Route("/track", a.AllTrackingRoutes)
+
+	// Background jobs run until the server starts shutting down.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	go a.purgeUnconfirmedWaitlist(jobsCtx)
+	go a.dispatchCampaigns(jobsCtx)
+	go a.publishScheduledClothes(jobsCtx)
+	go a.refreshSearchLexicon(jobsCtx)
+	go a.resyncSlugs(jobsCtx)
+	go a.subscribeIpLimiter.sweep(jobsCtx)
+	go a.subscribeDomainLimiter.sweep(jobsCtx)
+	go a.trackIpLimiter.sweep(jobsCtx)
 
 	// Run the server in a goroutine so it doesn't block
 	go func() {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/poohda-go/types"
@@ -15,7 +16,6 @@ func (a *application) AllAuthRoutes(r chi.Router) {
 }
 
 func (a *application) Login(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
 	var payload types.LoginDto
 
 	if err := utils.ParseJSON(r, &payload); err != nil {
@@ -34,6 +34,11 @@ func (a *application) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token := utils.JwtToken(payload.Username, ctx)
+	token, err := utils.SignPurposeToken(payload.Username, adminPurpose, 7*24*time.Hour)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	utils.WriteJSON(w, http.StatusAccepted, token)
 }
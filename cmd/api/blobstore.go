@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"github.com/poohda-go/utils"
+)
+
+var MEDIA_STORAGE = os.Getenv("MEDIA_STORAGE")
+
+const (
+	blobStorageCloudinary = "cloudinary"
+	blobStorageLocal      = "local"
+
+	// localMediaDir sits under ./public so the /public route serves it.
+	localMediaDir = "public/media"
+)
+
+// BlobStore keeps uploaded files somewhere they can be served from.
+type BlobStore interface {
+	// Name is what gets recorded against each media row, so a file can be
+	// found again even if the configured store changes later.
+	Name() string
+	// Put stores body under key and returns the public URL it is served at.
+	Put(ctx context.Context, key string, contentType string, body io.Reader) (string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// newBlobStore picks the store from MEDIA_STORAGE, falling back to local disk
+// so development never needs Cloudinary credentials.
+func newBlobStore() BlobStore {
+	switch MEDIA_STORAGE {
+	case blobStorageCloudinary:
+		return &cloudinaryBlobStore{folder: "poohda"}
+	default:
+		return &localBlobStore{dir: localMediaDir, baseUrl: APP_URL + "/" + localMediaDir}
+	}
+}
+
+// cloudinaryBlobStore uploads through the account from utils.InitializeCloudinary.
+type cloudinaryBlobStore struct {
+	folder string
+}
+
+// publicId drops the extension, Cloudinary works out the format itself.
+func (s *cloudinaryBlobStore) publicId(key string) string {
+	return s.folder + "/" + strings.TrimSuffix(key, filepath.Ext(key))
+}
+
+func (s *cloudinaryBlobStore) Name() string {
+	return blobStorageCloudinary
+}
+
+func (s *cloudinaryBlobStore) Put(ctx context.Context, key string, contentType string, body io.Reader) (string, error) {
+	cld, err := utils.InitializeCloudinary()
+	if err != nil {
+		return "", err
+	}
+
+	overwrite := false
+	result, err := cld.Upload.Upload(ctx, body, uploader.UploadParams{
+		PublicID:  s.publicId(key),
+		Overwrite: &overwrite,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if result.Error.Message != "" {
+		return "", errors.New(result.Error.Message)
+	}
+
+	return result.SecureURL, nil
+}
+
+func (s *cloudinaryBlobStore) Delete(ctx context.Context, key string) error {
+	cld, err := utils.InitializeCloudinary()
+	if err != nil {
+		return err
+	}
+
+	result, err := cld.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: s.publicId(key)})
+	if err != nil {
+		return err
+	}
+
+	if result.Error.Message != "" {
+		return errors.New(result.Error.Message)
+	}
+
+	return nil
+}
+
+// localBlobStore writes files to disk, for development and single box setups.
+type localBlobStore struct {
+	dir     string
+	baseUrl string
+}
+
+func (s *localBlobStore) Name() string {
+	return blobStorageLocal
+}
+
+func (s *localBlobStore) Put(ctx context.Context, key string, contentType string, body io.Reader) (string, error) {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return "", err
+	}
+
+	// Write to a temporary name first so a failed upload never leaves half a
+	// file where the URL points.
+	temp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := io.Copy(temp, body); err != nil {
+		temp.Close()
+		return "", err
+	}
+
+	if err := temp.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(temp.Name(), filepath.Join(s.dir, filepath.Base(key))); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%s", s.baseUrl, filepath.Base(key)), nil
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(s.dir, filepath.Base(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,367 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+var (
+	campaignBatchSize     = envInt("CAMPAIGN_BATCH_SIZE", 25)
+	campaignBatchInterval = time.Duration(envInt("CAMPAIGN_BATCH_INTERVAL_SECONDS", 30)) * time.Second
+	// A failed send is tried again campaignRetryDelay later, then twice as
+	// long after that, up to campaignMaxAttempts in all.
+	campaignMaxAttempts = envInt("CAMPAIGN_MAX_ATTEMPTS", 3)
+	campaignRetryDelay  = 5 * time.Minute
+)
+
+// campaignTemplates maps each template to the preference category its mail
+// counts towards.
+var campaignTemplates = map[string]string{
+	types.CampaignTemplateLaunch:       types.MailCategoryLaunchNews,
+	types.CampaignTemplateAnnouncement: types.MailCategoryPromotions,
+	types.CampaignTemplateEarlyAccess:  types.MailCategoryLaunchNews,
+}
+
+var campaignMail = template.Must(template.New("campaign").Parse(`
+<!DOCTYPE html>
+<html lang="en">
+
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>{{.Subject}}</title>
+</head>
+
+<body style="color: #ffffff;  background-color: #f4f4f4; padding: 10px;">
+  <div style="max-width: 600px; margin: 0 auto; background-color: #000000; padding: 20px; border-radius: 8px; box-shadow: 0 0 10px rgba(0, 0, 0, 0.1);">
+
+    <div style="text-align: center;">
+      <img alt="PoohDa" src="https://res.cloudinary.com/brownson/image/upload/v1734001320/pmbizybnu0aeentwkcza.png"
+        style="width: 300px;  padding: 0px; margin: -50px;" />
+      <h1 style="font-family: Helvetica, Arial, sans-serif; font-size: 30px; margin-top: -50px; color: #008000;">
+        {{if eq .Template "launch"}}We’re Live{{else if eq .Template "early_access"}}You’re In Early{{else}}{{.Headline}}{{end}}</h1>
+    </div>
+
+    <div style="line-height: 1.6;">
+      <p style="margin-bottom: 16px;">Hey {{.Name}}!</p>
+{{if eq .Template "launch"}}
+      <p style="margin-bottom: 16px;">The wait is over. Da Difference is live on the PooHDa website and, as promised,
+        you’re hearing it first.</p>
+
+      <p style="margin-bottom: 16px;">Every piece is rare, limited and unimagined, so don’t sleep on it.</p>
+{{else if eq .Template "early_access"}}
+      <p style="margin-bottom: 16px;">We promised you’d be first, so the new drop is open to you before anyone else.</p>
+
+      <p style="margin-bottom: 16px; text-align: center;">
+        <a href="{{.AccessUrl}}" style="display: inline-block; padding: 12px 24px; background-color: #008000; color: #ffffff; text-decoration: none; border-radius: 4px; font-family: Helvetica, Arial, sans-serif;">Shop early</a>
+      </p>
+
+      <p style="margin-bottom: 16px;">Or enter your access code <strong style="color: #008000;">{{.AccessCode}}</strong> at checkout.
+        It’s yours alone, so keep it to yourself.</p>
+{{else}}
+      <p style="margin-bottom: 16px; white-space: pre-line;">{{.Message}}</p>
+{{end}}
+      <p style="margin-top: 40px;">
+        <span style="display: block;">Catch you soon,</span>
+        <span style="display: block; font-weight: bold;">POOH</span>
+        <span style="display: block;">Creative Director, PooHDa</span>
+      </p>
+    </div>
+
+    <div style="text-align: center; margin-top: 20px; font-size: 12px; color: #aaa;">
+      <p>&copy; 2024 PooHDa. All rights reserved.</p>
+      <p><a href="{{.UnsubscribeUrl}}" style="color: #aaa;">Unsubscribe</a> · <a href="{{.PreferencesUrl}}" style="color: #aaa;">Email preferences</a></p>
+    </div>
+  </div>
+</body>
+
+</html>
+`))
+
+func (a *application) AllCampaignRoutes(r chi.Router) {
+	r.Use(requireAdmin)
+	r.Get("/", a.GetAllCampaigns)
+	r.Post("/", a.CreateCampaign)
+	r.Get("/{campaign}", a.GetOneCampaign)
+	r.Get("/{campaign}/recipients", a.GetCampaignRecipients)
+	r.Post("/{campaign}/stop", a.StopCampaign)
+}
+
+func (a *application) CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var payload types.CampaignDTO
+
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	campaign, err := a.store.Campaigns.CreateCampaign(ctx, payload)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	// Send-now campaigns shouldn't wait for the next tick.
+	a.wakeCampaigns()
+	utils.WriteJSON(w, http.StatusCreated, campaign)
+}
+
+func (a *application) GetAllCampaigns(w http.ResponseWriter, r *http.Request) {
+	campaigns, err := a.store.Campaigns.GetAllCampaigns(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, campaigns)
+}
+
+func (a *application) GetOneCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "campaign"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	campaign, err := a.store.Campaigns.GetOneCampaign(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No campaign like this"))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, campaign)
+}
+
+func (a *application) GetCampaignRecipients(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "campaign"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	recipients, err := a.store.Campaigns.GetCampaignRecipients(ctx, id, r.URL.Query().Get("status"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, recipients)
+}
+
+func (a *application) StopCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "campaign"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	campaign, err := a.store.Campaigns.StopCampaign(ctx, id)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, campaign)
+}
+
+func (a *application) wakeCampaigns() {
+	select {
+	case a.campaignWake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchCampaigns starts every campaign as its scheduled time arrives, and
+// on startup resumes any that were interrupted mid-send.
+func (a *application) dispatchCampaigns(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	interrupted, err := a.store.Campaigns.GetSendingCampaigns(ctx)
+	if err != nil {
+		a.logger.Errorf("Resuming campaigns: %v", err)
+	}
+
+	for _, campaign := range interrupted {
+		go a.sendCampaign(ctx, campaign)
+	}
+
+	for {
+		due, err := a.store.Campaigns.ClaimDueCampaigns(ctx)
+		if err != nil {
+			a.logger.Errorf("Claiming due campaigns: %v", err)
+		}
+
+		for _, campaign := range due {
+			go a.sendCampaign(ctx, campaign)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-a.campaignWake:
+		}
+	}
+}
+
+// sendCampaign mails the campaign's recipients in throttled batches, checking
+// between batches whether an admin has stopped it.
+func (a *application) sendCampaign(ctx context.Context, campaign types.Campaign) {
+	if _, err := a.store.Campaigns.PopulateCampaignRecipients(ctx, campaign); err != nil {
+		a.logger.Errorf("Populating campaign %d: %v", campaign.Id, err)
+		return
+	}
+
+	for {
+		status, err := a.store.Campaigns.GetCampaignStatus(ctx, campaign.Id)
+		if err != nil {
+			a.logger.Errorf("Checking campaign %d: %v", campaign.Id, err)
+			return
+		}
+
+		if status != types.CampaignStatusSending {
+			a.logger.Infof("Campaign %d is %s, no longer sending", campaign.Id, status)
+			return
+		}
+
+		recipients, err := a.store.Campaigns.GetPendingCampaignRecipients(ctx, campaign.Id, campaignBatchSize)
+		if err != nil {
+			a.logger.Errorf("Loading campaign %d recipients: %v", campaign.Id, err)
+			return
+		}
+
+		if len(recipients) == 0 {
+			pending, err := a.store.Campaigns.HasPendingCampaignRecipients(ctx, campaign.Id)
+			if err != nil {
+				a.logger.Errorf("Checking campaign %d recipients: %v", campaign.Id, err)
+				return
+			}
+
+			// Some are waiting to be retried, so check back after a batch.
+			if pending {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(campaignBatchInterval):
+				}
+				continue
+			}
+
+			if err := a.store.Campaigns.FinishCampaign(ctx, campaign.Id); err != nil {
+				a.logger.Errorf("Finishing campaign %d: %v", campaign.Id, err)
+			}
+
+			a.logger.Infof("Campaign %d sent", campaign.Id)
+			return
+		}
+
+		for _, recipient := range recipients {
+			status, reason := types.RecipientStatusSent, ""
+			if err := a.sendCampaignMail(ctx, campaign, recipient); err == errUnsubscribed {
+				status, reason = types.RecipientStatusSkipped, err.Error()
+			} else if err != nil {
+				status, reason = types.RecipientStatusFailed, err.Error()
+			}
+
+			if status == types.RecipientStatusFailed && recipient.Attempts+1 < campaignMaxAttempts {
+				delay := campaignRetryDelay * time.Duration(1<<recipient.Attempts)
+				if err := a.store.Campaigns.RetryCampaignRecipient(ctx, recipient.Id, reason, delay); err != nil {
+					a.logger.Errorf("Marking campaign %d recipient %d for retry: %v", campaign.Id, recipient.Id, err)
+					return
+				}
+				continue
+			}
+
+			if err := a.store.Campaigns.MarkCampaignRecipient(ctx, recipient.Id, status, reason); err != nil {
+				a.logger.Errorf("Marking campaign %d recipient %d: %v", campaign.Id, recipient.Id, err)
+				return
+			}
+
+			// Launch news also goes out by text to members who opted in, on a
+			// best effort basis that doesn't affect the email status.
+			if campaign.Template == types.CampaignTemplateLaunch && recipient.Number != "" && status != types.RecipientStatusSkipped {
+				if err := a.sms.SendSMS(ctx, recipient.Number, fmt.Sprintf("Hey %s, PooHDa is live! Da Difference is waiting for you on the website.", recipient.Name)); err != nil {
+					a.logger.Errorf("Texting campaign %d recipient %d: %v", campaign.Id, recipient.Id, err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(campaignBatchInterval):
+		}
+	}
+}
+
+func (a *application) sendCampaignMail(ctx context.Context, campaign types.Campaign, recipient types.CampaignRecipient) error {
+	var body bytes.Buffer
+	category := campaignTemplates[campaign.Template]
+
+	unsubscribe, err := unsubscribeUrl(recipient.Email, category)
+	if err != nil {
+		return err
+	}
+
+	preferences, err := preferencesUrl(recipient.Email)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]string{
+		"Template":       campaign.Template,
+		"Subject":        campaign.Subject,
+		"Headline":       campaign.Headline,
+		"Message":        campaign.Message,
+		"Name":           recipient.Name,
+		"UnsubscribeUrl": unsubscribe,
+		"PreferencesUrl": preferences,
+	}
+
+	// Early access mail carries the member's own code and magic link.
+	if campaign.Template == types.CampaignTemplateEarlyAccess {
+		if data["AccessCode"], err = a.store.Waitlist.IssueAccessCode(ctx, recipient.Email); err != nil {
+			return err
+		}
+
+		if data["AccessUrl"], err = earlyAccessUrl(recipient.Email); err != nil {
+			return err
+		}
+	}
+
+	if err := campaignMail.Execute(&body, data); err != nil {
+		return err
+	}
+
+	return a.deliverMail(ctx, outgoingMail{
+		To:       recipient.Email,
+		Subject:  campaign.Subject,
+		Body:     body.String(),
+		Category: category,
+	})
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+// catalogImportBatch is how many rows go in each import transaction.
+var catalogImportBatch = envInt("CATALOG_IMPORT_BATCH", 100)
+
+// catalogColumns are the catalog CSV's columns, in the order they're exported.
+// Prices are in kobo, like everywhere else.
+var catalogColumns = []string{"sku", "name", "category", "description", "price", "compare_at_price", "sale_price", "sale_starts_at", "sale_ends_at", "quantity", "colour", "sizes", "pictures", "is_featured", "status", "publish_at"}
+
+// catalogListSeparator splits the sizes and pictures cells.
+const catalogListSeparator = "|"
+
+// ExportCatalog streams every clothing not in the trash as a CSV that
+// ImportCatalog reads back in unchanged.
+func (a *application) ExportCatalog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	export := exportFile{
+		Name:   "catalog",
+		Sheet:  "Catalog",
+		Header: catalogColumns,
+		Raw:    true,
+	}
+
+	a.streamExport(w, "csv", export, func(write func([]string) error) error {
+		return a.store.Clothes.StreamCatalog(ctx, func(row types.CatalogRow) error {
+			return write([]string{
+				row.Sku,
+				row.Name,
+				row.Category,
+				row.Description,
+				strconv.FormatInt(row.Price.Amount, 10),
+				catalogMoney(row.CompareAtPrice),
+				catalogMoney(row.SalePrice),
+				catalogTime(row.SaleStartsAt),
+				catalogTime(row.SaleEndsAt),
+				strconv.Itoa(row.Quantity),
+				row.Colour,
+				strings.Join(row.Sizes, catalogListSeparator),
+				strings.Join(row.Pictures, catalogListSeparator),
+				strconv.FormatBool(row.IsFeatured),
+				row.Status,
+				catalogTime(row.PublishAt),
+			})
+		})
+	})
+}
+
+// ImportCatalog creates or updates clothes from a CSV with the columns of
+// ExportCatalog, sent either as the "file" form field or as the raw body.
+// Rows are matched on sku, so importing the same file twice changes nothing.
+// With ?dry_run=true it only reports what would happen.
+func (a *application) ImportCatalog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	result := types.CatalogImportResult{DryRun: dryRun, Errors: []types.CatalogImportError{}}
+
+	http.NewResponseController(w).SetReadDeadline(time.Now().Add(time.Minute))
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(5 * time.Minute))
+	r.Body = http.MaxBytesReader(w, r.Body, 10<<20)
+
+	var file io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		upload, _, err := r.FormFile("file")
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Upload the CSV as the file field: %v", err))
+			return
+		}
+		defer upload.Close()
+		file = upload
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Cannot read the CSV header: %v", err))
+		return
+	}
+
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+
+	for _, required := range []string{"sku", "name", "category", "description", "price", "quantity", "sizes"} {
+		if _, ok := columns[required]; !ok {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("The CSV needs a %s column", required))
+			return
+		}
+	}
+
+	cell := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rows := []types.CatalogRow{}
+	seen := map[string]int{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			result.Errors = append(result.Errors, types.CatalogImportError{Row: line, Error: err.Error()})
+			continue
+		}
+
+		result.Total++
+		row, err := parseCatalogRow(line, func(column string) string { return cell(record, column) })
+		if err != nil {
+			result.Errors = append(result.Errors, types.CatalogImportError{Row: line, Sku: row.Sku, Error: err.Error()})
+			continue
+		}
+
+		if first, ok := seen[row.Sku]; ok {
+			result.Errors = append(result.Errors, types.CatalogImportError{Row: line, Sku: row.Sku, Error: fmt.Sprintf("This SKU is already on row %d", first)})
+			continue
+		}
+
+		seen[row.Sku] = line
+		rows = append(rows, row)
+	}
+
+	scheduled := false
+	for start := 0; start < len(rows); start += catalogImportBatch {
+		batch := rows[start:min(start+catalogImportBatch, len(rows))]
+
+		imported, err := a.store.Clothes.ImportCatalog(ctx, batch, dryRun)
+		if err != nil {
+			// The whole batch was rolled back, earlier batches stay in.
+			a.logger.Errorf("Importing catalog rows %d to %d: %v", batch[0].Line, batch[len(batch)-1].Line, err)
+			for _, row := range batch {
+				result.Errors = append(result.Errors, types.CatalogImportError{Row: row.Line, Sku: row.Sku, Error: err.Error()})
+			}
+			continue
+		}
+
+		result.Created += imported.Created
+		result.Updated += imported.Updated
+		result.Errors = append(result.Errors, imported.Errors...)
+
+		scheduled = scheduled || slices.ContainsFunc(batch, func(row types.CatalogRow) bool {
+			return row.Status == types.ClothesStatusScheduled
+		})
+	}
+
+	if scheduled && !dryRun {
+		a.wakePublisher()
+	}
+
+	slices.SortStableFunc(result.Errors, func(a, b types.CatalogImportError) int {
+		return a.Row - b.Row
+	})
+
+	utils.WriteJSON(w, http.StatusOK, result)
+}
+
+// parseCatalogRow reads and validates one line of the catalog CSV.
+func parseCatalogRow(line int, cell func(string) string) (types.CatalogRow, error) {
+	row := types.CatalogRow{
+		Line:        line,
+		Sku:         cell("sku"),
+		Name:        cell("name"),
+		Category:    cell("category"),
+		Description: cell("description"),
+		Colour:      cell("colour"),
+		Sizes:       splitCatalogList(cell("sizes")),
+		Pictures:    splitCatalogList(cell("pictures")),
+		Status:      strings.ToLower(cell("status")),
+	}
+
+	price, err := parseCatalogMoney(cell, "price")
+	if err != nil {
+		return row, err
+	}
+
+	if price == nil {
+		return row, fmt.Errorf("price can't be blank")
+	}
+	row.Price = *price
+
+	if row.Quantity, err = strconv.Atoi(cell("quantity")); err != nil {
+		return row, fmt.Errorf("quantity has to be a whole number")
+	}
+
+	if value := cell("is_featured"); value != "" {
+		if row.IsFeatured, err = strconv.ParseBool(value); err != nil {
+			return row, fmt.Errorf("is_featured has to be true or false")
+		}
+	}
+
+	if row.CompareAtPrice, err = parseCatalogMoney(cell, "compare_at_price"); err != nil {
+		return row, err
+	}
+
+	if row.SalePrice, err = parseCatalogMoney(cell, "sale_price"); err != nil {
+		return row, err
+	}
+
+	for column, target := range map[string]**time.Time{"publish_at": &row.PublishAt, "sale_starts_at": &row.SaleStartsAt, "sale_ends_at": &row.SaleEndsAt} {
+		if *target, err = parseCatalogTime(cell, column); err != nil {
+			return row, err
+		}
+	}
+
+	if err := utils.ValidateJson(row); err != nil {
+		return row, err
+	}
+
+	return row, checkClothesPricing(row.Price, row.CompareAtPrice, row.SalePrice, row.SaleStartsAt, row.SaleEndsAt)
+}
+
+// parseCatalogMoney reads an optional amount in kobo, blank being nil.
+func parseCatalogMoney(cell func(string) string, column string) (*types.Money, error) {
+	value := cell(column)
+	if value == "" {
+		return nil, nil
+	}
+
+	amount, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s has to be a whole number of kobo", column)
+	}
+
+	money := types.NewMoney(amount)
+	return &money, nil
+}
+
+// parseCatalogTime reads an optional RFC 3339 cell, blank being nil.
+func parseCatalogTime(cell func(string) string, column string) (*time.Time, error) {
+	value := cell(column)
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s has to look like 2024-12-31T18:00:00Z", column)
+	}
+
+	return &parsed, nil
+}
+
+func catalogMoney(value *types.Money) string {
+	if value == nil {
+		return ""
+	}
+
+	return strconv.FormatInt(value.Amount, 10)
+}
+
+func catalogTime(value *time.Time) string {
+	if value == nil {
+		return ""
+	}
+
+	return value.Format(time.RFC3339)
+}
+
+// splitCatalogList splits a sizes or pictures cell, dropping blanks and
+// repeats.
+func splitCatalogList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, catalogListSeparator) {
+		item = strings.TrimSpace(item)
+		if item != "" && !slices.Contains(items, item) {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
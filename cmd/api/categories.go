@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/store"
 	"github.com/poohda-go/types"
 	"github.com/poohda-go/utils"
 )
@@ -15,11 +16,17 @@ import (
 func (a *application) AllCategoryRoutes(r chi.Router) {
 	r.Group(func(r chi.Router) {
 		r.Get("/", a.GetAllCategories)
-		r.Post("/", a.CreateNewCategory)
+		r.With(requireAdmin).Post("/", a.CreateNewCategory)
+		r.Get("/tree", a.GetCategoryTree)
+		r.With(requireAdmin).Get("/trash", a.GetTrashedCategories)
+		r.With(requireAdmin).Delete("/trash/{category}", a.PurgeCategory)
+		r.With(requireAdmin).Post("/{category}/restore", a.RestoreCategory)
 		r.Get("/{category}", a.GetOneCategory)
+		r.Get("/{category}/breadcrumbs", a.GetCategoryBreadcrumbs)
 		r.Get("/{category}/clothes", a.GetAllClothingReferenceToCategory)
-		r.Put("/{category}", a.EditCategory)
-		r.Delete("/{category}", a.DeleteCategory)
+		r.With(requireAdmin).Put("/{category}", a.EditCategory)
+		r.With(requireAdmin).Patch("/{category}/images/{image}", a.EditCategoryImage)
+		r.With(requireAdmin).Delete("/{category}", a.DeleteCategory)
 	})
 }
 
@@ -65,12 +72,11 @@ func (a *application) GetAllCategories(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusOK, categories)
 }
 
+// GetOneCategory looks the category up by id or slug.
 func (a *application) GetOneCategory(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	categoryName := chi.URLParam(r, "category")
-	id, err := strconv.Atoi(categoryName)
-	if err != nil {
-		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+	id, ok := idOrSlug(w, r, "category", a.store.Categories.ResolveCategorySlug, "No category like this")
+	if !ok {
 		return
 	}
 
@@ -83,25 +89,75 @@ func (a *application) GetOneCategory(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusOK, category)
 }
 
+func (a *application) GetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	tree, err := a.store.Categories.GetCategoryTree(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, tree)
+}
+
+func (a *application) GetCategoryBreadcrumbs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := idOrSlug(w, r, "category", a.store.Categories.ResolveCategorySlug, "No category like this")
+	if !ok {
+		return
+	}
+
+	breadcrumbs, err := a.store.Categories.GetCategoryBreadcrumbs(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No category like this"))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, breadcrumbs)
+}
+
 func (a *application) GetAllClothingReferenceToCategory(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	categoryName := chi.URLParam(r, "category")
-	id, err := strconv.Atoi(categoryName)
+	id, ok := idOrSlug(w, r, "category", a.store.Categories.ResolveCategorySlug, "No category like this")
+	if !ok {
+		return
+	}
+
+	filter, err := parseClothesFilter(r)
 	if err != nil {
-		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
-	clothings, err := a.store.Categories.GetAllClothesReferenceToACategory(ctx, id)
+
+	currency, rate, ok := a.displayRate(w, r)
+	if !ok {
+		return
+	}
+
+	clothings, err := a.store.Categories.GetAllClothesReferenceToACategory(ctx, id, filter)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			utils.WriteJSON(w, http.StatusOK, fmt.Sprintf("No clothings in this category"))
 			return
 		}
 
+		if err == store.ErrInvalidCursor {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
 		utils.WriteError(w, http.StatusConflict, err)
 		return
 	}
 
+	for i := range clothings.Data {
+		setDisplayPrice(&clothings.Data[i], currency, rate)
+	}
+
 	utils.WriteJSON(w, http.StatusOK, clothings)
 }
 
@@ -136,6 +192,8 @@ func (a *application) EditCategory(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusAccepted, category)
 }
 
+// DeleteCategory moves the category to the trash, see PurgeCategory for
+// deleting it for good.
 func (a *application) DeleteCategory(w http.ResponseWriter, r *http.Request) {
 	categoryName := chi.URLParam(r, "category")
 	ctx := r.Context()
@@ -147,7 +205,7 @@ func (a *application) DeleteCategory(w http.ResponseWriter, r *http.Request) {
 
 	category, err := a.store.Categories.DeleteCategory(ctx, id)
 	if err != nil {
-		utils.WriteError(w, http.StatusConflict, err)
+		writeTrashError(w, err, "No category like this")
 		return
 	}
 
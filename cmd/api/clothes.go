@@ -1,22 +1,38 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/store"
 	"github.com/poohda-go/types"
 	"github.com/poohda-go/utils"
 )
 
+const searchLexiconInterval = 10 * time.Minute
+
 func (a *application) AllClothingRoutes(r chi.Router) {
 	r.Group(func(r chi.Router) {
-		r.Post("/", a.CreateNewClothing)
+		r.With(requireAdmin).Post("/", a.CreateNewClothing)
 		r.Get("/", a.GetAllClothings)
-		r.Delete("/{id}", a.DeleteOneClothing)
+		r.With(requireAdmin).Get("/admin", a.GetAllClothingsForAdmin)
+		r.With(requireAdmin).Get("/export", a.ExportCatalog)
+		r.With(requireAdmin).Post("/import", a.ImportCatalog)
+		r.With(requireAdmin).Get("/trash", a.GetTrashedClothes)
+		r.With(requireAdmin).Delete("/trash/{id}", a.PurgeClothing)
+		r.With(requireAdmin).Post("/{id}/restore", a.RestoreClothing)
+		r.With(requireAdmin).Delete("/{id}", a.DeleteOneClothing)
 		r.Get("/{id}", a.GetOneClothing)
+		r.With(requireAdmin).Put("/{id}", a.EditClothing)
+		r.With(requireAdmin).Patch("/{id}/images/{image}", a.EditClothingImage)
+		r.With(requireAdmin).Get("/{id}/price-history", a.GetPriceHistory)
+		r.Get("/search", a.GetClothesThroughName)
+		r.Get("/suggest", a.SuggestClothes)
 		r.Get("/search/{search}", a.GetClothesThroughName)
 	})
 }
@@ -56,6 +72,16 @@ func (a *application) CreateNewClothing(w http.ResponseWriter, r *http.Request)
 		utils.WriteError(w, http.StatusConflict, err)
 		return
 	}
+	if err := checkClothesSchedule(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := checkClothesPricing(payload.Price, payload.CompareAtPrice, payload.SalePrice, payload.SaleStartsAt, payload.SaleEndsAt); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	a.logger.Info(payload)
 	newClothings, err := a.store.Clothes.CreateNewClothes(ctx, payload)
 	if err != nil {
@@ -63,15 +89,38 @@ func (a *application) CreateNewClothing(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if newClothings.Status == types.ClothesStatusScheduled || (newClothings.Status == types.ClothesStatusPublished && newClothings.LaunchCampaign) {
+		a.wakePublisher()
+	}
+
 	utils.WriteJSON(w, http.StatusCreated, newClothings)
 }
 
 func (a *application) GetAllClothings(w http.ResponseWriter, r *http.Request) {
-	clothings, err := a.store.Clothes.GetAllClothes()
+	ctx := r.Context()
+	filter, err := parseClothesFilter(r)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			a.logger.Infof("GetAllClpthng: %v", err)
-			utils.WriteJSON(w, http.StatusOK, fmt.Sprintf("No clothings at all"))
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if category := r.URL.Query().Get("category"); category != "" {
+		filter.CategoryId, err = strconv.Atoi(category)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("category has to be a number"))
+			return
+		}
+	}
+
+	currency, rate, ok := a.displayRate(w, r)
+	if !ok {
+		return
+	}
+
+	clothings, err := a.store.Clothes.ListClothes(ctx, filter)
+	if err != nil {
+		if err == store.ErrInvalidCursor {
+			utils.WriteError(w, http.StatusBadRequest, err)
 			return
 		}
 
@@ -79,17 +128,117 @@ func (a *application) GetAllClothings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for i := range clothings.Data {
+		setDisplayPrice(&clothings.Data[i], currency, rate)
+	}
+
 	utils.WriteJSON(w, http.StatusOK, clothings)
 }
 
-func (a *application) GetOneClothing(w http.ResponseWriter, r *http.Request) {
+// GetAllClothingsForAdmin lists clothes whatever their status, or only those
+// with ?status=.
+func (a *application) GetAllClothingsForAdmin(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := chi.URLParam(r, "id")
-	clotheId, err := strconv.Atoi(id)
+	filter, err := parseClothesFilter(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter.IncludeUnpublished = true
+	filter.Status = r.URL.Query().Get("status")
+	switch filter.Status {
+	case "", types.ClothesStatusDraft, types.ClothesStatusScheduled, types.ClothesStatusPublished, types.ClothesStatusArchived:
+	default:
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("status has to be one of draft, scheduled, published or archived"))
+		return
+	}
+
+	clothings, err := a.store.Clothes.ListClothes(ctx, filter)
 	if err != nil {
+		if err == store.ErrInvalidCursor {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
 		utils.WriteError(w, http.StatusConflict, err)
 		return
 	}
+
+	utils.WriteJSON(w, http.StatusOK, clothings)
+}
+
+// parseClothesFilter reads the listing query string: min_price, max_price,
+// size, colour, featured, q, sort, cursor, limit (20 by default, at most 100)
+// and facets.
+func parseClothesFilter(r *http.Request) (types.ClothesFilter, error) {
+	query := r.URL.Query()
+	filter := types.ClothesFilter{
+		Size:   query.Get("size"),
+		Colour: query.Get("colour"),
+		Search: query.Get("q"),
+		Sort:   query.Get("sort"),
+		Cursor: query.Get("cursor"),
+		Limit:  20,
+	}
+
+	// Price filters are in kobo, whatever currency prices are shown in.
+	for name, target := range map[string]**int64{"min_price": &filter.MinPrice, "max_price": &filter.MaxPrice} {
+		if value := query.Get(name); value != "" {
+			price, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return filter, fmt.Errorf("%s has to be a number", name)
+			}
+			*target = &price
+		}
+	}
+
+	if value := query.Get("featured"); value != "" {
+		featured, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("featured has to be true or false")
+		}
+		filter.Featured = &featured
+	}
+
+	if value := query.Get("facets"); value != "" {
+		facets, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("facets has to be true or false")
+		}
+		filter.Facets = facets
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > 100 {
+			return filter, fmt.Errorf("limit has to be between 1 and 100")
+		}
+		filter.Limit = limit
+	}
+
+	switch filter.Sort {
+	case "", types.ClothesSortNewest, types.ClothesSortPriceAsc, types.ClothesSortPriceDesc, types.ClothesSortName:
+	default:
+		return filter, fmt.Errorf("sort has to be one of newest, price_asc, price_desc or name")
+	}
+
+	return filter, nil
+}
+
+// GetOneClothing looks the clothing up by id or slug.
+func (a *application) GetOneClothing(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	clotheId, ok := idOrSlug(w, r, "id", a.store.Clothes.ResolveClothesSlug, "No clothing like this exists!")
+	if !ok {
+		return
+	}
+
+	currency, rate, ok := a.displayRate(w, r)
+	if !ok {
+		return
+	}
+
 	clothings, err := a.store.Clothes.GetOneClothes(ctx, clotheId)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -101,22 +250,234 @@ func (a *application) GetOneClothing(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setDisplayPrice(clothings, currency, rate)
 	utils.WriteJSON(w, http.StatusOK, clothings)
 }
 
+func (a *application) EditClothing(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	var payload types.ClothesDTO
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	if err := checkClothesSchedule(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := checkClothesPricing(payload.Price, payload.CompareAtPrice, payload.SalePrice, payload.SaleStartsAt, payload.SaleEndsAt); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := a.store.Categories.GetOneCategory(ctx, payload.CategoryId); err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusConflict, fmt.Errorf("No category like this"))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	clothing, err := a.store.Clothes.EditClothes(ctx, id, payload)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No clothing like this exists!"))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	if clothing.Status == types.ClothesStatusScheduled || (clothing.Status == types.ClothesStatusPublished && clothing.LaunchCampaign) {
+		a.wakePublisher()
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, clothing)
+}
+
+// checkClothesSchedule makes sure an early access window opens before the
+// public release it leads up to.
+func checkClothesSchedule(payload types.ClothesDTO) error {
+	if payload.EarlyAccessAt != nil && (payload.PublishAt == nil || !payload.EarlyAccessAt.Before(*payload.PublishAt)) {
+		return fmt.Errorf("early_access_at has to be before publish_at")
+	}
+
+	return nil
+}
+
+// checkClothesPricing makes sure prices are in the base currency, a
+// compare-at price is above the price, a sale price below it and a sale
+// window the right way round.
+func checkClothesPricing(price types.Money, compareAtPrice *types.Money, salePrice *types.Money, saleStartsAt *time.Time, saleEndsAt *time.Time) error {
+	for _, amount := range []*types.Money{&price, compareAtPrice, salePrice} {
+		if amount != nil && !amount.IsBaseCurrency() {
+			return fmt.Errorf("Prices have to be in %s, other currencies are only for display", types.BaseCurrency)
+		}
+	}
+
+	if price.Amount <= 0 {
+		return fmt.Errorf("price has to be more than 0")
+	}
+
+	if compareAtPrice != nil && compareAtPrice.Amount <= price.Amount {
+		return fmt.Errorf("compare_at_price has to be more than price")
+	}
+
+	if salePrice == nil {
+		if saleStartsAt != nil || saleEndsAt != nil {
+			return fmt.Errorf("sale_starts_at and sale_ends_at need a sale_price")
+		}
+		return nil
+	}
+
+	if salePrice.Amount >= price.Amount {
+		return fmt.Errorf("sale_price has to be less than price")
+	}
+
+	if saleStartsAt != nil && saleEndsAt != nil && !saleStartsAt.Before(*saleEndsAt) {
+		return fmt.Errorf("sale_starts_at has to be before sale_ends_at")
+	}
+
+	return nil
+}
+
+// GetPriceHistory lists every price the clothing has had, newest first.
+func (a *application) GetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	history, err := a.store.Clothes.GetPriceHistory(ctx, id)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, history)
+}
+
+// GetClothesThroughName searches clothes, taking the same filters as the
+// listing so the results and their facets always agree.
 func (a *application) GetClothesThroughName(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	searchString := chi.URLParam(r, "search")
+	filter, err := parseClothesFilter(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if filter.Search == "" {
+		filter.Search = chi.URLParam(r, "search")
+	}
+	searchString := filter.Search
+
+	currency, rate, ok := a.displayRate(w, r)
+	if !ok {
+		return
+	}
 
-	clothes, err := a.store.Clothes.GetClothesThroughName(ctx, searchString)
+	clothes, err := a.store.Clothes.GetClothesThroughName(ctx, filter)
 	if err != nil {
 		utils.WriteError(w, http.StatusConflict, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, clothes)
+	for i := range clothes {
+		setDisplayPrice(&clothes[i].Clothes, currency, rate)
+	}
+
+	page := types.ClothesSearchPage{Data: clothes}
+	if filter.Facets {
+		page.Facets, err = a.store.Clothes.GetClothesFacets(ctx, filter)
+		if err != nil {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+	}
+
+	if len(clothes) == 0 {
+		page.DidYouMean, err = a.store.Clothes.DidYouMean(ctx, searchString)
+		if err != nil {
+			a.logger.Errorf("Finding a correction for %q: %v", searchString, err)
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, page)
 }
 
+func (a *application) SuggestClothes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	searchString := r.URL.Query().Get("q")
+	if len([]rune(searchString)) < 2 {
+		utils.WriteJSON(w, http.StatusOK, []types.Suggestion{})
+		return
+	}
+
+	suggestions, err := a.store.Clothes.SuggestClothes(ctx, searchString, 8)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, suggestions)
+}
+
+// resyncSlugs brings clothes and category slugs made before Slugify's rules
+// in line with them, once at startup. Old slugs keep redirecting.
+func (a *application) resyncSlugs(ctx context.Context) {
+	for name, resync := range map[string]func(context.Context) (int, error){
+		"clothes":  a.store.Clothes.ResyncClothesSlugs,
+		"category": a.store.Categories.ResyncCategorySlugs,
+	} {
+		changed, err := resync(ctx)
+		if err != nil {
+			a.logger.Errorf("Resyncing %s slugs: %v", name, err)
+		} else if changed > 0 {
+			a.logger.Infof("Resynced %d %s slugs", changed, name)
+		}
+	}
+}
+
+// refreshSearchLexicon keeps did-you-mean up with new and renamed clothes,
+// every searchLexiconInterval until ctx is cancelled.
+func (a *application) refreshSearchLexicon(ctx context.Context) {
+	ticker := time.NewTicker(searchLexiconInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.store.Clothes.RefreshSearchLexicon(ctx); err != nil {
+			a.logger.Errorf("Refreshing the search lexicon: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeleteOneClothing moves the clothing to the trash, see PurgeClothing for
+// deleting it for good.
 func (a *application) DeleteOneClothing(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	idString := chi.URLParam(r, "id")
@@ -128,7 +489,7 @@ func (a *application) DeleteOneClothing(w http.ResponseWriter, r *http.Request)
 
 	clothihgToDelete, err := a.store.Clothes.DeleteClothes(ctx, id)
 	if err != nil {
-		utils.WriteError(w, http.StatusConflict, err)
+		writeTrashError(w, err, "No clothing like this exists!")
 		return
 	}
 
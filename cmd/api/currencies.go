@@ -0,0 +1,137 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+func (a *application) AllCurrencyRoutes(r chi.Router) {
+	r.Get("/", a.GetExchangeRates)
+	r.With(requireAdmin).Put("/{currency}", a.SetExchangeRate)
+	r.With(requireAdmin).Delete("/{currency}", a.DeleteExchangeRate)
+}
+
+func (a *application) GetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := a.store.Currencies.GetExchangeRates(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.ExchangeRates{Base: types.BaseCurrency, Rates: rates})
+}
+
+// SetExchangeRate sets how many base currency units one unit of the
+// currency costs, e.g. {"rate": 1550} for USD.
+func (a *application) SetExchangeRate(w http.ResponseWriter, r *http.Request) {
+	currency, ok := displayCurrency(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.ExchangeRateDTO
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	rate, err := a.store.Currencies.SetExchangeRate(r.Context(), currency, payload.Rate)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, rate)
+}
+
+func (a *application) DeleteExchangeRate(w http.ResponseWriter, r *http.Request) {
+	currency, ok := displayCurrency(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.store.Currencies.DeleteExchangeRate(r.Context(), currency); err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("There's no rate for %s", currency))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, fmt.Sprintf("Prices won't be shown in %s anymore", currency))
+}
+
+// displayCurrency reads the {currency} URL param, which has to be one the
+// storefront supports other than the base currency.
+func displayCurrency(w http.ResponseWriter, r *http.Request) (string, bool) {
+	currency := strings.ToUpper(chi.URLParam(r, "currency"))
+	if _, ok := types.CurrencyExponents[currency]; !ok || currency == types.BaseCurrency {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Rates can only be set for %s", supportedDisplayCurrencies()))
+		return "", false
+	}
+
+	return currency, true
+}
+
+func supportedDisplayCurrencies() string {
+	currencies := []string{}
+	for currency := range types.CurrencyExponents {
+		if currency != types.BaseCurrency {
+			currencies = append(currencies, currency)
+		}
+	}
+
+	slices.Sort(currencies)
+	return strings.Join(currencies, " and ")
+}
+
+// displayRate reads ?currency= and looks up its rate. The rate is 0 when
+// prices stay in the base currency.
+func (a *application) displayRate(w http.ResponseWriter, r *http.Request) (string, float64, bool) {
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency == "" || currency == types.BaseCurrency {
+		return "", 0, true
+	}
+
+	rate, err := a.store.Currencies.GetExchangeRate(r.Context(), currency)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Prices can't be shown in %s", currency))
+			return "", 0, false
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return "", 0, false
+	}
+
+	return currency, rate.Rate, true
+}
+
+// setDisplayPrice fills in the clothing's prices in currency, if one was asked for.
+func setDisplayPrice(clothing *types.Clothes, currency string, rate float64) {
+	if rate == 0 {
+		return
+	}
+
+	price := clothing.EffectivePrice.Convert(currency, rate)
+	clothing.DisplayPrice = &price
+
+	if clothing.Sale != nil {
+		was := clothing.Sale.WasPrice.Convert(currency, rate)
+		clothing.Sale.DisplayWasPrice = &was
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+const (
+	earlyAccessPurpose = "early-access"
+	earlyAccessTTL     = 30 * 24 * time.Hour
+)
+
+var errNoEarlyAccess = errors.New("This access code or link isn't valid")
+
+func earlyAccessUrl(email string) (string, error) {
+	token, err := utils.SignPurposeToken(email, earlyAccessPurpose, earlyAccessTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/early-access?token=%s", APP_URL, token), nil
+}
+
+// earlyAccessMember finds the waitlist member behind an access code or magic
+// link token, returning errNoEarlyAccess when neither checks out.
+func (a *application) earlyAccessMember(ctx context.Context, code string, token string) (*types.Waitlist, error) {
+	var email string
+	if token != "" {
+		var err error
+		if email, err = utils.VerifyPurposeToken(token, earlyAccessPurpose); err != nil {
+			return nil, errNoEarlyAccess
+		}
+	}
+
+	if code == "" && email == "" {
+		return nil, errNoEarlyAccess
+	}
+
+	member, err := a.store.Waitlist.GetEarlyAccessMember(ctx, code, email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errNoEarlyAccess
+		}
+
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// VerifyEarlyAccess lets the storefront check a code or magic link up front,
+// before the member gets as far as ordering.
+func (a *application) VerifyEarlyAccess(w http.ResponseWriter, r *http.Request) {
+	var payload types.EarlyAccessDTO
+
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	member, err := a.earlyAccessMember(r.Context(), payload.Code, payload.Token)
+	if err != nil {
+		if err == errNoEarlyAccess {
+			utils.WriteError(w, http.StatusForbidden, err)
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"name": member.Name, "email": member.Email})
+}
+
+// checkEarlyAccess stops anyone ordering a drop before its public release,
+// unless its early access window is open and they hold a code or magic link.
+// Everyone else gets a countdown to the release. It reports whether the
+// request may go on; if not, the response has been written.
+func (a *application) checkEarlyAccess(w http.ResponseWriter, r *http.Request, clothesIds []int, code string, token string) bool {
+	ctx := r.Context()
+	now := time.Now()
+	var member *types.Waitlist
+
+	for _, id := range clothesIds {
+		release, err := a.store.Clothes.GetClothesRelease(ctx, id)
+		if err != nil {
+			utils.WriteError(w, http.StatusNotAcceptable, err)
+			return false
+		}
+
+		if release.Status != types.ClothesStatusScheduled || release.PublishAt == nil || !now.Before(*release.PublishAt) {
+			continue
+		}
+
+		windowOpen := release.EarlyAccessAt != nil && !now.Before(*release.EarlyAccessAt)
+		if windowOpen && member == nil {
+			member, err = a.earlyAccessMember(ctx, code, token)
+			if err != nil && err != errNoEarlyAccess {
+				utils.WriteError(w, http.StatusConflict, err)
+				return false
+			}
+		}
+
+		if windowOpen && member != nil {
+			continue
+		}
+
+		message := fmt.Sprintf("%s drops on %s", release.Name, release.PublishAt.Format(time.RFC1123))
+		if windowOpen {
+			message = fmt.Sprintf("%s is in early access for waitlist members until %s", release.Name, release.PublishAt.Format(time.RFC1123))
+		}
+
+		utils.WriteJSON(w, http.StatusForbidden, types.Countdown{
+			Error:            message,
+			ClothesId:        release.Id,
+			Name:             release.Name,
+			ReleaseAt:        *release.PublishAt,
+			SecondsRemaining: int64(time.Until(*release.PublishAt).Seconds()),
+			EarlyAccessAt:    release.EarlyAccessAt,
+		})
+		return false
+	}
+
+	return true
+}
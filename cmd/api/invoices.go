@@ -0,0 +1,291 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+const (
+	invoicePurpose = "invoice"
+	invoiceLinkTTL = 365 * 24 * time.Hour
+
+	// invoiceLogoPath is read once, the first time an invoice is rendered.
+	invoiceLogoPath = "public/poohda.png"
+)
+
+var (
+	invoiceGreen = color.RGBA{0x00, 0x80, 0x00, 0xff}
+	invoiceBlack = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	invoiceGrey  = color.RGBA{0x77, 0x77, 0x77, 0xff}
+	invoiceLight = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	invoiceWhite = color.RGBA{0xff, 0xff, 0xff, 0xff}
+
+	invoiceLogo     image.Image
+	invoiceLogoOnce sync.Once
+)
+
+// invoiceNumber formats an order's invoice number the way it's printed.
+func invoiceNumber(number int) string {
+	return fmt.Sprintf("INV-%06d", number)
+}
+
+// invoiceUrl builds the signed link customers can download their invoice from.
+// It's keyed by the order's reference, so the link doesn't give away how many
+// orders there are.
+func invoiceUrl(reference string) (string, error) {
+	token, err := utils.SignPurposeToken(reference, invoicePurpose, invoiceLinkTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/orders/%s/invoice.pdf?token=%s", APP_URL, url.PathEscape(reference), token), nil
+}
+
+// RecordOrderPayment marks an order paid with the payment provider's
+// reference, which gives it its invoice number, and emails the buyer the
+// invoice.
+func (a *application) RecordOrderPayment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	orderId, err := strconv.Atoi(chi.URLParam(r, "order"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	var payload types.OrderPaymentDTO
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	order, err := a.store.Orders.MarkOrderPaid(ctx, orderId, payload.Reference)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No order like this exists"))
+			return
+		}
+
+		// Including store.ErrOrderPaid, so one payment can't be invoiced twice.
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	a.sendOrderStatusSMS(*order, "paid for")
+	a.sendOrderConfirmation(*order)
+
+	utils.WriteJSON(w, http.StatusOK, order)
+}
+
+// GetOrderInvoice serves a paid order's invoice to admins, or to anyone
+// holding the signed link from the payment email.
+func (a *application) GetOrderInvoice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	param := chi.URLParam(r, "reference")
+	if !isAdmin(r) {
+		subject, err := utils.VerifyPurposeToken(r.URL.Query().Get("token"), invoicePurpose)
+		if err != nil || subject != param {
+			utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("This invoice link isn't valid"))
+			return
+		}
+	}
+
+	order, err := a.store.Orders.GetOrderByReference(ctx, param)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No order like this exists"))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	if order.InvoiceNumber == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("This order hasn't been paid for yet, so it has no invoice"))
+		return
+	}
+
+	invoice, err := renderInvoice(*order)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, invoiceNumber(*order.InvoiceNumber)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(invoice)
+}
+
+// loadInvoiceLogo decodes and shrinks the logo. Invoices still render
+// without it if it can't be read.
+func loadInvoiceLogo() image.Image {
+	invoiceLogoOnce.Do(func() {
+		file, err := os.Open(invoiceLogoPath)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+
+		logo, err := png.Decode(file)
+		if err != nil {
+			return
+		}
+
+		// Twice the size it's drawn at keeps it sharp when printed.
+		invoiceLogo = utils.ResizeToFit(logo, 180, 180)
+	})
+
+	return invoiceLogo
+}
+
+// renderInvoice draws a paid order's invoice: the logo and invoice details,
+// who it's for and where it ships, a row per line with its tax, then the
+// totals.
+func renderInvoice(order types.Order) ([]byte, error) {
+	const (
+		left   = 50.0
+		right  = utils.PDFPageWidth - 50
+		bottom = utils.PDFPageHeight - 80
+	)
+
+	amount := func(m types.Money) string {
+		return m.Currency + " " + m.Decimal()
+	}
+
+	pdf := utils.NewPDF()
+	pdf.AddPage()
+
+	if logo := loadInvoiceLogo(); logo != nil {
+		pdf.Image(logo, left, 40, 90, 90)
+	}
+
+	paidAt := order.CreatedAt
+	if order.PaidAt != nil {
+		paidAt = *order.PaidAt
+	}
+
+	pdf.TextRight(right, 70, 26, true, invoiceGreen, "INVOICE")
+	y := 92.0
+	for _, detail := range [][2]string{
+		{"Invoice", invoiceNumber(*order.InvoiceNumber)},
+		{"Date", paidAt.Format("2 January 2006")},
+		{"Order", order.Reference},
+		{"Payment reference", order.PaymentReference},
+	} {
+		pdf.TextRight(right-130, y, 9, false, invoiceGrey, detail[0])
+		pdf.TextRight(right, y, 9, true, invoiceBlack, detail[1])
+		y += 14
+	}
+
+	y = 170
+	pdf.Text(left, y, 9, true, invoiceGrey, "BILLED TO")
+	pdf.Text(300, y, 9, true, invoiceGrey, "SHIPS TO")
+	y += 16
+	billed := []string{order.Name, order.Email, order.Phone}
+	ships := []string{order.Address, order.Country}
+	for i := 0; i < max(len(billed), len(ships)); i++ {
+		if i < len(billed) && billed[i] != "" {
+			pdf.Text(left, y, 10, i == 0, invoiceBlack, billed[i])
+		}
+		if i < len(ships) && ships[i] != "" {
+			pdf.Text(300, y, 10, false, invoiceBlack, ships[i])
+		}
+		y += 14
+	}
+
+	header := func(y float64) {
+		pdf.Rect(left, y-14, right-left, 22, invoiceGreen)
+		pdf.Text(left+8, y+1, 9, true, invoiceWhite, "ITEM")
+		pdf.TextRight(265, y+1, 9, true, invoiceWhite, "QTY")
+		pdf.TextRight(355, y+1, 9, true, invoiceWhite, "UNIT PRICE")
+		pdf.TextRight(445, y+1, 9, true, invoiceWhite, "TAX")
+		pdf.TextRight(right-8, y+1, 9, true, invoiceWhite, "TOTAL")
+	}
+
+	y += 20
+	header(y)
+	y += 28
+
+	for _, line := range order.Lines {
+		if y > bottom {
+			pdf.AddPage()
+			y = 60
+			header(y)
+			y += 28
+		}
+
+		name := []rune(line.Name)
+		for len(name) > 0 && utils.TextWidth(string(name), 9, false) > 160 {
+			name = name[:len(name)-1]
+		}
+		pdf.Text(left+8, y, 9, false, invoiceBlack, string(name))
+		if line.Sku != "" {
+			pdf.Text(left+8, y+11, 7, false, invoiceGrey, line.Sku)
+		}
+		pdf.TextRight(265, y, 9, false, invoiceBlack, strconv.Itoa(line.Quantity))
+		pdf.TextRight(355, y, 9, false, invoiceBlack, amount(line.UnitPrice))
+		pdf.TextRight(445, y, 9, false, invoiceBlack, amount(line.Tax))
+		pdf.TextRight(right-8, y, 9, false, invoiceBlack, amount(line.Total))
+		pdf.Line(left, y+18, right, y+18, 0.5, invoiceLight)
+		y += 28
+	}
+
+	if y > bottom-60 {
+		pdf.AddPage()
+		y = 60
+	}
+
+	taxLabel := fmt.Sprintf("Tax (%s%%)", strconv.FormatFloat(order.TaxRate, 'f', -1, 64))
+	if order.TaxInclusive {
+		taxLabel = fmt.Sprintf("Tax (%s%%, included)", strconv.FormatFloat(order.TaxRate, 'f', -1, 64))
+	}
+
+	y += 6
+	for _, total := range []struct {
+		label string
+		value types.Money
+	}{
+		{"Subtotal", order.Subtotal},
+		{taxLabel, order.Tax},
+	} {
+		pdf.TextRight(445, y, 10, false, invoiceGrey, total.label)
+		pdf.TextRight(right-8, y, 10, false, invoiceBlack, amount(total.value))
+		y += 16
+	}
+
+	pdf.Line(330, y-8, right, y-8, 1, invoiceBlack)
+	y += 6
+	pdf.TextRight(445, y, 12, true, invoiceBlack, "Total paid")
+	pdf.TextRight(right-8, y, 12, true, invoiceGreen, amount(order.Price))
+
+	pdf.Line(left, utils.PDFPageHeight-60, right, utils.PDFPageHeight-60, 0.5, invoiceLight)
+	pdf.Text(left, utils.PDFPageHeight-45, 8, false, invoiceGrey, "Thanks for being Da Difference. PooHDa")
+	pdf.TextRight(right, utils.PDFPageHeight-45, 8, false, invoiceGrey, fmt.Sprintf("Amounts in %s", types.BaseCurrency))
+
+	var out bytes.Buffer
+	if _, err := pdf.WriteTo(&out); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
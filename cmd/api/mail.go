@@ -2,8 +2,11 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -20,8 +23,27 @@ var (
 	content       embed.FS
 	ZOHO_EMAIL    = os.Getenv("ZOHO_EMAIL")
 	ZOHO_PASSWORD = os.Getenv("ZOHO_PASSWORD")
+	APP_URL       = os.Getenv("APP_URL")
 )
 
+// errUnsubscribed is returned by deliverMail when the recipient opted out of
+// the mail's category, so callers can skip them rather than fail.
+var errUnsubscribed = errors.New("Recipient has unsubscribed from these emails")
+
+type outgoingMail struct {
+	To          string
+	Subject     string
+	Body        string
+	Category    string
+	Attachments []mailAttachment
+}
+
+type mailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
 type ImageUrlForMail struct {
 	LogoUrl string
 }
@@ -30,6 +52,28 @@ func (a *application) SendMail(w http.ResponseWriter, r *http.Request) {
 	cwd, _ := os.Getwd()
 	var body bytes.Buffer
 	var payload types.SubscribePayload
+
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot be able to parse json"))
+		return
+	}
+
+	if err := utils.Validator.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		fmt.Printf("Error: %s", errors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload: %v", errors))
+		return
+	}
+
+	// Already validated, so this only rewrites the number into E.164.
+	payload.Number, _ = utils.NormalizePhone(payload.Number)
+
+	// Spam checks go before anything that costs us a Cloudinary call or a mail.
+	if rejection := a.checkSubscribeSpam(r, payload); rejection != nil {
+		a.rejectSubscribe(w, r, payload, rejection)
+		return
+	}
+
 	cld, err := utils.InitializeCloudinary()
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
@@ -45,18 +89,6 @@ func (a *application) SendMail(w http.ResponseWriter, r *http.Request) {
 		LogoUrl: result.URL,
 	}
 
-	if err := utils.ParseJSON(r, &payload); err != nil {
-		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot be able to parse json"))
-		return
-	}
-
-	if err := utils.Validator.Struct(payload); err != nil {
-		errors := err.(validator.ValidationErrors)
-		fmt.Printf("Error: %s", errors)
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload: %v", errors))
-		return
-	}
-
 	tmpl, err := template.ParseFiles(fmt.Sprintf("%s/public/index.html", cwd))
 	if err != nil {
 		log.Fatal("Failed to parse template:", err)
@@ -71,35 +103,58 @@ func (a *application) SendMail(w http.ResponseWriter, r *http.Request) {
 	// a.logger.Info(body.String())
 	chanErr := make(chan error, 1)
 
-	err = a.store.Waitlist.AddToWaitlist(r.Context(), payload)
+	if ref := r.URL.Query().Get("ref"); ref != "" {
+		payload.Ref = ref
+	}
+
+	participant, err := a.store.Waitlist.AddToWaitlist(r.Context(), payload)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid payload: %v", err))
 		return
 	}
 
-	go sendMailGoRoutine(chanErr, payload)
+	// Someone signing up again may type their email differently, the links
+	// have to carry the one on the list.
+	payload.Email = participant.Email
+
+	confirmUrl, err := waitlistConfirmUrl(payload.Email)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Joining the waitlist again is an explicit opt back in to launch news.
+	if err := a.store.Preferences.SetEmailPreference(r.Context(), payload.Email, types.MailCategoryLaunchNews, true); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	go a.sendMailGoRoutine(r.Context(), chanErr, payload, confirmUrl, participant.ReferralCode)
 
 	if err := <-chanErr; err != nil {
 		fmt.Print(err)
 		utils.WriteError(w, http.StatusConflict, err)
 		return
 	} else {
-		utils.WriteJSON(w, http.StatusOK, fmt.Sprintf("%s with email: %s have joined the waitlist", payload.Name, payload.Email))
+		utils.WriteJSON(w, http.StatusOK, fmt.Sprintf("%s with email: %s have joined the waitlist, check your inbox to confirm", payload.Name, payload.Email))
 		a.logger.Info("Sent email.......")
 	}
 }
 
-func sendMailGoRoutine(chanErr chan error, payload types.SubscribePayload) {
-	m := gomail.NewMessage()
-	// cwd, _ := os.Getwd()
-	// poohdaLogo := fmt.Sprintf("%s/public/PoohDa White green.png", cwd)
-	// firstName := strings.Split(payload.Name, " ")
-	m.SetHeader("From", "noreply@poohda.com")
-	m.SetHeader("To", payload.Email)
-	m.SetAddressHeader("Cc", payload.Email, payload.Name)
-	m.SetHeader("Subject", "You’re on the Waitlist to Be Da Difference")
-	// m.SetBody("text/html", body.String())
-	m.SetBody("text/html", `
+func (a *application) sendMailGoRoutine(ctx context.Context, chanErr chan error, payload types.SubscribePayload, confirmUrl string, referralCode string) {
+	unsubscribe, err := unsubscribeUrl(payload.Email, types.MailCategoryLaunchNews)
+	if err != nil {
+		chanErr <- err
+		return
+	}
+
+	preferences, err := preferencesUrl(payload.Email)
+	if err != nil {
+		chanErr <- err
+		return
+	}
+
+	body := fmt.Sprintf(`
 <!DOCTYPE html>
 <html lang="en">
 
@@ -134,6 +189,15 @@ func sendMailGoRoutine(chanErr chan error, payload types.SubscribePayload) {
       <p style="margin-bottom: 16px;">You made it to the waitlist to be Da Difference—the leader of the pack! That means
         you’ll be the first to know when our exclusive pieces go live on the PooHDa website.</p>
 
+      <p style="margin-bottom: 16px;">Confirm your spot so we know it’s really you. This link expires in 72 hours.</p>
+
+      <p style="margin-bottom: 16px; text-align: center;">
+        <a href="%s" style="display: inline-block; padding: 12px 24px; background-color: #008000; color: #ffffff; text-decoration: none; border-radius: 4px; font-family: Helvetica, Arial, sans-serif;">Confirm my spot</a>
+      </p>
+
+      <p style="margin-bottom: 16px;">Want to move up the line? Share your referral code <strong style="color: #008000;">%s</strong>
+        with friends. Every friend who joins with it and confirms bumps you closer to the front.</p>
+
       <p style="margin-bottom: 16px;">So get ready for the launch.</p>
 
       <p style="margin-bottom: 16px;">Every piece is crafted to break the rules and elevate your style—rare, limited,
@@ -152,19 +216,66 @@ func sendMailGoRoutine(chanErr chan error, payload types.SubscribePayload) {
 
     <div style="text-align: center; margin-top: 20px; font-size: 12px; color: #aaa;">
       <p>&copy; 2024 PooHDa. All rights reserved.</p>
+      <p><a href="%s" style="color: #aaa;">Unsubscribe</a> · <a href="%s" style="color: #aaa;">Email preferences</a></p>
     </div>
   </div>
 </body>
 
 </html>
-    `)
+    `, confirmUrl, referralCode, unsubscribe, preferences)
 
-	d := gomail.NewDialer("smtppro.zoho.com", 465, ZOHO_EMAIL, ZOHO_PASSWORD)
+	chanErr <- a.deliverMail(ctx, outgoingMail{
+		To:       payload.Email,
+		Subject:  "You’re on the Waitlist to Be Da Difference",
+		Body:     body,
+		Category: types.MailCategoryLaunchNews,
+	})
+}
+
+// deliverMail checks the recipient still wants mail in this category before
+// handing it to the SMTP server with RFC 8058 one-click unsubscribe headers.
+func (a *application) deliverMail(ctx context.Context, mail outgoingMail) error {
+	subscribed, err := a.store.Preferences.IsSubscribed(ctx, mail.To, mail.Category)
+	if err != nil {
+		return err
+	}
+
+	if !subscribed {
+		return errUnsubscribed
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", "noreply@poohda.com")
+	m.SetHeader("To", mail.To)
+	m.SetHeader("Subject", mail.Subject)
+
+	if mail.Category != "" {
+		unsubscribe, err := unsubscribeUrl(mail.To, mail.Category)
+		if err != nil {
+			return err
+		}
+
+		m.SetHeader("List-Unsubscribe", fmt.Sprintf("<%s>", unsubscribe))
+		m.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
 
+	m.SetBody("text/html", mail.Body)
+
+	for _, attachment := range mail.Attachments {
+		m.Attach(attachment.Filename,
+			gomail.SetHeader(map[string][]string{"Content-Type": {attachment.ContentType}}),
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(attachment.Data)
+				return err
+			}),
+		)
+	}
+
+	d := gomail.NewDialer("smtppro.zoho.com", 465, ZOHO_EMAIL, ZOHO_PASSWORD)
 	if err := d.DialAndSend(m); err != nil {
 		log.Print(err)
-		chanErr <- err
+		return err
 	}
 
-	chanErr <- nil
+	return nil
 }
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+// mediaMaxBytes caps a single upload, MEDIA_MAX_BYTES overrides the 10MB default.
+var mediaMaxBytes = envInt("MEDIA_MAX_BYTES", 10<<20)
+
+// mediaMaxPixels caps an upload's width times height, so a small file that
+// unpacks to a huge image can't exhaust memory when it's resized.
+// MEDIA_MAX_MEGAPIXELS overrides the 40 megapixel default.
+var mediaMaxPixels = envInt("MEDIA_MAX_MEGAPIXELS", 40) * 1000 * 1000
+
+// mediaTypes are the content types we accept, keyed to the extension we save
+// them with. The type is sniffed from the file, not taken from the client.
+var mediaTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// mediaSizes are the boxes local variants are scaled down to fit. Cloudinary
+// makes the same sizes on the fly, see setImageVariants in the store.
+var mediaSizes = []struct {
+	name          string
+	width, height int
+}{
+	{"thumb", 200, 200},
+	{"card", 600, 800},
+	{"zoom", 1600, 1600},
+}
+
+func (a *application) AllMediaRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(requireAdmin)
+		r.Post("/", a.UploadMedia)
+	})
+}
+
+// UploadMedia stores the "file" form field in the configured BlobStore and
+// returns the media row, whose id can then be passed as media_ids when
+// creating clothes or categories.
+func (a *application) UploadMedia(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	http.NewResponseController(w).SetReadDeadline(time.Now().Add(time.Minute))
+	r.Body = http.MaxBytesReader(w, r.Body, int64(mediaMaxBytes))
+
+	upload, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("Files can be at most %d bytes", mediaMaxBytes))
+			return
+		}
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Upload the image as the file field: %v", err))
+		return
+	}
+	defer upload.Close()
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(upload, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Cannot read the upload: %v", err))
+		return
+	}
+
+	contentType := http.DetectContentType(sniff[:n])
+	ext, ok := mediaTypes[contentType]
+	if !ok {
+		utils.WriteError(w, http.StatusUnsupportedMediaType, fmt.Errorf("Only JPEG, PNG and WebP images can be uploaded, got %s", contentType))
+		return
+	}
+
+	if _, err := upload.Seek(0, io.SeekStart); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Only the header is decoded here, WebP has no decoder in the standard
+	// library so its size is left at 0.
+	var width, height int
+	if config, _, err := image.DecodeConfig(upload); err == nil {
+		width, height = config.Width, config.Height
+	}
+
+	if width*height > mediaMaxPixels {
+		utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("Images can be at most %d megapixels, this one is %dx%d", mediaMaxPixels/1000/1000, width, height))
+		return
+	}
+
+	if _, err := upload.Seek(0, io.SeekStart); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	id, err := newMediaId()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	key := id + ext
+	url, err := a.blobs.Put(ctx, key, contentType, upload)
+	if err != nil {
+		a.logger.Errorf("Storing media %s: %v", key, err)
+		utils.WriteError(w, http.StatusBadGateway, fmt.Errorf("Could not store the upload"))
+		return
+	}
+
+	keys := []string{key}
+	var variants *types.ImageVariants
+	if a.blobs.Name() == blobStorageLocal && width > 0 {
+		if _, err := upload.Seek(0, io.SeekStart); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		var variantKeys []string
+		variants, variantKeys, err = a.storeLocalVariants(ctx, id, ext, url, upload)
+		keys = append(keys, variantKeys...)
+		if err != nil {
+			a.removeMedia(keys)
+			a.logger.Errorf("Resizing media %s: %v", key, err)
+			utils.WriteError(w, http.StatusUnprocessableEntity, fmt.Errorf("Could not resize the image: %v", err))
+			return
+		}
+	}
+
+	media, err := a.store.Media.CreateMedia(ctx, types.Media{
+		Id:           id,
+		Storage:      a.blobs.Name(),
+		StorageKey:   key,
+		Url:          url,
+		ContentType:  contentType,
+		Size:         header.Size,
+		OriginalName: header.Filename,
+		Width:        width,
+		Height:       height,
+		Variants:     variants,
+	})
+	if err != nil {
+		// Don't leave orphaned files behind when the row can't be saved.
+		a.removeMedia(keys)
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, media)
+}
+
+// newMediaId returns a random id that is also safe to use as a file name.
+func newMediaId() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// storeLocalVariants scales the upload down to each of mediaSizes and stores
+// them next to the original, as id-thumb.jpg and so on. Sizes the image
+// already fits in just point at the original. There is no WebP encoder in
+// the standard library, so local uploads go without a WebP variant. Callers
+// check the size against mediaMaxPixels before it's decoded here.
+func (a *application) storeLocalVariants(ctx context.Context, id string, ext string, url string, upload io.Reader) (*types.ImageVariants, []string, error) {
+	src, format, err := image.Decode(upload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := []string{}
+	urls := map[string]string{}
+	for _, size := range mediaSizes {
+		resized := utils.ResizeToFit(src, size.width, size.height)
+		if resized == src {
+			urls[size.name] = url
+			continue
+		}
+
+		var buf bytes.Buffer
+		if format == "png" {
+			err = png.Encode(&buf, resized)
+		} else {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+		}
+		if err != nil {
+			return nil, keys, err
+		}
+
+		key := id + "-" + size.name + ext
+		variantUrl, err := a.blobs.Put(ctx, key, "image/"+format, &buf)
+		if err != nil {
+			return nil, keys, err
+		}
+
+		keys = append(keys, key)
+		urls[size.name] = variantUrl
+	}
+
+	return &types.ImageVariants{Thumb: urls["thumb"], Card: urls["card"], Zoom: urls["zoom"]}, keys, nil
+}
+
+// removeMedia deletes stored files after an upload fails part way. It uses a
+// fresh context since the request's may be the reason it failed.
+func (a *application) removeMedia(keys []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, key := range keys {
+		if err := a.blobs.Delete(ctx, key); err != nil {
+			a.logger.Errorf("Removing orphaned media %s: %v", key, err)
+		}
+	}
+}
+
+// editImage parses the image id and ImageDTO shared by the clothes and
+// category image routes and hands them to update with the owner's id.
+func (a *application) editImage(w http.ResponseWriter, r *http.Request, param string, notFound string, update func(context.Context, int, int, types.ImageDTO) (*types.Image, error)) {
+	ownerId, err := strconv.Atoi(chi.URLParam(r, param))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	imageId, err := strconv.Atoi(chi.URLParam(r, "image"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	var payload types.ImageDTO
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	image, err := update(r.Context(), ownerId, imageId, payload)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, errors.New(notFound))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, image)
+}
+
+// EditClothingImage changes the alt text, order, focal point or primary flag
+// of one of the clothing's images.
+func (a *application) EditClothingImage(w http.ResponseWriter, r *http.Request) {
+	a.editImage(w, r, "id", "No image like this on this clothing", a.store.Clothes.UpdateClothesImage)
+}
+
+// EditCategoryImage changes the alt text, order, focal point or primary flag
+// of one of the category's images.
+func (a *application) EditCategoryImage(w http.ResponseWriter, r *http.Request) {
+	a.editImage(w, r, "category", "No image like this on this category", a.store.Categories.UpdateCategoryImage)
+}
@@ -1,6 +1,15 @@
 package api
 
-import "net/http"
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/poohda-go/utils"
+)
 
 func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -17,3 +26,104 @@ func enableCORS(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// adminPurpose scopes login tokens so that links mailed to customers, which
+// are signed with the same key, can never be used as admin credentials.
+const adminPurpose = "admin"
+
+// requireAdmin only lets through requests carrying the bearer token handed
+// out by /auth/login.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("You need to be logged in to do this"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin reports whether the request carries a valid admin bearer token, for
+// routes that admins and customers holding a link can both use.
+func isAdmin(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return false
+	}
+
+	_, err := utils.VerifyPurposeToken(token, adminPurpose)
+	return err == nil
+}
+
+// TRUSTED_PROXIES lists the addresses or CIDR ranges of the proxies in front
+// of the API, comma separated. Only they get to say who the client is.
+var trustedProxies = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+
+func parseTrustedProxies(value string) []netip.Prefix {
+	prefixes := []netip.Prefix{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+
+	return prefixes
+}
+
+func isTrustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// realIP rewrites RemoteAddr to the client's address from X-Forwarded-For or
+// X-Real-IP, but only when the request came through a trusted proxy, since
+// anyone else can set those headers to whatever they like. X-Forwarded-For is
+// read from the right, skipping our own proxies, as everything left of them
+// is up to the client.
+func realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isTrustedProxy(clientIp(r)) {
+			client := ""
+			hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if hop == "" {
+					continue
+				}
+
+				client = hop
+				if !isTrustedProxy(hop) {
+					break
+				}
+			}
+
+			if client == "" {
+				client = strings.TrimSpace(r.Header.Get("X-Real-IP"))
+			}
+
+			if _, err := netip.ParseAddr(client); err == nil {
+				r.RemoteAddr = net.JoinHostPort(client, "0")
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
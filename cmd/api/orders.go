@@ -1,19 +1,112 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"fmt"
+	"html/template"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/poohda-go/types"
 	"github.com/poohda-go/utils"
 )
 
+var orderConfirmationMail = template.Must(template.New("order").Parse(`
+<!DOCTYPE html>
+<html lang="en">
+
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>{{.Subject}}</title>
+</head>
+
+<body style="color: #ffffff;  background-color: #f4f4f4; padding: 10px;">
+  <div style="max-width: 600px; margin: 0 auto; background-color: #000000; padding: 20px; border-radius: 8px; box-shadow: 0 0 10px rgba(0, 0, 0, 0.1);">
+
+    <div style="text-align: center;">
+      <img alt="PoohDa" src="https://res.cloudinary.com/brownson/image/upload/v1734001320/pmbizybnu0aeentwkcza.png"
+        style="width: 300px;  padding: 0px; margin: -50px;" />
+      <h1 style="font-family: Helvetica, Arial, sans-serif; font-size: 30px; margin-top: -50px; color: #008000;">
+        {{if .Paid}}Payment Received{{else}}Order Received{{end}}</h1>
+    </div>
+
+    <div style="line-height: 1.6;">
+      <p style="margin-bottom: 16px;">Hey {{.Order.Name}}!</p>
+
+{{if .Paid}}
+      <p style="margin-bottom: 16px;">We’ve got your payment for order {{.Order.Reference}}, reference {{.Order.PaymentReference}}.
+        Your invoice {{.InvoiceNumber}} is attached, and you can <a href="{{.InvoiceUrl}}" style="color: #008000;">download it again</a> any time.</p>
+{{else}}
+      <p style="margin-bottom: 16px;">We’ve got your order {{.Order.Reference}} and we’re getting it ready. Here’s what you bought.</p>
+{{end}}
+
+      <table style="width: 100%; border-collapse: collapse; font-family: Helvetica, Arial, sans-serif; font-size: 14px;">
+        <tr style="color: #aaa; text-align: left;">
+          <th style="padding: 6px 0;">Item</th>
+          <th style="padding: 6px 0; text-align: right;">Qty</th>
+          <th style="padding: 6px 0; text-align: right;">Price</th>
+          <th style="padding: 6px 0; text-align: right;">Tax</th>
+          <th style="padding: 6px 0; text-align: right;">Total</th>
+        </tr>
+{{range .Order.Lines}}
+        <tr style="border-top: 1px solid #333;">
+          <td style="padding: 6px 0;">{{.Name}}</td>
+          <td style="padding: 6px 0; text-align: right;">{{.Quantity}}</td>
+          <td style="padding: 6px 0; text-align: right;">{{.UnitPrice}}</td>
+          <td style="padding: 6px 0; text-align: right;">{{.Tax}}</td>
+          <td style="padding: 6px 0; text-align: right;">{{.Total}}</td>
+        </tr>
+{{end}}
+        <tr style="border-top: 1px solid #333;">
+          <td colspan="4" style="padding: 6px 0;">Subtotal</td>
+          <td style="padding: 6px 0; text-align: right;">{{.Order.Subtotal}}</td>
+        </tr>
+        <tr>
+          <td colspan="4" style="padding: 6px 0;">Tax ({{.Order.TaxRate}}%{{if .Order.TaxInclusive}}, included in the prices{{end}})</td>
+          <td style="padding: 6px 0; text-align: right;">{{.Order.Tax}}</td>
+        </tr>
+        <tr style="font-weight: bold; color: #008000;">
+          <td colspan="4" style="padding: 6px 0;">Total</td>
+          <td style="padding: 6px 0; text-align: right;">{{.Order.Price}}</td>
+        </tr>
+      </table>
+
+      <p style="margin-top: 16px;">It’s going to {{.Order.Address}}. We’ll let you know once it’s on its way.</p>
+
+      <p style="margin-top: 40px;">
+        <span style="display: block;">Thanks for being Da Difference,</span>
+        <span style="display: block; font-weight: bold;">POOH</span>
+        <span style="display: block;">Creative Director, PooHDa</span>
+      </p>
+    </div>
+
+    <div style="text-align: center; margin-top: 20px; font-size: 12px; color: #aaa;">
+      <p>&copy; 2024 PooHDa. All rights reserved.</p>
+      <p><a href="{{.PreferencesUrl}}" style="color: #aaa;">Email preferences</a></p>
+    </div>
+  </div>
+</body>
+
+</html>
+`))
+
 func (a *application) AllOrdersRoutes(r chi.Router) {
-	r.Get("/", a.GetAllOrders)
+	r.With(requireAdmin).Get("/", a.GetAllOrders)
 	r.Post("/", a.CreateANewOrder)
-	r.Get("/{order}", a.GetASingleOrder)
+	r.Post("/quote", a.QuoteOrder)
+	r.With(requireAdmin).Get("/export", a.ExportOrders)
+	r.With(requireAdmin).Get("/{order}", a.GetASingleOrder)
+	r.Get("/{reference}/invoice.pdf", a.GetOrderInvoice)
+	r.With(requireAdmin).Post("/{order}/payment", a.RecordOrderPayment)
+	r.With(requireAdmin).Post("/{order}/shipped", a.MarkOrderShipped)
+	r.With(requireAdmin).Post("/{order}/delivered", a.MarkOrderDelivered)
+	r.Post("/{reference}/returns", a.CreateReturn)
+	r.With(requireAdmin).Get("/{reference}/returns", a.GetOrderReturns)
 }
 
 func (a *application) GetAllOrders(w http.ResponseWriter, r *http.Request) {
@@ -41,6 +134,16 @@ func (a *application) CreateANewOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Prices are only shown in other currencies, every order settles in the base one.
+	if !payload.Price.IsBaseCurrency() || payload.Price.Amount < 0 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("The order price has to be in %s", types.BaseCurrency))
+		return
+	}
+
+	if payload.Phone != "" {
+		payload.Phone, _ = utils.NormalizePhone(payload.Phone)
+	}
+
 	areThereClothes, err := a.store.Clothes.GetAllClothes()
 	if err != nil {
 		utils.WriteError(w, http.StatusConflict, err)
@@ -52,20 +155,43 @@ func (a *application) CreateANewOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	clothesIds := []int{}
 	for _, clotheId := range payload.ClothesBought {
-		_, err := a.store.Clothes.GetOneClothes(ctx, clotheId.Id)
-		if err != nil {
-			utils.WriteError(w, http.StatusNotAcceptable, err)
+		clothesIds = append(clothesIds, clotheId.Id)
+	}
+
+	// Clothes that aren't out yet can't be quoted, so buyers get their
+	// countdown before the quote is tried.
+	if !a.checkEarlyAccess(w, r, clothesIds, payload.AccessCode, payload.AccessToken) {
+		return
+	}
+
+	quote, err := a.quoteOrder(ctx, payload.Country, payload.ClothesBought)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotAcceptable, fmt.Errorf("One of these clothes doesn't exist"))
 			return
 		}
+
+		utils.WriteError(w, http.StatusNotAcceptable, err)
+		return
 	}
 
-	newOrder, err := a.store.Orders.CreateANewOrder(ctx, payload)
+	// The buyer shouldn't pay a total they weren't shown.
+	if payload.Price.Amount != 0 && payload.Price.Amount != quote.Total.Amount {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("The total for this order is now %s", quote.Total))
+		return
+	}
+
+	newOrder, err := a.store.Orders.CreateANewOrder(ctx, payload, *quote)
 	if err != nil {
 		utils.WriteError(w, http.StatusConflict, err)
 		return
 	}
 
+	a.sendOrderStatusSMS(*newOrder, "received")
+	a.sendOrderConfirmation(*newOrder)
+
 	utils.WriteJSON(w, http.StatusCreated, newOrder)
 }
 
@@ -86,3 +212,193 @@ func (a *application) GetASingleOrder(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteJSON(w, http.StatusAccepted, order)
 }
+
+// MarkOrderShipped records that the order has gone out with the courier's
+// tracking number, which buyers then see when tracking it.
+func (a *application) MarkOrderShipped(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(chi.URLParam(r, "order"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	var payload types.OrderShipmentDTO
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	order, err := a.store.Orders.MarkOrderShipped(r.Context(), orderId, payload.TrackingNumber)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No order like this exists"))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	a.sendOrderStatusSMS(*order, "shipped, tracking number "+order.TrackingNumber)
+	utils.WriteJSON(w, http.StatusOK, order)
+}
+
+// MarkOrderDelivered records that the buyer has the order, from when on it
+// can be returned.
+func (a *application) MarkOrderDelivered(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(chi.URLParam(r, "order"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	order, err := a.store.Orders.MarkOrderDelivered(r.Context(), orderId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No order like this exists"))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	a.sendOrderStatusSMS(*order, "delivered")
+	utils.WriteJSON(w, http.StatusOK, order)
+}
+
+// sendOrderStatusSMS texts the buyer about their order if they opted in. It
+// runs in the background so a slow provider never holds up the request.
+func (a *application) sendOrderStatusSMS(order types.Order, status string) {
+	if !order.SmsOptIn || order.Phone == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		message := fmt.Sprintf("Hi %s, your PooHDa order %s has been %s. Thanks for being Da Difference!", order.Name, order.Reference, status)
+		if err := a.sms.SendSMS(ctx, order.Phone, message); err != nil {
+			a.logger.Errorf("Texting order %d: %v", order.Id, err)
+		}
+	}()
+}
+
+// sendOrderConfirmation emails the buyer what they ordered and the tax on it,
+// if they gave an email. Once paid it sends the payment confirmation with the
+// invoice attached instead. Like the SMS it runs in the background.
+func (a *application) sendOrderConfirmation(order types.Order) {
+	if order.Email == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := a.sendOrderMail(ctx, order); err != nil {
+			a.logger.Errorf("Emailing order %d: %v", order.Id, err)
+		}
+	}()
+}
+
+func (a *application) sendOrderMail(ctx context.Context, order types.Order) error {
+	var body bytes.Buffer
+	// The confirmation, and once paid the receipt with its invoice, are
+	// transactional, so they have no category to unsubscribe from and go out
+	// whatever the buyer's preferences are.
+	mail := outgoingMail{
+		To:      order.Email,
+		Subject: fmt.Sprintf("Your PooHDa order %s", order.Reference),
+	}
+
+	preferences, err := preferencesUrl(order.Email)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]any{
+		"Order":          order,
+		"Paid":           order.InvoiceNumber != nil,
+		"PreferencesUrl": preferences,
+	}
+
+	if order.InvoiceNumber != nil {
+		invoice, err := renderInvoice(order)
+		if err != nil {
+			return err
+		}
+
+		if data["InvoiceUrl"], err = invoiceUrl(order.Reference); err != nil {
+			return err
+		}
+
+		number := invoiceNumber(*order.InvoiceNumber)
+		data["InvoiceNumber"] = number
+		mail.Subject = fmt.Sprintf("Payment received for your PooHDa order %s", order.Reference)
+		mail.Attachments = append(mail.Attachments, mailAttachment{Filename: number + ".pdf", ContentType: "application/pdf", Data: invoice})
+	}
+
+	data["Subject"] = mail.Subject
+	if err := orderConfirmationMail.Execute(&body, data); err != nil {
+		return err
+	}
+
+	mail.Body = body.String()
+	return a.deliverMail(ctx, mail)
+}
+
+// ExportOrders streams a row per order line as CSV (the default) or XLSX,
+// with the line's tax and the order's totals, optionally limited to orders
+// placed between the from and to dates inclusive. Amounts are in kobo.
+func (a *application) ExportOrders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	export := exportFile{
+		Name:   "orders",
+		Sheet:  "Orders",
+		Header: []string{"order_id", "reference", "created_at", "name", "email", "country", "sku", "clothing", "quantity", "unit_price", "line_tax", "line_total", "tax_rate", "tax_inclusive", "order_subtotal", "order_tax", "order_total"},
+	}
+
+	a.streamExport(w, r.URL.Query().Get("format"), export, func(write func([]string) error) error {
+		return a.store.Orders.StreamOrders(ctx, from, to, func(order types.Order) error {
+			for _, line := range order.Lines {
+				err := write([]string{
+					strconv.Itoa(order.Id),
+					order.Reference,
+					order.CreatedAt.Format(time.RFC3339),
+					order.Name,
+					order.Email,
+					order.Country,
+					line.Sku,
+					line.Name,
+					strconv.Itoa(line.Quantity),
+					strconv.FormatInt(line.UnitPrice.Amount, 10),
+					strconv.FormatInt(line.Tax.Amount, 10),
+					strconv.FormatInt(line.Total.Amount, 10),
+					strconv.FormatFloat(order.TaxRate, 'f', -1, 64),
+					strconv.FormatBool(order.TaxInclusive),
+					strconv.FormatInt(order.Subtotal.Amount, 10),
+					strconv.FormatInt(order.Tax.Amount, 10),
+					strconv.FormatInt(order.Price.Amount, 10),
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+}
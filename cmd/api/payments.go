@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/poohda-go/types"
+	"go.uber.org/zap"
+)
+
+var (
+	PAYMENT_PROVIDER    = os.Getenv("PAYMENT_PROVIDER")
+	PAYSTACK_BASE_URL   = os.Getenv("PAYSTACK_BASE_URL")
+	PAYSTACK_SECRET_KEY = os.Getenv("PAYSTACK_SECRET_KEY")
+)
+
+// PaymentProvider moves money back to buyers. Reference is the payment's
+// reference recorded on the order, and the returned string is the refund's.
+// Key identifies the refund on our side, so FindRefund can tell whether a
+// refund whose outcome got lost actually went through.
+type PaymentProvider interface {
+	Refund(ctx context.Context, reference string, amount types.Money, key string) (string, error)
+	FindRefund(ctx context.Context, reference string, key string) (string, bool, error)
+}
+
+// errRefundDeclined is wrapped by Refund when the provider answered and
+// turned the refund down. Any other error leaves the outcome unknown.
+var errRefundDeclined = errors.New("The payment provider declined the refund")
+
+// newPaymentProvider picks the provider from PAYMENT_PROVIDER. The fake has
+// to be asked for by name, so a missing setting refuses refunds rather than
+// pretending to make them.
+func newPaymentProvider(logger *zap.SugaredLogger) PaymentProvider {
+	switch PAYMENT_PROVIDER {
+	case "paystack":
+		baseUrl := PAYSTACK_BASE_URL
+		if baseUrl == "" {
+			baseUrl = "https://api.paystack.co"
+		}
+
+		return &paystackProvider{
+			url:       baseUrl + "/refund",
+			secretKey: PAYSTACK_SECRET_KEY,
+			client:    &http.Client{Timeout: 20 * time.Second},
+		}
+	case "fake":
+		return &fakePaymentProvider{logger: logger, refunds: map[string]string{}}
+	default:
+		logger.Errorf("PAYMENT_PROVIDER is %q, refunds are off until it's paystack or fake", PAYMENT_PROVIDER)
+		return &unconfiguredPaymentProvider{}
+	}
+}
+
+// paystackProvider refunds through Paystack, which takes amounts in kobo.
+type paystackProvider struct {
+	url       string
+	secretKey string
+	client    *http.Client
+}
+
+func (p *paystackProvider) Refund(ctx context.Context, reference string, amount types.Money, key string) (string, error) {
+	payload, err := json.Marshal(map[string]any{
+		"transaction":   reference,
+		"amount":        amount.Amount,
+		"currency":      types.BaseCurrency,
+		"merchant_note": key,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.secretKey)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		Status  bool   `json:"status"`
+		Message string `json:"message"`
+		Data    struct {
+			Id int64 `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("Payment provider responded %d with an unreadable body: %v", res.StatusCode, err)
+	}
+
+	// Paystack's 5xx could have come after the refund was made, so only its
+	// 4xx count as turning it down.
+	if res.StatusCode >= 400 && res.StatusCode < 500 {
+		return "", fmt.Errorf("%w: %s", errRefundDeclined, body.Message)
+	}
+
+	if res.StatusCode >= 300 || !body.Status {
+		return "", fmt.Errorf("Payment provider responded %d: %s", res.StatusCode, body.Message)
+	}
+
+	return strconv.FormatInt(body.Data.Id, 10), nil
+}
+
+// FindRefund looks through the payment's refunds for the one sent with key.
+func (p *paystackProvider) FindRefund(ctx context.Context, reference string, key string) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url+"?"+url.Values{"transaction": {reference}}.Encode(), nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.secretKey)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		Status  bool   `json:"status"`
+		Message string `json:"message"`
+		Data    []struct {
+			Id           int64  `json:"id"`
+			MerchantNote string `json:"merchant_note"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", false, fmt.Errorf("Payment provider responded %d with an unreadable body: %v", res.StatusCode, err)
+	}
+
+	if res.StatusCode >= 300 || !body.Status {
+		return "", false, fmt.Errorf("Payment provider responded %d: %s", res.StatusCode, body.Message)
+	}
+
+	for _, refund := range body.Data {
+		if refund.MerchantNote == key {
+			return strconv.FormatInt(refund.Id, 10), true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// fakePaymentProvider only logs, for development and tests. It remembers its
+// refunds by key so FindRefund behaves like the real thing.
+type fakePaymentProvider struct {
+	logger  *zap.SugaredLogger
+	mu      sync.Mutex
+	refunds map[string]string
+}
+
+func (p *fakePaymentProvider) Refund(ctx context.Context, reference string, amount types.Money, key string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.logger.Infof("Refund of %s on payment %s", amount, reference)
+	refund := fmt.Sprintf("fake-refund-%d", time.Now().UnixNano())
+	p.refunds[reference+"/"+key] = refund
+	return refund, nil
+}
+
+func (p *fakePaymentProvider) FindRefund(ctx context.Context, reference string, key string) (string, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	refund, ok := p.refunds[reference+"/"+key]
+	return refund, ok, nil
+}
+
+// unconfiguredPaymentProvider stands in when PAYMENT_PROVIDER isn't set to
+// anything we know, and refuses every refund.
+type unconfiguredPaymentProvider struct{}
+
+var errNoPaymentProvider = errors.New("No payment provider is configured, so refunds can't be made")
+
+func (p *unconfiguredPaymentProvider) Refund(ctx context.Context, reference string, amount types.Money, key string) (string, error) {
+	return "", fmt.Errorf("%w: %w", errRefundDeclined, errNoPaymentProvider)
+}
+
+func (p *unconfiguredPaymentProvider) FindRefund(ctx context.Context, reference string, key string) (string, bool, error) {
+	return "", false, errNoPaymentProvider
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+// emailPreferencesPurpose tokens never expire, an unsubscribe link in an old
+// email has to keep working.
+const emailPreferencesPurpose = "email-preferences"
+
+// unsubscribePage asks before unsubscribing, since mail scanners and link
+// prefetchers follow GET links on their own. The button posts back to the
+// same URL.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`
+<!DOCTYPE html>
+<html lang="en">
+
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>Unsubscribe</title>
+</head>
+
+<body style="color: #ffffff;  background-color: #f4f4f4; padding: 10px;">
+  <div style="max-width: 600px; margin: 0 auto; background-color: #000000; padding: 20px; border-radius: 8px; text-align: center; font-family: Helvetica, Arial, sans-serif;">
+    <h1 style="font-size: 26px; color: #008000;">Unsubscribe</h1>
+    <p style="margin-bottom: 16px;">Stop sending {{if .Category}}{{.Category}} emails{{else}}all emails{{end}} to {{.Email}}?</p>
+    <form method="POST" action="{{.Action}}">
+      <button type="submit" style="padding: 12px 24px; background-color: #008000; color: #ffffff; border: none; border-radius: 4px; font-size: 16px;">Unsubscribe</button>
+    </form>
+  </div>
+</body>
+
+</html>
+`))
+
+func (a *application) AllEmailRoutes(r chi.Router) {
+	r.Get("/unsubscribe", a.ConfirmUnsubscribe)
+	r.Post("/unsubscribe", a.Unsubscribe)
+	r.Get("/preferences", a.GetEmailPreferences)
+	r.Put("/preferences", a.UpdateEmailPreferences)
+}
+
+// ConfirmUnsubscribe is where the footer link lands. It changes nothing,
+// only showing the button that posts to Unsubscribe.
+func (a *application) ConfirmUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	email, err := utils.VerifyPurposeToken(r.URL.Query().Get("token"), emailPreferencesPurpose)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	unsubscribePage.Execute(w, map[string]string{
+		"Email":    email,
+		"Category": r.URL.Query().Get("category"),
+		"Action":   r.URL.RequestURI(),
+	})
+}
+
+// Unsubscribe handles both the RFC 8058 one-click POST sent by mail clients
+// and the confirmation page's button, opting out of a single category or of
+// everything.
+func (a *application) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	email, err := utils.VerifyPurposeToken(r.URL.Query().Get("token"), emailPreferencesPurpose)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	category := r.URL.Query().Get("category")
+	if category == "" {
+		off := false
+		_, err = a.store.Preferences.UpdateEmailPreferences(ctx, email, types.EmailPreferencesDTO{
+			LaunchNews: &off,
+			Promotions: &off,
+		})
+	} else {
+		err = a.store.Preferences.SetEmailPreference(ctx, email, category, false)
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, fmt.Sprintf("%s has been unsubscribed", email))
+}
+
+func (a *application) GetEmailPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	email, err := utils.VerifyPurposeToken(r.URL.Query().Get("token"), emailPreferencesPurpose)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	preferences, err := a.store.Preferences.GetEmailPreferences(ctx, email)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, preferences)
+}
+
+func (a *application) UpdateEmailPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var payload types.EmailPreferencesDTO
+	email, err := utils.VerifyPurposeToken(r.URL.Query().Get("token"), emailPreferencesPurpose)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	preferences, err := a.store.Preferences.UpdateEmailPreferences(ctx, email, payload)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, preferences)
+}
+
+func unsubscribeUrl(email string, category string) (string, error) {
+	token, err := utils.SignPurposeToken(email, emailPreferencesPurpose, 0)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{"token": {token}}
+	if category != "" {
+		query.Set("category", category)
+	}
+
+	return fmt.Sprintf("%s/email/unsubscribe?%s", APP_URL, query.Encode()), nil
+}
+
+func preferencesUrl(email string) (string, error) {
+	token, err := utils.SignPurposeToken(email, emailPreferencesPurpose, 0)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/email/preferences?token=%s", APP_URL, token), nil
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/poohda-go/types"
+)
+
+// maxPublishSleep bounds how long the scheduler sleeps, in case a schedule
+// was changed without waking it, e.g. straight in the database.
+const maxPublishSleep = 10 * time.Minute
+
+func (a *application) wakePublisher() {
+	select {
+	case a.publishWake <- struct{}{}:
+	default:
+	}
+}
+
+// publishScheduledClothes opens early access windows and flips scheduled
+// clothes live. Rather than polling it sleeps until the next early_access_at
+// or publish_at, and is woken whenever a schedule changes. The shop already shows scheduled clothes once their time passes,
+// so this mostly keeps statuses honest and starts launch campaigns.
+func (a *application) publishScheduledClothes(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-a.publishWake:
+		}
+
+		earlyAccess, err := a.store.Clothes.StartDueEarlyAccess(ctx)
+		if err != nil {
+			a.logger.Errorf("Starting early access: %v", err)
+		}
+
+		earlyLaunches := []string{}
+		for _, clothing := range earlyAccess {
+			a.logger.Infof("Early access opened for %s (%d) until %s", clothing.Name, clothing.Id, clothing.PublishAt)
+			if clothing.LaunchCampaign {
+				earlyLaunches = append(earlyLaunches, clothing.Name)
+			}
+		}
+
+		if len(earlyLaunches) > 0 {
+			a.startLaunchCampaign(ctx, types.CampaignTemplateEarlyAccess, earlyLaunches)
+		}
+
+		published, err := a.store.Clothes.PublishDueClothes(ctx)
+		if err != nil {
+			a.logger.Errorf("Publishing scheduled clothes: %v", err)
+		}
+
+		for _, clothing := range published {
+			a.logger.Infof("Published %s (%d), scheduled for %s", clothing.Name, clothing.Id, clothing.PublishAt)
+		}
+
+		// Clothes published straight away launch here too, not just the
+		// scheduled ones flipped above.
+		due, err := a.store.Clothes.StartDueLaunches(ctx)
+		if err != nil {
+			a.logger.Errorf("Starting launch campaigns: %v", err)
+		}
+
+		launches := []string{}
+		for _, clothing := range due {
+			launches = append(launches, clothing.Name)
+		}
+
+		if len(launches) > 0 {
+			a.startLaunchCampaign(ctx, types.CampaignTemplateLaunch, launches)
+		}
+
+		sleep := maxPublishSleep
+		next, err := a.store.Clothes.NextPublishAt(ctx)
+		if err != nil {
+			a.logger.Errorf("Finding the next scheduled clothes: %v", err)
+		} else if next != nil && time.Until(*next) < sleep {
+			sleep = max(time.Until(*next), 0)
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(sleep)
+	}
+}
+
+// startLaunchCampaign mails the confirmed waitlist about a drop, one campaign
+// for everything that went live, or opened for early access, together.
+func (a *application) startLaunchCampaign(ctx context.Context, template string, names []string) {
+	name, subject := "Drop", "It’s Live: Da Difference Has Dropped"
+	if template == types.CampaignTemplateEarlyAccess {
+		name, subject = "Early access", "You’re First: Early Access to the New Drop"
+	}
+
+	campaign, err := a.store.Campaigns.CreateCampaign(ctx, types.CampaignDTO{
+		Name:     fmt.Sprintf("%s: %s", name, strings.Join(names, ", ")),
+		Template: template,
+		Subject:  subject,
+		Segment:  types.CampaignSegmentConfirmed,
+	})
+	if err != nil {
+		a.logger.Errorf("Creating launch campaign for %v: %v", names, err)
+		return
+	}
+
+	a.logger.Infof("Started launch campaign %d for %v", campaign.Id, names)
+	a.wakeCampaigns()
+}
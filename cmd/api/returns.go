@@ -0,0 +1,332 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+func (a *application) AllReturnRoutes(r chi.Router) {
+	r.Use(requireAdmin)
+	r.Get("/", a.GetAllReturns)
+	r.Get("/{return}", a.GetOneReturn)
+	r.Post("/{return}/approve", a.ApproveReturn)
+	r.Post("/{return}/reject", a.RejectReturn)
+	r.Post("/{return}/receive", a.ReceiveReturn)
+	r.Post("/{return}/refund", a.RefundReturn)
+}
+
+// CreateReturn opens a return against lines of a delivered order. Admins can
+// open one for any order, customers have to give the email or phone number
+// the order was placed with, and share TrackOrder's per IP limit on guesses.
+func (a *application) CreateReturn(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !isAdmin(r) && !a.trackIpLimiter.Allow(clientIp(r)) {
+		utils.WriteError(w, http.StatusTooManyRequests, fmt.Errorf("Too many lookups, please try again later"))
+		return
+	}
+
+	var payload types.ReturnDTO
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	order, err := a.store.Orders.GetOrderByReference(ctx, chi.URLParam(r, "reference"))
+	if err != nil && err != sql.ErrNoRows {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	openedBy := types.ReturnOpenedByAdmin
+	if !isAdmin(r) {
+		openedBy = types.ReturnOpenedByCustomer
+
+		// A wrong email gets the same answer as a missing order, so the
+		// endpoint can't be used to find out which orders exist.
+		if order != nil && !ownsOrder(*order, payload.Email, payload.Phone) {
+			order = nil
+		}
+	}
+
+	if order == nil {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No order like this exists"))
+		return
+	}
+
+	item, err := a.store.Returns.CreateReturn(ctx, order.Id, payload, openedBy)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No order like this exists"))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, item)
+}
+
+// ownsOrder reports whether email or phone is the one the order was placed
+// with.
+func ownsOrder(order types.Order, email string, phone string) bool {
+	if email != "" && order.Email != "" && strings.EqualFold(email, order.Email) {
+		return true
+	}
+
+	if phone != "" && order.Phone != "" {
+		normalized, err := utils.NormalizePhone(phone)
+		return err == nil && normalized == order.Phone
+	}
+
+	return false
+}
+
+func (a *application) GetOrderReturns(w http.ResponseWriter, r *http.Request) {
+	order, err := a.store.Orders.GetOrderByReference(r.Context(), chi.URLParam(r, "reference"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No order like this exists"))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	returns, err := a.store.Returns.GetReturns(r.Context(), order.Id, "")
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, returns)
+}
+
+// GetAllReturns lists returns newest first, optionally only those at
+// ?status=.
+func (a *application) GetAllReturns(w http.ResponseWriter, r *http.Request) {
+	returns, err := a.store.Returns.GetReturns(r.Context(), 0, r.URL.Query().Get("status"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, returns)
+}
+
+func (a *application) GetOneReturn(w http.ResponseWriter, r *http.Request) {
+	returnId, ok := returnParam(w, r)
+	if !ok {
+		return
+	}
+
+	item, err := a.store.Returns.GetOneReturn(r.Context(), returnId)
+	writeReturn(w, item, err)
+}
+
+func (a *application) ApproveReturn(w http.ResponseWriter, r *http.Request) {
+	a.moveReturn(w, r, a.store.Returns.ApproveReturn)
+}
+
+func (a *application) RejectReturn(w http.ResponseWriter, r *http.Request) {
+	a.moveReturn(w, r, a.store.Returns.RejectReturn)
+}
+
+// ReceiveReturn records the clothes arriving back, which restocks them.
+func (a *application) ReceiveReturn(w http.ResponseWriter, r *http.Request) {
+	a.moveReturn(w, r, a.store.Returns.ReceiveReturn)
+}
+
+// moveReturn reads the optional note and moves the return on with move.
+func (a *application) moveReturn(w http.ResponseWriter, r *http.Request, move func(ctx context.Context, id int, note string) (*types.Return, error)) {
+	returnId, ok := returnParam(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.ReturnDecisionDTO
+	if r.ContentLength != 0 {
+		if err := utils.ParseJSON(r, &payload); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	item, err := move(r.Context(), returnId, payload.Note)
+	writeReturn(w, item, err)
+}
+
+// refundSettleTime is how long a refund is left to come back from the
+// payment provider before a retry asks the provider what became of it.
+const refundSettleTime = time.Minute
+
+// RefundReturn pays a received return back through the payment provider,
+// either in full or the amount given. Every refund is sent with the return's
+// key, so when one's outcome was lost, trying again checks with the provider
+// before anything is sent a second time.
+func (a *application) RefundReturn(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	returnId, ok := returnParam(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.RefundDTO
+	if r.ContentLength != 0 {
+		if err := utils.ParseJSON(r, &payload); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	item, err := a.store.Returns.GetOneReturn(ctx, returnId)
+	if err != nil {
+		writeReturn(w, nil, err)
+		return
+	}
+
+	order, err := a.store.Orders.GetASingleOrder(ctx, item.OrderId)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	if order.PaymentReference == "" {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("This order was never paid for, so there's nothing to refund"))
+		return
+	}
+
+	refundNote := func(amount types.Money) string {
+		note := fmt.Sprintf("%s refunded", amount)
+		if payload.Note != "" {
+			note += ", " + payload.Note
+		}
+
+		return note
+	}
+
+	// Once the provider has been asked, the outcome has to be recorded even
+	// if the admin's connection drops.
+	ctx = context.WithoutCancel(ctx)
+	key := fmt.Sprintf("return-%d", returnId)
+
+	if item.Status == types.ReturnStatusRefunding {
+		if time.Since(item.UpdatedAt) < refundSettleTime {
+			utils.WriteError(w, http.StatusConflict, fmt.Errorf("A refund for this return is still going through, try again in a minute"))
+			return
+		}
+
+		reference, found, err := a.payments.FindRefund(ctx, order.PaymentReference, key)
+		if err != nil {
+			a.logger.Errorf("Checking on the refund for return %d: %v", returnId, err)
+			utils.WriteError(w, http.StatusBadGateway, err)
+			return
+		}
+
+		if found && item.RefundAmount != nil {
+			item, err = a.store.Returns.FinishRefund(ctx, returnId, reference, refundNote(*item.RefundAmount))
+			writeReturn(w, item, err)
+			return
+		}
+
+		// The provider never got it, so it's safe to send afresh.
+		if _, err := a.store.Returns.CancelRefund(ctx, returnId); err != nil {
+			writeReturn(w, nil, err)
+			return
+		}
+	}
+
+	amount := item.Amount
+	if payload.Amount != nil {
+		amount = *payload.Amount
+	}
+
+	if !amount.IsBaseCurrency() || amount.Amount <= 0 || amount.Amount > item.Amount.Amount {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("The refund has to be more than 0 and at most %s", item.Amount))
+		return
+	}
+	amount = types.NewMoney(amount.Amount)
+
+	if _, err := a.store.Returns.StartRefund(ctx, returnId, amount); err != nil {
+		writeReturn(w, nil, err)
+		return
+	}
+
+	reference, err := a.payments.Refund(ctx, order.PaymentReference, amount, key)
+	if err != nil {
+		a.logger.Errorf("Refunding return %d: %v", returnId, err)
+
+		// Without an answer the money may have gone, so the return stays
+		// refunding until a retry has checked.
+		if !errors.Is(err, errRefundDeclined) {
+			utils.WriteError(w, http.StatusBadGateway, fmt.Errorf("The payment provider didn't confirm the refund, try again in a minute to check on it"))
+			return
+		}
+
+		if _, cancelErr := a.store.Returns.CancelRefund(ctx, returnId); cancelErr != nil {
+			a.logger.Errorf("Putting return %d back after a failed refund: %v", returnId, cancelErr)
+		}
+
+		utils.WriteError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	item, err = a.store.Returns.FinishRefund(ctx, returnId, reference, refundNote(amount))
+	if err != nil {
+		// The money has gone, so this needs someone to look at it.
+		a.logger.Errorf("Recording refund %s for return %d: %v", reference, returnId, err)
+	}
+
+	writeReturn(w, item, err)
+}
+
+func returnParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	returnId, err := strconv.Atoi(chi.URLParam(r, "return"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return 0, false
+	}
+
+	return returnId, true
+}
+
+func writeReturn(w http.ResponseWriter, item *types.Return, err error) {
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No return like this exists"))
+			return
+		}
+
+		// Including the store's ErrReturnStage.
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, item)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/utils"
+)
+
+type slugResolver func(ctx context.Context, slug string) (int, string, error)
+
+// idOrSlug reads the param URL segment, which can be an id or a slug. An old
+// slug is answered with a 301 to the same URL under the current slug, in
+// which case ok is false and the response has already been written.
+func idOrSlug(w http.ResponseWriter, r *http.Request, param string, resolve slugResolver, notFound string) (int, bool) {
+	value := chi.URLParam(r, param)
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, true
+	}
+
+	id, slug, err := resolve(r.Context(), value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, errors.New(notFound))
+			return 0, false
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return 0, false
+	}
+
+	if slug != value {
+		location := *r.URL
+		segments := strings.Split(location.Path, "/")
+		for i, segment := range segments {
+			if segment == value {
+				segments[i] = slug
+			}
+		}
+		location.Path = strings.Join(segments, "/")
+		location.RawPath = ""
+
+		http.Redirect(w, r, location.String(), http.StatusMovedPermanently)
+		return 0, false
+	}
+
+	return id, true
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	SMS_PROVIDER     = os.Getenv("SMS_PROVIDER")
+	TERMII_BASE_URL  = os.Getenv("TERMII_BASE_URL")
+	TERMII_API_KEY   = os.Getenv("TERMII_API_KEY")
+	TERMII_SENDER_ID = os.Getenv("TERMII_SENDER_ID")
+)
+
+// SMSSender delivers a text message to an E.164 phone number.
+type SMSSender interface {
+	SendSMS(ctx context.Context, to string, message string) error
+}
+
+// newSMSSender picks the provider from SMS_PROVIDER, falling back to the
+// fake so local development never texts real people.
+func newSMSSender(logger *zap.SugaredLogger) SMSSender {
+	switch SMS_PROVIDER {
+	case "termii":
+		baseUrl := TERMII_BASE_URL
+		if baseUrl == "" {
+			baseUrl = "https://api.ng.termii.com"
+		}
+
+		return &httpSMSSender{
+			url:      baseUrl + "/api/sms/send",
+			apiKey:   TERMII_API_KEY,
+			senderId: TERMII_SENDER_ID,
+			client:   &http.Client{Timeout: 10 * time.Second},
+		}
+	default:
+		return &fakeSMSSender{logger: logger}
+	}
+}
+
+// httpSMSSender talks to a Termii style JSON API.
+type httpSMSSender struct {
+	url      string
+	apiKey   string
+	senderId string
+	client   *http.Client
+}
+
+func (s *httpSMSSender) SendSMS(ctx context.Context, to string, message string) error {
+	payload, err := json.Marshal(map[string]string{
+		"api_key": s.apiKey,
+		"to":      to,
+		"from":    s.senderId,
+		"sms":     message,
+		"type":    "plain",
+		"channel": "generic",
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		var body struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(res.Body).Decode(&body)
+		return fmt.Errorf("SMS provider responded %d: %s", res.StatusCode, body.Message)
+	}
+
+	return nil
+}
+
+// fakeSMSSender only logs, for development and tests.
+type fakeSMSSender struct {
+	logger *zap.SugaredLogger
+}
+
+func (s *fakeSMSSender) SendSMS(ctx context.Context, to string, message string) error {
+	s.logger.Infof("SMS to %s: %s", to, message)
+	return nil
+}
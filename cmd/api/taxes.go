@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+func (a *application) AllTaxRoutes(r chi.Router) {
+	r.Get("/", a.GetTaxRates)
+	r.With(requireAdmin).Put("/{country}", a.SetTaxRate)
+	r.With(requireAdmin).Delete("/{country}", a.DeleteTaxRate)
+}
+
+func (a *application) GetTaxRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := a.store.Taxes.GetTaxRates(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, rates)
+}
+
+// SetTaxRate sets the tax on orders shipping to a country, e.g.
+// {"name": "VAT", "rate": 7.5, "inclusive": true} for NG.
+func (a *application) SetTaxRate(w http.ResponseWriter, r *http.Request) {
+	country, ok := taxCountry(w, r)
+	if !ok {
+		return
+	}
+
+	var payload types.TaxRateDTO
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.ValidateJson(payload); err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	rate, err := a.store.Taxes.SetTaxRate(r.Context(), country, payload)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, rate)
+}
+
+func (a *application) DeleteTaxRate(w http.ResponseWriter, r *http.Request) {
+	country, ok := taxCountry(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.store.Taxes.DeleteTaxRate(r.Context(), country); err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("There's no tax rate for %s", country))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, fmt.Sprintf("Orders shipping to %s won't be taxed anymore", country))
+}
+
+// taxCountry reads the {country} URL param as an ISO 3166 alpha-2 code.
+func taxCountry(w http.ResponseWriter, r *http.Request) (string, bool) {
+	country := strings.ToUpper(chi.URLParam(r, "country"))
+	if err := utils.Validator.Var(country, "iso3166_1_alpha2"); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("%q isn't a two letter country code", country))
+		return "", false
+	}
+
+	return country, true
+}
+
+// QuoteOrder works out what an order comes to, tax included, without
+// placing it.
+func (a *application) QuoteOrder(w http.ResponseWriter, r *http.Request) {
+	var payload types.OrderDTO
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(payload.ClothesBought) == 0 {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Add some clothes to quote"))
+		return
+	}
+
+	for _, item := range payload.ClothesBought {
+		if err := utils.ValidateJson(item); err != nil {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+	}
+
+	if err := utils.Validator.Var(payload.Country, "omitempty,iso3166_1_alpha2"); err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("country has to be a two letter country code"))
+		return
+	}
+
+	quote, err := a.quoteOrder(r.Context(), payload.Country, payload.ClothesBought)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, http.StatusNotAcceptable, fmt.Errorf("One of these clothes doesn't exist"))
+			return
+		}
+
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, quote)
+}
+
+// quoteOrder prices each clothing at what it sells for now and taxes it at
+// the rate for country. Countries without a rate aren't taxed.
+func (a *application) quoteOrder(ctx context.Context, country string, items []types.ClothesBought) (*types.OrderQuote, error) {
+	country = strings.ToUpper(country)
+	if country == "" {
+		country = types.HomeCountry
+	}
+
+	quote := types.OrderQuote{
+		Country:  country,
+		Lines:    []types.OrderLine{},
+		Subtotal: types.NewMoney(0),
+		Tax:      types.NewMoney(0),
+		Total:    types.NewMoney(0),
+	}
+
+	rate, err := a.store.Taxes.GetTaxRate(ctx, country)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if rate != nil {
+		quote.TaxRate = rate.Rate
+		quote.TaxInclusive = rate.Inclusive
+	}
+
+	for _, item := range items {
+		clothing, err := a.store.Clothes.GetOneClothes(ctx, item.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		amount := clothing.EffectivePrice.Amount * int64(item.Quantity)
+		net, tax := applyTax(amount, quote.TaxRate, quote.TaxInclusive)
+
+		quote.Lines = append(quote.Lines, types.OrderLine{
+			ClothesId: clothing.Id,
+			Sku:       clothing.Sku,
+			Name:      clothing.Name,
+			Quantity:  item.Quantity,
+			UnitPrice: clothing.EffectivePrice,
+			Tax:       types.NewMoney(tax),
+			Total:     types.NewMoney(net + tax),
+		})
+
+		quote.Subtotal.Amount += net
+		quote.Tax.Amount += tax
+	}
+
+	quote.Total.Amount = quote.Subtotal.Amount + quote.Tax.Amount
+	return &quote, nil
+}
+
+// applyTax splits amount into what's before tax and the tax at rate percent,
+// rounded to the nearest kobo. An inclusive amount already contains the tax,
+// an exclusive one has it added on top.
+func applyTax(amount int64, rate float64, inclusive bool) (int64, int64) {
+	if inclusive {
+		tax := int64(math.Round(float64(amount) * rate / (100 + rate)))
+		return amount - tax, tax
+	}
+
+	return amount, int64(math.Round(float64(amount) * rate / 100))
+}
@@ -0,0 +1,40 @@
+package api
+
+import "testing"
+
+func TestApplyTax(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    int64
+		rate      float64
+		inclusive bool
+		net       int64
+		tax       int64
+	}{
+		{"exclusive adds the tax on top", 10000, 7.5, false, 10000, 750},
+		{"inclusive takes the tax out", 10750, 7.5, true, 10000, 750},
+		{"exclusive rounds half a kobo up", 333, 7.5, false, 333, 25},
+		{"inclusive rounds to the nearest kobo", 100, 7.5, true, 93, 7},
+		{"exclusive rounds down below half", 10, 7.5, false, 10, 1},
+		{"inclusive of a single kobo", 1, 7.5, true, 1, 0},
+		{"zero rate", 10000, 0, false, 10000, 0},
+		{"zero rate inclusive", 10000, 0, true, 10000, 0},
+		{"zero amount", 0, 7.5, false, 0, 0},
+		{"zero amount inclusive", 0, 7.5, true, 0, 0},
+		{"negative exclusive", -333, 7.5, false, -333, -25},
+		{"negative inclusive", -100, 7.5, true, -93, -7},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			net, tax := applyTax(test.amount, test.rate, test.inclusive)
+			if net != test.net || tax != test.tax {
+				t.Errorf("applyTax(%d, %v, %v) = %d, %d, want %d, %d", test.amount, test.rate, test.inclusive, net, tax, test.net, test.tax)
+			}
+
+			if test.inclusive && net+tax != test.amount {
+				t.Errorf("applyTax(%d, %v, true) split into %d + %d, which doesn't add back up", test.amount, test.rate, net, tax)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+var (
+	trackIpLimit  = envInt("TRACK_IP_LIMIT", 20)
+	trackIpWindow = 10 * time.Minute
+)
+
+func (a *application) AllTrackingRoutes(r chi.Router) {
+	r.Get("/{reference}", a.TrackOrder)
+}
+
+// TrackOrder shows where an order is to anyone with its reference and the
+// email or phone it was placed with, given as ?email= or ?phone=. Guesses are
+// rate limited per IP, and every miss gets the same answer so it can't tell
+// which references exist.
+func (a *application) TrackOrder(w http.ResponseWriter, r *http.Request) {
+	if !a.trackIpLimiter.Allow(clientIp(r)) {
+		utils.WriteError(w, http.StatusTooManyRequests, fmt.Errorf("Too many lookups, please try again later"))
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	phone := r.URL.Query().Get("phone")
+	if email == "" && phone == "" {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Give the email or phone number the order was placed with"))
+		return
+	}
+
+	order, err := a.store.Orders.GetOrderByReference(r.Context(), chi.URLParam(r, "reference"))
+	if err != nil && err != sql.ErrNoRows {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	if order == nil || !ownsOrder(*order, email, phone) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("No order like this exists"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, orderTracking(*order))
+}
+
+// orderTracking trims an order down to what TrackOrder shows. History notes
+// are for admins, so only the tracking number on shipping is kept.
+func orderTracking(order types.Order) types.OrderTracking {
+	tracking := types.OrderTracking{
+		Reference:      order.Reference,
+		Status:         types.OrderStatusPlaced,
+		TrackingNumber: order.TrackingNumber,
+		Lines:          order.Lines,
+		Total:          order.Price,
+		PlacedAt:       order.CreatedAt,
+		Timeline:       []types.OrderStatus{},
+	}
+
+	for _, entry := range order.History {
+		if entry.Status != types.OrderStatusShipped {
+			entry.Note = ""
+		}
+
+		tracking.Timeline = append(tracking.Timeline, entry)
+		tracking.Status = entry.Status
+	}
+
+	return tracking
+}
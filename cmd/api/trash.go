@@ -0,0 +1,108 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/utils"
+)
+
+// writeTrashError answers a failed delete, restore or purge. Referential
+// integrity failures come back from the store as errors meant for the admin,
+// and all get a 409.
+func writeTrashError(w http.ResponseWriter, err error, notFound string) {
+	if err == sql.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, errors.New(notFound))
+		return
+	}
+
+	utils.WriteError(w, http.StatusConflict, err)
+}
+
+func (a *application) GetTrashedClothes(w http.ResponseWriter, r *http.Request) {
+	clothes, err := a.store.Clothes.GetTrashedClothes(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, clothes)
+}
+
+func (a *application) RestoreClothing(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	clothing, err := a.store.Clothes.RestoreClothes(r.Context(), id)
+	if err != nil {
+		writeTrashError(w, err, "No clothing like this exists!")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, clothing)
+}
+
+func (a *application) PurgeClothing(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	clothing, err := a.store.Clothes.PurgeClothes(r.Context(), id)
+	if err != nil {
+		writeTrashError(w, err, "No clothing like this exists!")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, clothing)
+}
+
+func (a *application) GetTrashedCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := a.store.Categories.GetTrashedCategories(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, categories)
+}
+
+func (a *application) RestoreCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "category"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	category, err := a.store.Categories.RestoreCategory(r.Context(), id)
+	if err != nil {
+		writeTrashError(w, err, "No category like this")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, category)
+}
+
+func (a *application) PurgeCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "category"))
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("Cannot convert to int"))
+		return
+	}
+
+	category, err := a.store.Categories.PurgeCategory(r.Context(), id)
+	if err != nil {
+		writeTrashError(w, err, "No category like this")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, category)
+}
@@ -1,14 +1,37 @@
 package api
 
 import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/poohda-go/types"
 	"github.com/poohda-go/utils"
 )
 
+const waitlistConfirmPurpose = "waitlist-confirm"
+
+var (
+	waitlistConfirmTTL     = 72 * time.Hour
+	waitlistUnconfirmedTTL = envDays("WAITLIST_UNCONFIRMED_DAYS", 7)
+	waitlistReferralBoost  = envInt("WAITLIST_REFERRAL_BOOST", 5)
+)
+
 func (a *application) AllWaitlistRoutes(r chi.Router) {
-	r.Get("/", a.GetAllWaitlistParticipants)
+	r.With(requireAdmin).Get("/", a.GetAllWaitlistParticipants)
+	r.Get("/confirm", a.ConfirmWaitlistEntry)
+	r.Get("/me/{code}", a.GetWaitlistStanding)
+	r.Post("/early-access", a.VerifyEarlyAccess)
+	r.With(requireAdmin).Get("/leaderboard", a.GetWaitlistLeaderboard)
+	r.With(requireAdmin).Get("/export", a.ExportWaitlist)
+	r.With(requireAdmin).Post("/import", a.ImportWaitlist)
 }
 
 func (a *application) GetAllWaitlistParticipants(w http.ResponseWriter, r *http.Request) {
@@ -20,3 +43,386 @@ func (a *application) GetAllWaitlistParticipants(w http.ResponseWriter, r *http.
 
 	utils.WriteJSON(w, http.StatusOK, waitlist)
 }
+
+func (a *application) ConfirmWaitlistEntry(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	email, err := utils.VerifyPurposeToken(r.URL.Query().Get("token"), waitlistConfirmPurpose)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	participant, err := a.store.Waitlist.ConfirmWaitlistEntry(ctx, email)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, fmt.Sprintf("%s with email: %s is confirmed on the waitlist", participant.Name, participant.Email))
+}
+
+func (a *application) GetWaitlistStanding(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	code := chi.URLParam(r, "code")
+
+	standing, err := a.store.Waitlist.GetWaitlistStanding(ctx, code, waitlistReferralBoost)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, standing)
+}
+
+func (a *application) GetWaitlistLeaderboard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	leaderboard, err := a.store.Waitlist.GetWaitlistLeaderboard(ctx, waitlistReferralBoost, limit)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, leaderboard)
+}
+
+// ExportWaitlist streams confirmed participants as CSV (the default) or XLSX,
+// optionally limited to signups between the from and to dates inclusive.
+func (a *application) ExportWaitlist(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	export := exportFile{
+		Name:   "waitlist",
+		Sheet:  "Waitlist",
+		Header: []string{"name", "email", "number", "referral_code", "confirmed_at", "created_at"},
+	}
+
+	a.streamExport(w, r.URL.Query().Get("format"), export, func(write func([]string) error) error {
+		return a.store.Waitlist.StreamWaitlist(ctx, from, to, func(participant types.Waitlist) error {
+			confirmedAt := ""
+			if participant.ConfirmedAt != nil {
+				confirmedAt = participant.ConfirmedAt.Format(time.RFC3339)
+			}
+
+			return write([]string{
+				participant.Name,
+				participant.Email,
+				participant.Number,
+				participant.ReferralCode,
+				confirmedAt,
+				participant.CreatedAt.Format(time.RFC3339),
+			})
+		})
+	})
+}
+
+// ImportWaitlist bulk loads contacts from a CSV with name, email, number and
+// consent columns, sent either as the "file" form field or as the raw body.
+// Imported contacts skip the confirmation mail, so only rows whose consent is
+// yes or true get in. With ?dry_run=true it only reports what would happen.
+func (a *application) ImportWaitlist(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	result := types.WaitlistImportResult{DryRun: dryRun, Errors: []types.WaitlistImportError{}}
+
+	http.NewResponseController(w).SetReadDeadline(time.Now().Add(time.Minute))
+	r.Body = http.MaxBytesReader(w, r.Body, 10<<20)
+
+	var file io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		upload, _, err := r.FormFile("file")
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Upload the CSV as the file field: %v", err))
+			return
+		}
+		defer upload.Close()
+		file = upload
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("Cannot read the CSV header: %v", err))
+		return
+	}
+
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+
+	for _, required := range []string{"name", "email", "consent"} {
+		if _, ok := columns[required]; !ok {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("The CSV needs a %s column", required))
+			return
+		}
+	}
+
+	cell := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return unescapeCell(strings.TrimSpace(record[i]))
+		}
+		return ""
+	}
+
+	rows := []types.WaitlistImportRow{}
+	seen := map[string]bool{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			result.Errors = append(result.Errors, types.WaitlistImportError{Row: line, Error: err.Error()})
+			continue
+		}
+
+		result.Total++
+		row := types.WaitlistImportRow{
+			Name:   cell(record, "name"),
+			Email:  strings.ToLower(cell(record, "email")),
+			Number: cell(record, "number"),
+		}
+
+		if err := utils.ValidateJson(row); err != nil {
+			result.Errors = append(result.Errors, types.WaitlistImportError{Row: line, Email: row.Email, Error: err.Error()})
+			continue
+		}
+
+		switch strings.ToLower(cell(record, "consent")) {
+		case "yes", "y", "true", "1":
+		default:
+			result.Errors = append(result.Errors, types.WaitlistImportError{Row: line, Email: row.Email, Error: "No recorded consent, they'll have to sign up themselves"})
+			continue
+		}
+
+		if row.Number != "" {
+			row.Number, _ = utils.NormalizePhone(row.Number)
+		}
+
+		if seen[row.Email] {
+			result.Duplicates++
+			continue
+		}
+
+		seen[row.Email] = true
+		rows = append(rows, row)
+	}
+
+	emails := make([]string, len(rows))
+	for i, row := range rows {
+		emails[i] = row.Email
+	}
+
+	existing, err := a.store.Waitlist.GetExistingWaitlistEmails(ctx, emails)
+	if err != nil {
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	fresh := []types.WaitlistImportRow{}
+	for _, row := range rows {
+		if existing[row.Email] {
+			result.Duplicates++
+			continue
+		}
+
+		fresh = append(fresh, row)
+	}
+
+	result.Imported = len(fresh)
+	if !dryRun && len(fresh) > 0 {
+		imported, err := a.store.Waitlist.ImportWaitlist(ctx, fresh)
+		if err != nil {
+			utils.WriteError(w, http.StatusConflict, err)
+			return
+		}
+
+		// Anything that lost a race with a signup since the check is a duplicate too.
+		result.Imported = int(imported)
+		result.Duplicates += len(fresh) - int(imported)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, result)
+}
+
+// parseDateRange reads the optional from and to query dates (YYYY-MM-DD),
+// returning to as the exclusive start of the following day.
+func parseDateRange(r *http.Request) (*time.Time, *time.Time, error) {
+	var from, to *time.Time
+
+	if value := r.URL.Query().Get("from"); value != "" {
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("from has to look like 2024-12-31")
+		}
+		from = &date
+	}
+
+	if value := r.URL.Query().Get("to"); value != "" {
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("to has to look like 2024-12-31")
+		}
+		date = date.AddDate(0, 0, 1)
+		to = &date
+	}
+
+	return from, to, nil
+}
+
+// exportFile describes a download for streamExport.
+type exportFile struct {
+	// Name starts the file name, the date is added to it.
+	Name   string
+	Sheet  string
+	Header []string
+	// Raw leaves cells unescaped, for files meant to be imported back.
+	Raw bool
+}
+
+// streamExport sends the export as CSV (the default format) or XLSX, the
+// header row first and then whatever stream writes. Big exports outlive the
+// server's default write timeout, so it gets five minutes. Once the headers
+// are out an error can't be sent any more, so a failing stream is logged and
+// the file cut short.
+func (a *application) streamExport(w http.ResponseWriter, format string, export exportFile, stream func(write func([]string) error) error) {
+	filename := fmt.Sprintf("%s-%s", export.Name, time.Now().Format(time.DateOnly))
+	write, finish, err := exportWriter(w, format, filename, export.Sheet, !export.Raw)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(5 * time.Minute))
+
+	err = write(export.Header)
+	if err == nil {
+		err = stream(write)
+	}
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		a.logger.Errorf("Exporting %s: %v", export.Name, err)
+	}
+}
+
+// exportWriter sets up a download of filename as CSV or XLSX, returning how
+// to write each record and how to finish the file. With escape, CSV cells
+// that could run as formulas are quoted.
+func exportWriter(w http.ResponseWriter, format string, filename string, sheetName string, escape bool) (func([]string) error, func() error, error) {
+	switch format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+		writer := csv.NewWriter(w)
+		write := writer.Write
+		if escape {
+			write = escapedRows(write)
+		}
+		return write, func() error {
+			writer.Flush()
+			return writer.Error()
+		}, nil
+	case "xlsx":
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+		writer, err := utils.NewXLSXWriter(w, sheetName)
+		if err != nil {
+			return nil, nil, err
+		}
+		return writer.Write, writer.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("Format has to be csv or xlsx")
+	}
+}
+
+// formulaStarts are what a CSV cell can start with for spreadsheet apps to
+// run it as a formula.
+const formulaStarts = "=+-@\t\r"
+
+// escapedRows stops spreadsheet apps running anything the public typed in
+// as a formula, by quoting cells that start like one. That includes phone
+// numbers, which come out as '+234.... XLSX cells are written as strings and
+// never evaluated, so only CSV needs it.
+func escapedRows(write func([]string) error) func([]string) error {
+	return func(row []string) error {
+		escaped := make([]string, len(row))
+		for i, cell := range row {
+			if cell != "" && strings.ContainsRune(formulaStarts, rune(cell[0])) {
+				cell = "'" + cell
+			}
+			escaped[i] = cell
+		}
+
+		return write(escaped)
+	}
+}
+
+// unescapeCell undoes escapedRows, so our own exports import cleanly.
+func unescapeCell(cell string) string {
+	if len(cell) > 1 && cell[0] == '\'' && strings.ContainsRune(formulaStarts, rune(cell[1])) {
+		return cell[1:]
+	}
+
+	return cell
+}
+
+// waitlistConfirmUrl builds the signed link sent in the welcome email.
+func waitlistConfirmUrl(email string) (string, error) {
+	token, err := utils.SignPurposeToken(email, waitlistConfirmPurpose, waitlistConfirmTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/waitlist/confirm?token=%s", APP_URL, token), nil
+}
+
+// purgeUnconfirmedWaitlist removes signups that never clicked their
+// confirmation link, checking once an hour until ctx is cancelled.
+func (a *application) purgeUnconfirmedWaitlist(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purged, err := a.store.Waitlist.PurgeUnconfirmedWaitlist(ctx, waitlistUnconfirmedTTL)
+		if err != nil {
+			a.logger.Errorf("Purging unconfirmed waitlist: %v", err)
+		} else if purged > 0 {
+			a.logger.Infof("Purged %d unconfirmed waitlist entries", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func envDays(key string, fallback int) time.Duration {
+	return time.Duration(envInt(key, fallback)) * 24 * time.Hour
+}
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
+}
@@ -156,6 +156,579 @@ func AddMigrations(db *sql.DB) {
 					`ALTER TABLE "clothes_bought" DROP CONSTRAINT IF EXISTS "ClothesBought_orderId_fkey"`,
 				},
 			},
+
+			{
+				Id: "16",
+				Up: []string{
+					`ALTER TABLE "waitlist" ADD COLUMN IF NOT EXISTS created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, ADD COLUMN IF NOT EXISTS confirmed_at TIMESTAMP`,
+					`UPDATE "waitlist" SET confirmed_at = created_at WHERE confirmed_at IS NULL`,
+				},
+				Down: []string{
+					`ALTER TABLE "waitlist" DROP COLUMN IF EXISTS confirmed_at, DROP COLUMN IF EXISTS created_at`,
+				},
+			},
+
+			{
+				Id: "17",
+				Up: []string{
+					`ALTER TABLE "waitlist" ADD COLUMN IF NOT EXISTS referral_code VARCHAR(12), ADD COLUMN IF NOT EXISTS referred_by BIGINT REFERENCES "waitlist"("id") ON DELETE SET NULL`,
+					// Existing members get codes from the alphabet utils.RandomCode
+					// draws from, drawn again on the rare clash.
+					`DO $$
+					DECLARE
+						member_id BIGINT;
+						candidate TEXT;
+					BEGIN
+						FOR member_id IN SELECT id FROM "waitlist" WHERE referral_code IS NULL LOOP
+							LOOP
+								candidate := (SELECT string_agg(substr('23456789ABCDEFGHJKLMNPQRSTUVWXYZ', 1 + floor(random() * 32)::INT, 1), '') FROM generate_series(1, 8));
+								EXIT WHEN NOT EXISTS (SELECT 1 FROM "waitlist" WHERE referral_code = candidate);
+							END LOOP;
+							UPDATE "waitlist" SET referral_code = candidate WHERE id = member_id;
+						END LOOP;
+					END
+					$$`,
+					`ALTER TABLE "waitlist" ALTER COLUMN referral_code SET NOT NULL`,
+					`CREATE UNIQUE INDEX IF NOT EXISTS "waitlist_referral_code_key" ON "waitlist" (referral_code)`,
+					`CREATE INDEX IF NOT EXISTS "waitlist_referred_by_idx" ON "waitlist" (referred_by)`,
+				},
+				Down: []string{
+					`DROP INDEX IF EXISTS "waitlist_referred_by_idx"`,
+					`DROP INDEX IF EXISTS "waitlist_referral_code_key"`,
+					`ALTER TABLE "waitlist" DROP COLUMN IF EXISTS referred_by, DROP COLUMN IF EXISTS referral_code`,
+				},
+			},
+
+			{
+				Id: "18",
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS "email_preferences" (email VARCHAR(255) PRIMARY KEY, launch_news BOOLEAN NOT NULL DEFAULT TRUE, order_updates BOOLEAN NOT NULL DEFAULT TRUE, promotions BOOLEAN NOT NULL DEFAULT TRUE, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS "email_preferences"`,
+				},
+			},
+
+			{
+				Id: "19",
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS "campaigns" (id SERIAL PRIMARY KEY, name VARCHAR(255) NOT NULL, template VARCHAR(50) NOT NULL, subject VARCHAR(255) NOT NULL, headline VARCHAR(255) NOT NULL DEFAULT '', message TEXT NOT NULL DEFAULT '', segment VARCHAR(50) NOT NULL, signed_up_after TIMESTAMP, top_referrers INT NOT NULL DEFAULT 0, status VARCHAR(20) NOT NULL DEFAULT 'scheduled', scheduled_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, started_at TIMESTAMP, finished_at TIMESTAMP, created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+					`CREATE TABLE IF NOT EXISTS "campaign_recipients" (id SERIAL PRIMARY KEY, campaign_id INT NOT NULL REFERENCES "campaigns"("id") ON DELETE CASCADE, name VARCHAR(255) NOT NULL, email VARCHAR(255) NOT NULL, status VARCHAR(20) NOT NULL DEFAULT 'pending', error TEXT NOT NULL DEFAULT '', sent_at TIMESTAMP, UNIQUE (campaign_id, email))`,
+					`CREATE INDEX IF NOT EXISTS "campaign_recipients_pending_idx" ON "campaign_recipients" (campaign_id) WHERE status = 'pending'`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS "campaign_recipients"`,
+					`DROP TABLE IF EXISTS "campaigns"`,
+				},
+			},
+
+			{
+				Id: "20",
+				Up: []string{
+					`ALTER TABLE "waitlist" ALTER COLUMN number TYPE VARCHAR(16), ADD COLUMN IF NOT EXISTS sms_opt_in BOOLEAN NOT NULL DEFAULT FALSE`,
+					`ALTER TABLE "campaign_recipients" ADD COLUMN IF NOT EXISTS number VARCHAR(16) NOT NULL DEFAULT ''`,
+					`ALTER TABLE "orders" ADD COLUMN IF NOT EXISTS phone VARCHAR(16) NOT NULL DEFAULT '', ADD COLUMN IF NOT EXISTS sms_opt_in BOOLEAN NOT NULL DEFAULT FALSE`,
+				},
+				Down: []string{
+					`ALTER TABLE "orders" DROP COLUMN IF EXISTS sms_opt_in, DROP COLUMN IF EXISTS phone`,
+					`ALTER TABLE "campaign_recipients" DROP COLUMN IF EXISTS number`,
+					`ALTER TABLE "waitlist" DROP COLUMN IF EXISTS sms_opt_in`,
+				},
+			},
+
+			{
+				Id: "21",
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS "blocked_email_domains" (domain VARCHAR(255) PRIMARY KEY, reason VARCHAR(255) NOT NULL DEFAULT '', created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+					`INSERT INTO "blocked_email_domains" (domain, reason) VALUES ('mailinator.com', 'disposable'), ('guerrillamail.com', 'disposable'), ('sharklasers.com', 'disposable'), ('10minutemail.com', 'disposable'), ('temp-mail.org', 'disposable'), ('tempmail.com', 'disposable'), ('yopmail.com', 'disposable'), ('trashmail.com', 'disposable'), ('getnada.com', 'disposable'), ('dispostable.com', 'disposable'), ('maildrop.cc', 'disposable'), ('throwawaymail.com', 'disposable') ON CONFLICT DO NOTHING`,
+					`CREATE TABLE IF NOT EXISTS "subscribe_rejections" (id SERIAL PRIMARY KEY, reason VARCHAR(50) NOT NULL, ip VARCHAR(64) NOT NULL DEFAULT '', email VARCHAR(255) NOT NULL DEFAULT '', domain VARCHAR(255) NOT NULL DEFAULT '', created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+					`CREATE INDEX IF NOT EXISTS "subscribe_rejections_created_at_idx" ON "subscribe_rejections" (created_at)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS "subscribe_rejections"`,
+					`DROP TABLE IF EXISTS "blocked_email_domains"`,
+				},
+			},
+
+			{
+				Id: "22",
+				Up: []string{
+					`ALTER TABLE "clothes" ADD COLUMN IF NOT EXISTS is_featured BOOLEAN NOT NULL DEFAULT FALSE`,
+					`CREATE INDEX IF NOT EXISTS "clothes_created_at_idx" ON "clothes" (created_at DESC, id DESC)`,
+					`CREATE INDEX IF NOT EXISTS "clothes_price_idx" ON "clothes" (price, id)`,
+					`CREATE INDEX IF NOT EXISTS "clothes_name_idx" ON "clothes" (name, id)`,
+					`CREATE INDEX IF NOT EXISTS "clothes_category_id_idx" ON "clothes" (category_id)`,
+					`CREATE INDEX IF NOT EXISTS "clothes_sizes_clothes_id_idx" ON "clothes_sizes" (clothes_id)`,
+					`CREATE INDEX IF NOT EXISTS "image_clothes_id_idx" ON "image" (clothes_id)`,
+				},
+				Down: []string{
+					`DROP INDEX IF EXISTS "image_clothes_id_idx"`,
+					`DROP INDEX IF EXISTS "clothes_sizes_clothes_id_idx"`,
+					`DROP INDEX IF EXISTS "clothes_category_id_idx"`,
+					`DROP INDEX IF EXISTS "clothes_name_idx"`,
+					`DROP INDEX IF EXISTS "clothes_price_idx"`,
+					`DROP INDEX IF EXISTS "clothes_created_at_idx"`,
+					`ALTER TABLE "clothes" DROP COLUMN IF EXISTS is_featured`,
+				},
+			},
+
+			{
+				Id: "23",
+				Up: []string{
+					`ALTER TABLE "clothes" ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+					`CREATE OR REPLACE FUNCTION clothes_search_vector(clothes_name TEXT, clothes_description TEXT, clothes_category_id INT) RETURNS tsvector AS $$
+						SELECT setweight(to_tsvector('english', COALESCE(clothes_name, '')), 'A')
+							|| setweight(to_tsvector('english', COALESCE((SELECT name FROM "category" WHERE id = clothes_category_id), '')), 'B')
+							|| setweight(to_tsvector('english', COALESCE(clothes_description, '')), 'C')
+					$$ LANGUAGE sql STABLE`,
+					`CREATE OR REPLACE FUNCTION clothes_search_vector_refresh() RETURNS trigger AS $$
+					BEGIN
+						NEW.search_vector := clothes_search_vector(NEW.name, NEW.description, NEW.category_id);
+						RETURN NEW;
+					END
+					$$ LANGUAGE plpgsql`,
+					`DROP TRIGGER IF EXISTS "clothes_search_vector_refresh" ON "clothes"`,
+					`CREATE TRIGGER "clothes_search_vector_refresh" BEFORE INSERT OR UPDATE OF name, description, category_id ON "clothes" FOR EACH ROW EXECUTE FUNCTION clothes_search_vector_refresh()`,
+					`CREATE OR REPLACE FUNCTION category_search_vector_refresh() RETURNS trigger AS $$
+					BEGIN
+						UPDATE "clothes" SET search_vector = clothes_search_vector(name, description, category_id) WHERE category_id = NEW.id;
+						RETURN NULL;
+					END
+					$$ LANGUAGE plpgsql`,
+					`DROP TRIGGER IF EXISTS "category_search_vector_refresh" ON "category"`,
+					`CREATE TRIGGER "category_search_vector_refresh" AFTER UPDATE OF name ON "category" FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name) EXECUTE FUNCTION category_search_vector_refresh()`,
+					`UPDATE "clothes" SET search_vector = clothes_search_vector(name, description, category_id)`,
+					`CREATE INDEX IF NOT EXISTS "clothes_search_vector_idx" ON "clothes" USING GIN (search_vector)`,
+				},
+				Down: []string{
+					`DROP INDEX IF EXISTS "clothes_search_vector_idx"`,
+					`DROP TRIGGER IF EXISTS "category_search_vector_refresh" ON "category"`,
+					`DROP FUNCTION IF EXISTS category_search_vector_refresh()`,
+					`DROP TRIGGER IF EXISTS "clothes_search_vector_refresh" ON "clothes"`,
+					`DROP FUNCTION IF EXISTS clothes_search_vector_refresh()`,
+					`DROP FUNCTION IF EXISTS clothes_search_vector(TEXT, TEXT, INT)`,
+					`ALTER TABLE "clothes" DROP COLUMN IF EXISTS search_vector`,
+				},
+			},
+
+			{
+				Id: "24",
+				Up: []string{
+					`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+					`CREATE INDEX IF NOT EXISTS "clothes_name_trgm_idx" ON "clothes" USING GIN (name gin_trgm_ops)`,
+					`CREATE INDEX IF NOT EXISTS "category_name_trgm_idx" ON "category" USING GIN (name gin_trgm_ops)`,
+				},
+				Down: []string{
+					`DROP INDEX IF EXISTS "category_name_trgm_idx"`,
+					`DROP INDEX IF EXISTS "clothes_name_trgm_idx"`,
+				},
+			},
+
+			{
+				Id: "25",
+				Up: []string{
+					`ALTER TABLE "clothes" ADD COLUMN IF NOT EXISTS colour VARCHAR(50) NOT NULL DEFAULT ''`,
+					`CREATE INDEX IF NOT EXISTS "clothes_colour_idx" ON "clothes" (lower(colour))`,
+				},
+				Down: []string{
+					`DROP INDEX IF EXISTS "clothes_colour_idx"`,
+					`ALTER TABLE "clothes" DROP COLUMN IF EXISTS colour`,
+				},
+			},
+
+			{
+				Id: "26",
+				Up: []string{
+					`CREATE EXTENSION IF NOT EXISTS unaccent`,
+					`ALTER TABLE "clothes" ADD COLUMN IF NOT EXISTS slug VARCHAR(160)`,
+					`ALTER TABLE "category" ADD COLUMN IF NOT EXISTS slug VARCHAR(160)`,
+					`UPDATE "clothes" SET slug = trim(both '-' from regexp_replace(lower(unaccent(name)), '[^a-z0-9]+', '-', 'g')) WHERE slug IS NULL`,
+					`UPDATE "category" SET slug = trim(both '-' from regexp_replace(lower(unaccent(name)), '[^a-z0-9]+', '-', 'g')) WHERE slug IS NULL`,
+					`UPDATE "clothes" AS cl SET slug = CASE WHEN cl.slug = '' THEN 'clothes' ELSE cl.slug END || '-' || cl.id WHERE cl.slug = '' OR EXISTS (SELECT 1 FROM "clothes" AS other WHERE other.slug = cl.slug AND other.id < cl.id)`,
+					`UPDATE "category" AS c SET slug = CASE WHEN c.slug = '' THEN 'category' ELSE c.slug END || '-' || c.id WHERE c.slug = '' OR EXISTS (SELECT 1 FROM "category" AS other WHERE other.slug = c.slug AND other.id < c.id)`,
+					`ALTER TABLE "clothes" ALTER COLUMN slug SET NOT NULL`,
+					`ALTER TABLE "category" ALTER COLUMN slug SET NOT NULL`,
+					`CREATE UNIQUE INDEX IF NOT EXISTS "clothes_slug_key" ON "clothes" (slug)`,
+					`CREATE UNIQUE INDEX IF NOT EXISTS "category_slug_key" ON "category" (slug)`,
+					`CREATE TABLE IF NOT EXISTS "clothes_slug_history" (slug VARCHAR(160) PRIMARY KEY, clothes_id INT NOT NULL REFERENCES "clothes"("id") ON DELETE CASCADE, created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+					`CREATE TABLE IF NOT EXISTS "category_slug_history" (slug VARCHAR(160) PRIMARY KEY, category_id INT NOT NULL REFERENCES "category"("id") ON DELETE CASCADE, created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS "category_slug_history"`,
+					`DROP TABLE IF EXISTS "clothes_slug_history"`,
+					`DROP INDEX IF EXISTS "category_slug_key"`,
+					`DROP INDEX IF EXISTS "clothes_slug_key"`,
+					`ALTER TABLE "category" DROP COLUMN IF EXISTS slug`,
+					`ALTER TABLE "clothes" DROP COLUMN IF EXISTS slug`,
+				},
+			},
+
+			{
+				Id: "27",
+				Up: []string{
+					`ALTER TABLE "category" ADD COLUMN IF NOT EXISTS parent_id INT REFERENCES "category"("id") ON DELETE SET NULL`,
+					`ALTER TABLE "category" ADD CONSTRAINT "category_not_own_parent" CHECK (parent_id <> id)`,
+					`CREATE INDEX IF NOT EXISTS "category_parent_id_idx" ON "category" (parent_id)`,
+				},
+				Down: []string{
+					`DROP INDEX IF EXISTS "category_parent_id_idx"`,
+					`ALTER TABLE "category" DROP CONSTRAINT IF EXISTS "category_not_own_parent"`,
+					`ALTER TABLE "category" DROP COLUMN IF EXISTS parent_id`,
+				},
+			},
+
+			{
+				Id: "28",
+				Up: []string{
+					// Everything already in the shop stays live, new clothes start as drafts.
+					`ALTER TABLE "clothes" ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'published' CHECK (status IN ('draft', 'scheduled', 'published', 'archived')), ADD COLUMN IF NOT EXISTS publish_at TIMESTAMP, ADD COLUMN IF NOT EXISTS launch_campaign BOOLEAN NOT NULL DEFAULT false`,
+					`ALTER TABLE "clothes" ALTER COLUMN status SET DEFAULT 'draft'`,
+					`CREATE INDEX IF NOT EXISTS "clothes_status_idx" ON "clothes" (status)`,
+					`CREATE INDEX IF NOT EXISTS "clothes_scheduled_idx" ON "clothes" (publish_at) WHERE status = 'scheduled'`,
+				},
+				Down: []string{
+					`DROP INDEX IF EXISTS "clothes_scheduled_idx"`,
+					`DROP INDEX IF EXISTS "clothes_status_idx"`,
+					`ALTER TABLE "clothes" DROP COLUMN IF EXISTS launch_campaign, DROP COLUMN IF EXISTS publish_at, DROP COLUMN IF EXISTS status`,
+				},
+			},
+
+			{
+				Id: "29",
+				Up: []string{
+					`ALTER TABLE "clothes" ADD COLUMN IF NOT EXISTS early_access_at TIMESTAMP, ADD COLUMN IF NOT EXISTS early_access_started BOOLEAN NOT NULL DEFAULT false`,
+					`ALTER TABLE "clothes" ADD CONSTRAINT "clothes_early_access_before_publish" CHECK (early_access_at IS NULL OR early_access_at < publish_at)`,
+					`ALTER TABLE "waitlist" ADD COLUMN IF NOT EXISTS access_code VARCHAR(12)`,
+					`CREATE UNIQUE INDEX IF NOT EXISTS "waitlist_access_code_key" ON "waitlist" (access_code)`,
+				},
+				Down: []string{
+					`DROP INDEX IF EXISTS "waitlist_access_code_key"`,
+					`ALTER TABLE "waitlist" DROP COLUMN IF EXISTS access_code`,
+					`ALTER TABLE "clothes" DROP CONSTRAINT IF EXISTS "clothes_early_access_before_publish"`,
+					`ALTER TABLE "clothes" DROP COLUMN IF EXISTS early_access_started, DROP COLUMN IF EXISTS early_access_at`,
+				},
+			},
+
+			{
+				Id: "30",
+				Up: []string{
+					`ALTER TABLE "clothes" ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+					`ALTER TABLE "category" ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+					`CREATE INDEX IF NOT EXISTS "clothes_trash_idx" ON "clothes" (deleted_at) WHERE deleted_at IS NOT NULL`,
+					`CREATE INDEX IF NOT EXISTS "category_trash_idx" ON "category" (deleted_at) WHERE deleted_at IS NOT NULL`,
+				},
+				Down: []string{
+					`DROP INDEX IF EXISTS "category_trash_idx"`,
+					`DROP INDEX IF EXISTS "clothes_trash_idx"`,
+					`ALTER TABLE "category" DROP COLUMN IF EXISTS deleted_at`,
+					`ALTER TABLE "clothes" DROP COLUMN IF EXISTS deleted_at`,
+				},
+			},
+
+			{
+				Id: "31",
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS "media" (id VARCHAR(32) PRIMARY KEY, storage VARCHAR(20) NOT NULL, storage_key VARCHAR(255) NOT NULL, url VARCHAR(500) NOT NULL, content_type VARCHAR(100) NOT NULL, size BIGINT NOT NULL, original_name VARCHAR(255) NOT NULL DEFAULT '', created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+					// Cloudinary URLs don't fit in 100 characters.
+					`ALTER TABLE "image" ALTER COLUMN url TYPE VARCHAR(500), ADD COLUMN IF NOT EXISTS media_id VARCHAR(32) REFERENCES "media"("id") ON DELETE SET NULL`,
+					`ALTER TABLE "category_image" ALTER COLUMN url TYPE VARCHAR(500), ADD COLUMN IF NOT EXISTS media_id VARCHAR(32) REFERENCES "media"("id") ON DELETE SET NULL`,
+				},
+				Down: []string{
+					`ALTER TABLE "category_image" DROP COLUMN IF EXISTS media_id`,
+					`ALTER TABLE "image" DROP COLUMN IF EXISTS media_id`,
+					`DROP TABLE IF EXISTS "media"`,
+				},
+			},
+
+			{
+				Id: "32",
+				Up: []string{
+					`ALTER TABLE "media" ADD COLUMN IF NOT EXISTS width INT NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS height INT NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS variants JSONB`,
+					`ALTER TABLE "image" ADD COLUMN IF NOT EXISTS position INT NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS alt_text VARCHAR(255) NOT NULL DEFAULT '', ADD COLUMN IF NOT EXISTS focal_x REAL NOT NULL DEFAULT 0.5, ADD COLUMN IF NOT EXISTS focal_y REAL NOT NULL DEFAULT 0.5, ADD COLUMN IF NOT EXISTS is_primary BOOLEAN NOT NULL DEFAULT false`,
+					`ALTER TABLE "category_image" ADD COLUMN IF NOT EXISTS position INT NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS alt_text VARCHAR(255) NOT NULL DEFAULT '', ADD COLUMN IF NOT EXISTS focal_x REAL NOT NULL DEFAULT 0.5, ADD COLUMN IF NOT EXISTS focal_y REAL NOT NULL DEFAULT 0.5, ADD COLUMN IF NOT EXISTS is_primary BOOLEAN NOT NULL DEFAULT false`,
+					`ALTER TABLE "image" ADD CONSTRAINT "image_focal_point_check" CHECK (focal_x BETWEEN 0 AND 1 AND focal_y BETWEEN 0 AND 1)`,
+					`ALTER TABLE "category_image" ADD CONSTRAINT "category_image_focal_point_check" CHECK (focal_x BETWEEN 0 AND 1 AND focal_y BETWEEN 0 AND 1)`,
+					// Existing pictures keep the order they were added in, and the first one becomes primary.
+					`UPDATE "image" AS i SET position = ordered.position FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY clothes_id ORDER BY id) - 1 AS position FROM "image") AS ordered WHERE i.id = ordered.id`,
+					`UPDATE "category_image" AS ci SET position = ordered.position FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY category_id ORDER BY id) - 1 AS position FROM "category_image") AS ordered WHERE ci.id = ordered.id`,
+					`UPDATE "image" SET is_primary = true WHERE position = 0`,
+					`UPDATE "category_image" SET is_primary = true WHERE position = 0`,
+					`CREATE UNIQUE INDEX IF NOT EXISTS "image_primary_key" ON "image" (clothes_id) WHERE is_primary`,
+					`CREATE UNIQUE INDEX IF NOT EXISTS "category_image_primary_key" ON "category_image" (category_id) WHERE is_primary`,
+					`CREATE INDEX IF NOT EXISTS "category_image_category_id_idx" ON "category_image" (category_id)`,
+				},
+				Down: []string{
+					`DROP INDEX IF EXISTS "category_image_category_id_idx"`,
+					`DROP INDEX IF EXISTS "category_image_primary_key"`,
+					`DROP INDEX IF EXISTS "image_primary_key"`,
+					`ALTER TABLE "category_image" DROP CONSTRAINT IF EXISTS "category_image_focal_point_check", DROP COLUMN IF EXISTS position, DROP COLUMN IF EXISTS alt_text, DROP COLUMN IF EXISTS focal_x, DROP COLUMN IF EXISTS focal_y, DROP COLUMN IF EXISTS is_primary`,
+					`ALTER TABLE "image" DROP CONSTRAINT IF EXISTS "image_focal_point_check", DROP COLUMN IF EXISTS position, DROP COLUMN IF EXISTS alt_text, DROP COLUMN IF EXISTS focal_x, DROP COLUMN IF EXISTS focal_y, DROP COLUMN IF EXISTS is_primary`,
+					`ALTER TABLE "media" DROP COLUMN IF EXISTS width, DROP COLUMN IF EXISTS height, DROP COLUMN IF EXISTS variants`,
+				},
+			},
+
+			{
+				Id: "33",
+				Up: []string{
+					`ALTER TABLE "clothes" ADD COLUMN IF NOT EXISTS sku VARCHAR(64)`,
+					// Slugs are already unique, so they make a fine SKU for what's there.
+					`UPDATE "clothes" SET sku = upper(slug) WHERE sku IS NULL`,
+					`ALTER TABLE "clothes" ALTER COLUMN sku SET NOT NULL, ADD CONSTRAINT "clothes_sku_key" UNIQUE (sku)`,
+				},
+				Down: []string{
+					`ALTER TABLE "clothes" DROP CONSTRAINT IF EXISTS "clothes_sku_key", DROP COLUMN IF EXISTS sku`,
+				},
+			},
+
+			{
+				Id: "34",
+				Up: []string{
+					`ALTER TABLE "clothes" ADD COLUMN IF NOT EXISTS compare_at_price INT, ADD COLUMN IF NOT EXISTS sale_price INT, ADD COLUMN IF NOT EXISTS sale_starts_at TIMESTAMP, ADD COLUMN IF NOT EXISTS sale_ends_at TIMESTAMP`,
+					`ALTER TABLE "clothes" ADD CONSTRAINT "clothes_sale_price_check" CHECK (sale_price IS NULL OR (sale_price >= 0 AND sale_price < price))`,
+					`ALTER TABLE "clothes" ADD CONSTRAINT "clothes_sale_window_check" CHECK (sale_starts_at IS NULL OR sale_ends_at IS NULL OR sale_starts_at < sale_ends_at)`,
+					`CREATE TABLE IF NOT EXISTS "price_history" (id SERIAL PRIMARY KEY, clothes_id INT NOT NULL REFERENCES "clothes"("id") ON DELETE CASCADE, price INT, compare_at_price INT, sale_price INT, sale_starts_at TIMESTAMP, sale_ends_at TIMESTAMP, changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+					`CREATE INDEX IF NOT EXISTS "price_history_clothes_id_idx" ON "price_history" (clothes_id, changed_at)`,
+					`INSERT INTO "price_history" (clothes_id, price, changed_at) SELECT id, price, COALESCE(created_at, CURRENT_TIMESTAMP) FROM "clothes"`,
+					`CREATE OR REPLACE FUNCTION clothes_price_history_record() RETURNS trigger AS $$
+					BEGIN
+						INSERT INTO "price_history" (clothes_id, price, compare_at_price, sale_price, sale_starts_at, sale_ends_at) VALUES (NEW.id, NEW.price, NEW.compare_at_price, NEW.sale_price, NEW.sale_starts_at, NEW.sale_ends_at);
+						RETURN NULL;
+					END
+					$$ LANGUAGE plpgsql`,
+					`DROP TRIGGER IF EXISTS "clothes_price_history_insert" ON "clothes"`,
+					`CREATE TRIGGER "clothes_price_history_insert" AFTER INSERT ON "clothes" FOR EACH ROW EXECUTE FUNCTION clothes_price_history_record()`,
+					`DROP TRIGGER IF EXISTS "clothes_price_history_update" ON "clothes"`,
+					`CREATE TRIGGER "clothes_price_history_update" AFTER UPDATE OF price, compare_at_price, sale_price, sale_starts_at, sale_ends_at ON "clothes" FOR EACH ROW WHEN ((OLD.price, OLD.compare_at_price, OLD.sale_price, OLD.sale_starts_at, OLD.sale_ends_at) IS DISTINCT FROM (NEW.price, NEW.compare_at_price, NEW.sale_price, NEW.sale_starts_at, NEW.sale_ends_at)) EXECUTE FUNCTION clothes_price_history_record()`,
+				},
+				Down: []string{
+					`DROP TRIGGER IF EXISTS "clothes_price_history_update" ON "clothes"`,
+					`DROP TRIGGER IF EXISTS "clothes_price_history_insert" ON "clothes"`,
+					`DROP FUNCTION IF EXISTS clothes_price_history_record()`,
+					`DROP TABLE IF EXISTS "price_history"`,
+					`ALTER TABLE "clothes" DROP CONSTRAINT IF EXISTS "clothes_sale_window_check", DROP CONSTRAINT IF EXISTS "clothes_sale_price_check", DROP COLUMN IF EXISTS compare_at_price, DROP COLUMN IF EXISTS sale_price, DROP COLUMN IF EXISTS sale_starts_at, DROP COLUMN IF EXISTS sale_ends_at`,
+				},
+			},
+
+			{
+				Id: "35",
+				Up: []string{
+					// Prices were whole naira, from here on every amount is in kobo.
+					// The history trigger reads the price columns, so it can't stay
+					// on while their type changes.
+					`DROP TRIGGER IF EXISTS "clothes_price_history_update" ON "clothes"`,
+					`ALTER TABLE "clothes" ALTER COLUMN price TYPE BIGINT USING price * 100, ALTER COLUMN compare_at_price TYPE BIGINT USING compare_at_price * 100, ALTER COLUMN sale_price TYPE BIGINT USING sale_price * 100`,
+					`CREATE TRIGGER "clothes_price_history_update" AFTER UPDATE OF price, compare_at_price, sale_price, sale_starts_at, sale_ends_at ON "clothes" FOR EACH ROW WHEN ((OLD.price, OLD.compare_at_price, OLD.sale_price, OLD.sale_starts_at, OLD.sale_ends_at) IS DISTINCT FROM (NEW.price, NEW.compare_at_price, NEW.sale_price, NEW.sale_starts_at, NEW.sale_ends_at)) EXECUTE FUNCTION clothes_price_history_record()`,
+					`ALTER TABLE "price_history" ALTER COLUMN price TYPE BIGINT USING price * 100, ALTER COLUMN compare_at_price TYPE BIGINT USING compare_at_price * 100, ALTER COLUMN sale_price TYPE BIGINT USING sale_price * 100`,
+					`ALTER TABLE "orders" ALTER COLUMN price TYPE BIGINT USING price * 100`,
+					`CREATE TABLE IF NOT EXISTS "exchange_rate" (currency CHAR(3) PRIMARY KEY, rate NUMERIC(20, 8) NOT NULL CHECK (rate > 0), updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS "exchange_rate"`,
+					`ALTER TABLE "orders" ALTER COLUMN price TYPE INT USING price / 100`,
+					`ALTER TABLE "price_history" ALTER COLUMN price TYPE INT USING price / 100, ALTER COLUMN compare_at_price TYPE INT USING compare_at_price / 100, ALTER COLUMN sale_price TYPE INT USING sale_price / 100`,
+					`DROP TRIGGER IF EXISTS "clothes_price_history_update" ON "clothes"`,
+					`ALTER TABLE "clothes" ALTER COLUMN price TYPE INT USING price / 100, ALTER COLUMN compare_at_price TYPE INT USING compare_at_price / 100, ALTER COLUMN sale_price TYPE INT USING sale_price / 100`,
+					`CREATE TRIGGER "clothes_price_history_update" AFTER UPDATE OF price, compare_at_price, sale_price, sale_starts_at, sale_ends_at ON "clothes" FOR EACH ROW WHEN ((OLD.price, OLD.compare_at_price, OLD.sale_price, OLD.sale_starts_at, OLD.sale_ends_at) IS DISTINCT FROM (NEW.price, NEW.compare_at_price, NEW.sale_price, NEW.sale_starts_at, NEW.sale_ends_at)) EXECUTE FUNCTION clothes_price_history_record()`,
+				},
+			},
+
+			{
+				Id: "36",
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS "tax_rate" (country CHAR(2) PRIMARY KEY, name VARCHAR(50) NOT NULL, rate NUMERIC(5, 2) NOT NULL CHECK (rate >= 0 AND rate < 100), inclusive BOOLEAN NOT NULL DEFAULT FALSE, updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+					// Prices in Nigeria are shown with VAT already in them.
+					`INSERT INTO "tax_rate" (country, name, rate, inclusive) VALUES ('NG', 'VAT', 7.5, TRUE) ON CONFLICT (country) DO NOTHING`,
+					`ALTER TABLE "orders" ADD COLUMN IF NOT EXISTS email VARCHAR(255) NOT NULL DEFAULT '', ADD COLUMN IF NOT EXISTS country CHAR(2) NOT NULL DEFAULT 'NG', ADD COLUMN IF NOT EXISTS subtotal BIGINT, ADD COLUMN IF NOT EXISTS tax BIGINT NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS tax_rate NUMERIC(5, 2) NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS tax_inclusive BOOLEAN NOT NULL DEFAULT FALSE, ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP`,
+					// Orders from before tax was tracked paid no tax.
+					`UPDATE "orders" SET subtotal = price WHERE subtotal IS NULL`,
+					`ALTER TABLE "clothes_bought" ADD COLUMN IF NOT EXISTS unit_price BIGINT NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS tax BIGINT NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS total BIGINT NOT NULL DEFAULT 0`,
+					`CREATE INDEX IF NOT EXISTS "orders_created_at_idx" ON "orders" (created_at)`,
+				},
+				Down: []string{
+					`DROP INDEX IF EXISTS "orders_created_at_idx"`,
+					`ALTER TABLE "clothes_bought" DROP COLUMN IF EXISTS total, DROP COLUMN IF EXISTS tax, DROP COLUMN IF EXISTS unit_price`,
+					`ALTER TABLE "orders" DROP COLUMN IF EXISTS created_at, DROP COLUMN IF EXISTS tax_inclusive, DROP COLUMN IF EXISTS tax_rate, DROP COLUMN IF EXISTS tax, DROP COLUMN IF EXISTS subtotal, DROP COLUMN IF EXISTS country, DROP COLUMN IF EXISTS email`,
+					`DROP TABLE IF EXISTS "tax_rate"`,
+				},
+			},
+
+			{
+				Id: "37",
+				Up: []string{
+					// A single row counter rather than a sequence, so a rolled back
+					// payment doesn't leave a gap in the invoice numbers.
+					`CREATE TABLE IF NOT EXISTS "invoice_counter" (id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id), last_number INT NOT NULL DEFAULT 0)`,
+					`INSERT INTO "invoice_counter" (id, last_number) VALUES (TRUE, 0) ON CONFLICT (id) DO NOTHING`,
+					`ALTER TABLE "orders" ADD COLUMN IF NOT EXISTS invoice_number INT, ADD COLUMN IF NOT EXISTS payment_reference VARCHAR(100) NOT NULL DEFAULT '', ADD COLUMN IF NOT EXISTS paid_at TIMESTAMP`,
+					`ALTER TABLE "orders" ADD CONSTRAINT "orders_invoice_number_key" UNIQUE (invoice_number)`,
+				},
+				Down: []string{
+					`ALTER TABLE "orders" DROP CONSTRAINT IF EXISTS "orders_invoice_number_key"`,
+					`ALTER TABLE "orders" DROP COLUMN IF EXISTS paid_at, DROP COLUMN IF EXISTS payment_reference, DROP COLUMN IF EXISTS invoice_number`,
+					`DROP TABLE IF EXISTS "invoice_counter"`,
+				},
+			},
+
+			{
+				Id: "38",
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS "order_status_history" (id SERIAL PRIMARY KEY, order_id INT NOT NULL REFERENCES "orders"("id") ON DELETE CASCADE, status VARCHAR(30) NOT NULL, note TEXT NOT NULL DEFAULT '', created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+					`CREATE INDEX IF NOT EXISTS "order_status_history_order_id_idx" ON "order_status_history" (order_id, created_at)`,
+					// Give existing orders the history they would have had.
+					`INSERT INTO "order_status_history" (order_id, status, created_at) SELECT id, 'placed', created_at FROM "orders"`,
+					`INSERT INTO "order_status_history" (order_id, status, note, created_at) SELECT id, 'paid', payment_reference, paid_at FROM "orders" WHERE paid_at IS NOT NULL`,
+					`INSERT INTO "order_status_history" (order_id, status) SELECT id, 'delivered' FROM "orders" WHERE is_delivered`,
+					`CREATE TABLE IF NOT EXISTS "return_request" (id SERIAL PRIMARY KEY, order_id INT NOT NULL REFERENCES "orders"("id"), status VARCHAR(20) NOT NULL DEFAULT 'requested' CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'refunding', 'refunded')), opened_by VARCHAR(20) NOT NULL CHECK (opened_by IN ('customer', 'admin')), note VARCHAR(500) NOT NULL DEFAULT '', refund_amount BIGINT CHECK (refund_amount > 0), refund_reference VARCHAR(100) NOT NULL DEFAULT '', created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+					`CREATE INDEX IF NOT EXISTS "return_request_order_id_idx" ON "return_request" (order_id)`,
+					`CREATE INDEX IF NOT EXISTS "return_request_status_idx" ON "return_request" (status, created_at)`,
+					`CREATE TABLE IF NOT EXISTS "return_line" (id SERIAL PRIMARY KEY, return_id INT NOT NULL REFERENCES "return_request"("id") ON DELETE CASCADE, clothes_bought_id INT NOT NULL REFERENCES "clothes_bought"("id"), quantity INT NOT NULL CHECK (quantity > 0), reason VARCHAR(30) NOT NULL, amount BIGINT NOT NULL)`,
+					`CREATE INDEX IF NOT EXISTS "return_line_clothes_bought_id_idx" ON "return_line" (clothes_bought_id)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS "return_line"`,
+					`DROP TABLE IF EXISTS "return_request"`,
+					`DROP TABLE IF EXISTS "order_status_history"`,
+				},
+			},
+
+			{
+				Id: "39",
+				Up: []string{
+					`ALTER TABLE "orders" ADD COLUMN IF NOT EXISTS reference VARCHAR(12), ADD COLUMN IF NOT EXISTS tracking_number VARCHAR(100) NOT NULL DEFAULT ''`,
+					// Existing orders get references the same shape as new ones,
+					// drawn again on the rare clash.
+					`DO $$
+					DECLARE
+						order_id INT;
+						candidate TEXT;
+					BEGIN
+						FOR order_id IN SELECT id FROM "orders" WHERE reference IS NULL LOOP
+							LOOP
+								candidate := 'PDA-' || (SELECT string_agg(substr('23456789ABCDEFGHJKLMNPQRSTUVWXYZ', 1 + floor(random() * 32)::INT, 1), '') FROM generate_series(1, 5));
+								EXIT WHEN NOT EXISTS (SELECT 1 FROM "orders" WHERE reference = candidate);
+							END LOOP;
+							UPDATE "orders" SET reference = candidate WHERE id = order_id;
+						END LOOP;
+					END
+					$$`,
+					`ALTER TABLE "orders" ALTER COLUMN reference SET NOT NULL`,
+					`ALTER TABLE "orders" ADD CONSTRAINT "orders_reference_key" UNIQUE (reference)`,
+				},
+				Down: []string{
+					`ALTER TABLE "orders" DROP CONSTRAINT IF EXISTS "orders_reference_key"`,
+					`ALTER TABLE "orders" DROP COLUMN IF EXISTS tracking_number, DROP COLUMN IF EXISTS reference`,
+				},
+			},
+
+			{
+				Id: "40",
+				Up: []string{
+					`ALTER TABLE "campaign_recipients" ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS retry_at TIMESTAMPTZ`,
+					// Unconfirmed signups that haven't been mailed yet never
+					// opted in, so they come off campaigns still sending.
+					`DELETE FROM "campaign_recipients" AS cr USING "waitlist" AS w WHERE cr.status = 'pending' AND w.email = cr.email AND w.confirmed_at IS NULL`,
+				},
+				Down: []string{
+					`ALTER TABLE "campaign_recipients" DROP COLUMN IF EXISTS retry_at, DROP COLUMN IF EXISTS attempts`,
+				},
+			},
+
+			{
+				Id: "41",
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS "used_form_tokens" (nonce VARCHAR(32) PRIMARY KEY, expires_at TIMESTAMPTZ NOT NULL)`,
+					`CREATE INDEX IF NOT EXISTS "used_form_tokens_expires_at_idx" ON "used_form_tokens" (expires_at)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS "used_form_tokens"`,
+				},
+			},
+
+			{
+				Id: "42",
+				Up: []string{
+					// The words did-you-mean corrects to, kept apart so they can
+					// carry a trigram index instead of being split out of every
+					// name and description per search.
+					`CREATE MATERIALIZED VIEW IF NOT EXISTS "search_lexicon" AS SELECT DISTINCT word FROM (
+						SELECT lower(word) AS word FROM "clothes" AS cl, regexp_split_to_table(cl.name || ' ' || cl.description, '[^[:alnum:]]+') AS word WHERE cl.deleted_at IS NULL AND (cl.status = 'published' OR (cl.status = 'scheduled' AND COALESCE(cl.early_access_at, cl.publish_at) <= CURRENT_TIMESTAMP))
+						UNION
+						SELECT lower(word) AS word FROM "category", regexp_split_to_table(name, '[^[:alnum:]]+') AS word WHERE deleted_at IS NULL
+					) AS words WHERE length(word) > 1`,
+					`CREATE UNIQUE INDEX IF NOT EXISTS "search_lexicon_word_key" ON "search_lexicon" (word)`,
+					`CREATE INDEX IF NOT EXISTS "search_lexicon_word_trgm_idx" ON "search_lexicon" USING GIN (word gin_trgm_ops)`,
+				},
+				Down: []string{
+					`DROP MATERIALIZED VIEW IF EXISTS "search_lexicon"`,
+				},
+			},
+
+			{
+				Id: "43",
+				Up: []string{
+					// Release and sale times were stored without a zone and compared
+					// against the database clock, which runs in UTC, so that's what
+					// they're read as.
+					// The lexicon and the history trigger read these columns, so
+					// they come off while the type changes.
+					`DROP MATERIALIZED VIEW IF EXISTS "search_lexicon"`,
+					`DROP TRIGGER IF EXISTS "clothes_price_history_update" ON "clothes"`,
+					`ALTER TABLE "clothes" ALTER COLUMN publish_at TYPE TIMESTAMPTZ USING publish_at AT TIME ZONE 'UTC', ALTER COLUMN early_access_at TYPE TIMESTAMPTZ USING early_access_at AT TIME ZONE 'UTC', ALTER COLUMN sale_starts_at TYPE TIMESTAMPTZ USING sale_starts_at AT TIME ZONE 'UTC', ALTER COLUMN sale_ends_at TYPE TIMESTAMPTZ USING sale_ends_at AT TIME ZONE 'UTC'`,
+					`ALTER TABLE "price_history" ALTER COLUMN sale_starts_at TYPE TIMESTAMPTZ USING sale_starts_at AT TIME ZONE 'UTC', ALTER COLUMN sale_ends_at TYPE TIMESTAMPTZ USING sale_ends_at AT TIME ZONE 'UTC'`,
+					`CREATE TRIGGER "clothes_price_history_update" AFTER UPDATE OF price, compare_at_price, sale_price, sale_starts_at, sale_ends_at ON "clothes" FOR EACH ROW WHEN ((OLD.price, OLD.compare_at_price, OLD.sale_price, OLD.sale_starts_at, OLD.sale_ends_at) IS DISTINCT FROM (NEW.price, NEW.compare_at_price, NEW.sale_price, NEW.sale_starts_at, NEW.sale_ends_at)) EXECUTE FUNCTION clothes_price_history_record()`,
+					`CREATE MATERIALIZED VIEW IF NOT EXISTS "search_lexicon" AS SELECT DISTINCT word FROM (
+						SELECT lower(word) AS word FROM "clothes" AS cl, regexp_split_to_table(cl.name || ' ' || cl.description, '[^[:alnum:]]+') AS word WHERE cl.deleted_at IS NULL AND (cl.status = 'published' OR (cl.status = 'scheduled' AND COALESCE(cl.early_access_at, cl.publish_at) <= CURRENT_TIMESTAMP))
+						UNION
+						SELECT lower(word) AS word FROM "category", regexp_split_to_table(name, '[^[:alnum:]]+') AS word WHERE deleted_at IS NULL
+					) AS words WHERE length(word) > 1`,
+					`CREATE UNIQUE INDEX IF NOT EXISTS "search_lexicon_word_key" ON "search_lexicon" (word)`,
+					`CREATE INDEX IF NOT EXISTS "search_lexicon_word_trgm_idx" ON "search_lexicon" USING GIN (word gin_trgm_ops)`,
+					// Launch campaigns go out once per clothing, whether it went
+					// live on schedule or was published straight away. Everything
+					// already live has had its chance.
+					`ALTER TABLE "clothes" ADD COLUMN IF NOT EXISTS launch_campaign_sent BOOLEAN NOT NULL DEFAULT false`,
+					`UPDATE "clothes" SET launch_campaign_sent = true WHERE status IN ('published', 'archived')`,
+				},
+				Down: []string{
+					`ALTER TABLE "clothes" DROP COLUMN IF EXISTS launch_campaign_sent`,
+					`DROP MATERIALIZED VIEW IF EXISTS "search_lexicon"`,
+					`DROP TRIGGER IF EXISTS "clothes_price_history_update" ON "clothes"`,
+					`ALTER TABLE "price_history" ALTER COLUMN sale_starts_at TYPE TIMESTAMP USING sale_starts_at AT TIME ZONE 'UTC', ALTER COLUMN sale_ends_at TYPE TIMESTAMP USING sale_ends_at AT TIME ZONE 'UTC'`,
+					`ALTER TABLE "clothes" ALTER COLUMN publish_at TYPE TIMESTAMP USING publish_at AT TIME ZONE 'UTC', ALTER COLUMN early_access_at TYPE TIMESTAMP USING early_access_at AT TIME ZONE 'UTC', ALTER COLUMN sale_starts_at TYPE TIMESTAMP USING sale_starts_at AT TIME ZONE 'UTC', ALTER COLUMN sale_ends_at TYPE TIMESTAMP USING sale_ends_at AT TIME ZONE 'UTC'`,
+					`CREATE TRIGGER "clothes_price_history_update" AFTER UPDATE OF price, compare_at_price, sale_price, sale_starts_at, sale_ends_at ON "clothes" FOR EACH ROW WHEN ((OLD.price, OLD.compare_at_price, OLD.sale_price, OLD.sale_starts_at, OLD.sale_ends_at) IS DISTINCT FROM (NEW.price, NEW.compare_at_price, NEW.sale_price, NEW.sale_starts_at, NEW.sale_ends_at)) EXECUTE FUNCTION clothes_price_history_record()`,
+					`CREATE MATERIALIZED VIEW IF NOT EXISTS "search_lexicon" AS SELECT DISTINCT word FROM (
+						SELECT lower(word) AS word FROM "clothes" AS cl, regexp_split_to_table(cl.name || ' ' || cl.description, '[^[:alnum:]]+') AS word WHERE cl.deleted_at IS NULL AND (cl.status = 'published' OR (cl.status = 'scheduled' AND COALESCE(cl.early_access_at, cl.publish_at) <= CURRENT_TIMESTAMP))
+						UNION
+						SELECT lower(word) AS word FROM "category", regexp_split_to_table(name, '[^[:alnum:]]+') AS word WHERE deleted_at IS NULL
+					) AS words WHERE length(word) > 1`,
+					`CREATE UNIQUE INDEX IF NOT EXISTS "search_lexicon_word_key" ON "search_lexicon" (word)`,
+					`CREATE INDEX IF NOT EXISTS "search_lexicon_word_trgm_idx" ON "search_lexicon" USING GIN (word gin_trgm_ops)`,
+				},
+			},
+
+			{
+				Id: "44",
+				Up: []string{
+					// Like the release times in 43, campaign times lost their offset
+					// on the way in, so they're read as the database's UTC.
+					`ALTER TABLE "campaigns" ALTER COLUMN scheduled_at TYPE TIMESTAMPTZ USING scheduled_at AT TIME ZONE 'UTC', ALTER COLUMN signed_up_after TYPE TIMESTAMPTZ USING signed_up_after AT TIME ZONE 'UTC'`,
+				},
+				Down: []string{
+					`ALTER TABLE "campaigns" ALTER COLUMN scheduled_at TYPE TIMESTAMP USING scheduled_at AT TIME ZONE 'UTC', ALTER COLUMN signed_up_after TYPE TIMESTAMP USING signed_up_after AT TIME ZONE 'UTC'`,
+				},
+			},
+
+			{
+				Id: "45",
+				Up: []string{
+					// Order mail is all transactional now, so there's nothing left
+					// to opt out of there.
+					`ALTER TABLE "email_preferences" DROP COLUMN IF EXISTS order_updates`,
+				},
+				Down: []string{
+					`ALTER TABLE "email_preferences" ADD COLUMN IF NOT EXISTS order_updates BOOLEAN NOT NULL DEFAULT TRUE`,
+				},
+			},
 		},
 	}
 
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/poohda-go/types"
+)
+
+type AntispamStore struct {
+	db *sql.DB
+}
+
+// UseFormToken spends a subscribe form token's nonce, reporting false when it
+// was already spent. Nonces are kept until their token expires, after which
+// the token is refused anyway.
+func (s *AntispamStore) UseFormToken(ctx context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM "used_form_tokens" WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		return false, err
+	}
+
+	result, err := s.db.ExecContext(ctx, `INSERT INTO "used_form_tokens" (nonce, expires_at) VALUES ($1, $2) ON CONFLICT (nonce) DO NOTHING`, nonce, expiresAt)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// IsDomainBlocked also matches subdomains, so blocking mailinator.com covers
+// eu.mailinator.com.
+func (s *AntispamStore) IsDomainBlocked(ctx context.Context, domain string) (bool, error) {
+	var blocked bool
+	query := `SELECT EXISTS (SELECT 1 FROM "blocked_email_domains" WHERE $1 = domain OR $1 LIKE '%.' || domain)`
+
+	err := s.db.QueryRowContext(ctx, query, strings.ToLower(domain)).Scan(&blocked)
+	return blocked, err
+}
+
+func (s *AntispamStore) GetBlockedDomains(ctx context.Context) ([]types.BlockedDomain, error) {
+	domains := []types.BlockedDomain{}
+	query := `SELECT domain, reason, created_at FROM "blocked_email_domains" ORDER BY domain`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var domain types.BlockedDomain
+		if err := rows.Scan(&domain.Domain, &domain.Reason, &domain.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		domains = append(domains, domain)
+	}
+
+	return domains, nil
+}
+
+func (s *AntispamStore) AddBlockedDomain(ctx context.Context, payload types.BlockedDomainDTO) (*types.BlockedDomain, error) {
+	var domain types.BlockedDomain
+	query := `INSERT INTO "blocked_email_domains" (domain, reason) VALUES ($1, $2) ON CONFLICT (domain) DO UPDATE SET reason=EXCLUDED.reason RETURNING domain, reason, created_at`
+
+	if err := s.db.QueryRowContext(ctx, query, strings.ToLower(payload.Domain), payload.Reason).Scan(
+		&domain.Domain,
+		&domain.Reason,
+		&domain.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &domain, nil
+}
+
+func (s *AntispamStore) RemoveBlockedDomain(ctx context.Context, domain string) error {
+	query := `DELETE FROM "blocked_email_domains" WHERE domain=$1`
+
+	result, err := s.db.ExecContext(ctx, query, strings.ToLower(domain))
+	if err != nil {
+		return err
+	}
+
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return fmt.Errorf("This domain is not blocked")
+	}
+
+	return nil
+}
+
+func (s *AntispamStore) RecordRejection(ctx context.Context, rejection types.SubscribeRejection) error {
+	query := `INSERT INTO "subscribe_rejections" (reason, ip, email, domain) VALUES ($1, $2, $3, $4)`
+
+	_, err := s.db.ExecContext(ctx, query, rejection.Reason, rejection.Ip, rejection.Email, rejection.Domain)
+	return err
+}
+
+func (s *AntispamStore) GetRejections(ctx context.Context, reason string, since time.Time, limit int) ([]types.SubscribeRejection, error) {
+	rejections := []types.SubscribeRejection{}
+	query := `SELECT id, reason, ip, email, domain, created_at FROM "subscribe_rejections" WHERE ($1::text = '' OR reason=$1::text) AND created_at >= $2 ORDER BY created_at DESC LIMIT $3`
+
+	rows, err := s.db.QueryContext(ctx, query, reason, since, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var rejection types.SubscribeRejection
+		if err := rows.Scan(
+			&rejection.Id,
+			&rejection.Reason,
+			&rejection.Ip,
+			&rejection.Email,
+			&rejection.Domain,
+			&rejection.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		rejections = append(rejections, rejection)
+	}
+
+	return rejections, nil
+}
+
+// GetRejectionSummary counts rejections per reason since the given time.
+func (s *AntispamStore) GetRejectionSummary(ctx context.Context, since time.Time) (map[string]int, error) {
+	summary := map[string]int{}
+	query := `SELECT reason, COUNT(*) FROM "subscribe_rejections" WHERE created_at >= $1 GROUP BY reason`
+
+	rows, err := s.db.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var reason string
+		var count int
+		if err := rows.Scan(&reason, &count); err != nil {
+			return nil, err
+		}
+
+		summary[reason] = count
+	}
+
+	return summary, nil
+}
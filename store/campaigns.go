@@ -0,0 +1,300 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/poohda-go/types"
+)
+
+type CampaignsStore struct {
+	db *sql.DB
+}
+
+const campaignColumns = `id, name, template, subject, headline, message, segment, signed_up_after, top_referrers, status, scheduled_at, started_at, finished_at, created_at`
+
+func scanCampaign(row interface{ Scan(...any) error }, campaign *types.Campaign) error {
+	return row.Scan(
+		&campaign.Id,
+		&campaign.Name,
+		&campaign.Template,
+		&campaign.Subject,
+		&campaign.Headline,
+		&campaign.Message,
+		&campaign.Segment,
+		&campaign.SignedUpAfter,
+		&campaign.TopReferrers,
+		&campaign.Status,
+		&campaign.ScheduledAt,
+		&campaign.StartedAt,
+		&campaign.FinishedAt,
+		&campaign.CreatedAt,
+	)
+}
+
+func (s *CampaignsStore) CreateCampaign(ctx context.Context, payload types.CampaignDTO) (*types.Campaign, error) {
+	var campaign types.Campaign
+	query := `INSERT INTO "campaigns" (name, template, subject, headline, message, segment, signed_up_after, top_referrers, scheduled_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9, CURRENT_TIMESTAMP)) RETURNING ` + campaignColumns
+
+	err := scanCampaign(s.db.QueryRowContext(
+		ctx,
+		query,
+		payload.Name,
+		payload.Template,
+		payload.Subject,
+		payload.Headline,
+		payload.Message,
+		payload.Segment,
+		payload.SignedUpAfter,
+		payload.TopReferrers,
+		payload.ScheduledAt,
+	), &campaign)
+	if err != nil {
+		return nil, err
+	}
+
+	return &campaign, nil
+}
+
+func (s *CampaignsStore) GetAllCampaigns(ctx context.Context) ([]types.Campaign, error) {
+	campaigns := []types.Campaign{}
+	query := `SELECT ` + campaignColumns + ` FROM "campaigns" ORDER BY created_at DESC`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var campaign types.Campaign
+		if err := scanCampaign(rows, &campaign); err != nil {
+			return nil, err
+		}
+
+		campaigns = append(campaigns, campaign)
+	}
+
+	return campaigns, nil
+}
+
+// GetOneCampaign also tallies the campaign's recipients by delivery status.
+func (s *CampaignsStore) GetOneCampaign(ctx context.Context, id int) (*types.Campaign, error) {
+	var campaign types.Campaign
+	query := `SELECT ` + campaignColumns + ` FROM "campaigns" WHERE id=$1`
+	countQuery := `SELECT status, COUNT(*) FROM "campaign_recipients" WHERE campaign_id=$1 GROUP BY status`
+
+	if err := scanCampaign(s.db.QueryRowContext(ctx, query, id), &campaign); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, countQuery, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	campaign.Recipients = map[string]int{}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+
+		campaign.Recipients[status] = count
+	}
+
+	return &campaign, nil
+}
+
+func (s *CampaignsStore) GetCampaignRecipients(ctx context.Context, id int, status string) ([]types.CampaignRecipient, error) {
+	recipients := []types.CampaignRecipient{}
+	query := `SELECT id, name, email, number, status, error, attempts, sent_at FROM "campaign_recipients" WHERE campaign_id=$1 AND ($2::text = '' OR status=$2::text) ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query, id, status)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var recipient types.CampaignRecipient
+		if err := rows.Scan(
+			&recipient.Id,
+			&recipient.Name,
+			&recipient.Email,
+			&recipient.Number,
+			&recipient.Status,
+			&recipient.Error,
+			&recipient.Attempts,
+			&recipient.SentAt,
+		); err != nil {
+			return nil, err
+		}
+
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, nil
+}
+
+func (s *CampaignsStore) StopCampaign(ctx context.Context, id int) (*types.Campaign, error) {
+	var campaign types.Campaign
+	query := `UPDATE "campaigns" SET status='stopped', finished_at=CURRENT_TIMESTAMP WHERE id=$1 AND status IN ('scheduled', 'sending') RETURNING ` + campaignColumns
+
+	if err := scanCampaign(s.db.QueryRowContext(ctx, query, id), &campaign); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("Only scheduled or sending campaigns can be stopped")
+		}
+
+		return nil, err
+	}
+
+	return &campaign, nil
+}
+
+// ClaimDueCampaigns moves every scheduled campaign whose time has come to
+// sending and returns them, so each campaign is only picked up once.
+func (s *CampaignsStore) ClaimDueCampaigns(ctx context.Context) ([]types.Campaign, error) {
+	return s.queryCampaigns(ctx, `UPDATE "campaigns" SET status='sending', started_at=CURRENT_TIMESTAMP WHERE status='scheduled' AND scheduled_at <= CURRENT_TIMESTAMP RETURNING `+campaignColumns)
+}
+
+// GetSendingCampaigns returns campaigns left mid-send, e.g. by a restart.
+func (s *CampaignsStore) GetSendingCampaigns(ctx context.Context) ([]types.Campaign, error) {
+	return s.queryCampaigns(ctx, `SELECT `+campaignColumns+` FROM "campaigns" WHERE status='sending'`)
+}
+
+func (s *CampaignsStore) queryCampaigns(ctx context.Context, query string) ([]types.Campaign, error) {
+	campaigns := []types.Campaign{}
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var campaign types.Campaign
+		if err := scanCampaign(rows, &campaign); err != nil {
+			return nil, err
+		}
+
+		campaigns = append(campaigns, campaign)
+	}
+
+	return campaigns, nil
+}
+
+// PopulateCampaignRecipients snapshots the campaign's segment of the
+// waitlist. Re-running it is harmless, existing recipients are kept.
+func (s *CampaignsStore) PopulateCampaignRecipients(ctx context.Context, campaign types.Campaign) (int64, error) {
+	var segment string
+	args := []any{campaign.Id}
+
+	// Only confirmed members are ever mailed, whatever the segment, since
+	// nobody else has opted in.
+	switch campaign.Segment {
+	case types.CampaignSegmentAll, types.CampaignSegmentConfirmed:
+		segment = `SELECT name, email, number, sms_opt_in FROM "waitlist" WHERE confirmed_at IS NOT NULL`
+	case types.CampaignSegmentSignedUpAfter:
+		segment = `SELECT name, email, number, sms_opt_in FROM "waitlist" WHERE confirmed_at IS NOT NULL AND created_at >= $2`
+		args = append(args, campaign.SignedUpAfter)
+	case types.CampaignSegmentTopReferrers:
+		segment = `SELECT w.name, w.email, w.number, w.sms_opt_in FROM "waitlist" AS w JOIN "waitlist" AS r ON r.referred_by = w.id AND r.confirmed_at IS NOT NULL WHERE w.confirmed_at IS NOT NULL GROUP BY w.id, w.name, w.email, w.number, w.sms_opt_in ORDER BY COUNT(r.id) DESC, w.created_at LIMIT $2`
+		args = append(args, campaign.TopReferrers)
+	default:
+		return 0, fmt.Errorf("No segment like this")
+	}
+
+	// Numbers are only carried over for members who opted in to texts.
+	query := `INSERT INTO "campaign_recipients" (campaign_id, name, email, number) SELECT $1, segment.name, segment.email, CASE WHEN segment.sms_opt_in THEN segment.number ELSE '' END FROM (` + segment + `) AS segment ON CONFLICT (campaign_id, email) DO NOTHING`
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetPendingCampaignRecipients returns up to limit recipients still to be
+// sent to, leaving out those waiting to be retried.
+func (s *CampaignsStore) GetPendingCampaignRecipients(ctx context.Context, id int, limit int) ([]types.CampaignRecipient, error) {
+	recipients := []types.CampaignRecipient{}
+	query := `SELECT id, name, email, number, status, error, attempts, sent_at FROM "campaign_recipients" WHERE campaign_id=$1 AND status='pending' AND (retry_at IS NULL OR retry_at <= CURRENT_TIMESTAMP) ORDER BY id LIMIT $2`
+
+	rows, err := s.db.QueryContext(ctx, query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var recipient types.CampaignRecipient
+		if err := rows.Scan(
+			&recipient.Id,
+			&recipient.Name,
+			&recipient.Email,
+			&recipient.Number,
+			&recipient.Status,
+			&recipient.Error,
+			&recipient.Attempts,
+			&recipient.SentAt,
+		); err != nil {
+			return nil, err
+		}
+
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, nil
+}
+
+func (s *CampaignsStore) MarkCampaignRecipient(ctx context.Context, id int, status string, reason string) error {
+	query := `UPDATE "campaign_recipients" SET status=$2, error=$3, sent_at=CASE WHEN $4 THEN CURRENT_TIMESTAMP ELSE sent_at END WHERE id=$1`
+
+	_, err := s.db.ExecContext(ctx, query, id, status, reason, status == types.RecipientStatusSent)
+	return err
+}
+
+// RetryCampaignRecipient records a failed send and leaves the recipient
+// pending, to be tried again after delay.
+func (s *CampaignsStore) RetryCampaignRecipient(ctx context.Context, id int, reason string, delay time.Duration) error {
+	query := `UPDATE "campaign_recipients" SET attempts = attempts + 1, error=$2, retry_at = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second' WHERE id=$1`
+
+	_, err := s.db.ExecContext(ctx, query, id, reason, delay.Seconds())
+	return err
+}
+
+// HasPendingCampaignRecipients reports whether anyone is still to be sent
+// to, including those waiting to be retried.
+func (s *CampaignsStore) HasPendingCampaignRecipients(ctx context.Context, id int) (bool, error) {
+	var pending bool
+	query := `SELECT EXISTS (SELECT 1 FROM "campaign_recipients" WHERE campaign_id=$1 AND status='pending')`
+
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&pending)
+	return pending, err
+}
+
+func (s *CampaignsStore) GetCampaignStatus(ctx context.Context, id int) (string, error) {
+	var status string
+	query := `SELECT status FROM "campaigns" WHERE id=$1`
+
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&status)
+	return status, err
+}
+
+func (s *CampaignsStore) FinishCampaign(ctx context.Context, id int) error {
+	query := `UPDATE "campaigns" SET status='sent', finished_at=CURRENT_TIMESTAMP WHERE id=$1 AND status='sending'`
+
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
+)
+
+// ImportCatalog creates or updates the clothing of each row by SKU, all in
+// one transaction. A row that fails is rolled back on its own and reported,
+// the rest of the batch still goes in. With dryRun everything is rolled back
+// at the end, so the result shows what would happen.
+func (s *ClothesStore) ImportCatalog(ctx context.Context, rows []types.CatalogRow, dryRun bool) (*types.CatalogImportResult, error) {
+	result := types.CatalogImportResult{DryRun: dryRun, Errors: []types.CatalogImportError{}}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	for _, row := range rows {
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT catalog_row`); err != nil {
+			return nil, err
+		}
+
+		created, err := importCatalogRow(ctx, tx, row)
+		if err != nil {
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT catalog_row`); err != nil {
+				return nil, err
+			}
+
+			result.Errors = append(result.Errors, types.CatalogImportError{Row: row.Line, Sku: row.Sku, Error: err.Error()})
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT catalog_row`); err != nil {
+			return nil, err
+		}
+
+		if created {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+	}
+
+	if dryRun {
+		return &result, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// importCatalogRow upserts a single row, reporting whether it was new.
+// Renames keep the old slug to redirect from, like EditClothes. A row without
+// pictures leaves the clothing's images alone.
+func importCatalogRow(ctx context.Context, tx *sql.Tx, row types.CatalogRow) (bool, error) {
+	var categoryId int
+	err := tx.QueryRowContext(ctx, `SELECT id FROM "category" WHERE lower(name) = lower($1) AND deleted_at IS NULL`, row.Category).Scan(&categoryId)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("There's no category called %q", row.Category)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	var id int
+	var oldName, oldSlug string
+	var trashed bool
+	err = tx.QueryRowContext(ctx, `SELECT id, name, slug, deleted_at IS NOT NULL FROM "clothes" WHERE sku=$1 FOR UPDATE`, row.Sku).Scan(&id, &oldName, &oldSlug, &trashed)
+	created := err == sql.ErrNoRows
+	if err != nil && !created {
+		return false, err
+	}
+
+	if trashed {
+		return false, fmt.Errorf("This SKU is in the trash, restore it first")
+	}
+
+	if created {
+		slug, err := clothesSlugs.uniqueSlug(ctx, tx, row.Name, 0)
+		if err != nil {
+			return false, err
+		}
+
+		query := `INSERT INTO "clothes" (sku, name, slug, price, category_id, description, quantity, is_featured, colour, status, publish_at, compare_at_price, sale_price, sale_starts_at, sale_ends_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE(NULLIF($10, ''), 'draft'), $11, $12, $13, $14, $15) RETURNING id`
+		if err := tx.QueryRowContext(ctx, query, row.Sku, row.Name, slug, row.Price, categoryId, row.Description, row.Quantity, row.IsFeatured, row.Colour, row.Status, row.PublishAt, row.CompareAtPrice, row.SalePrice, row.SaleStartsAt, row.SaleEndsAt).Scan(&id); err != nil {
+			return false, err
+		}
+	} else {
+		slug := oldSlug
+		if utils.Slugify(row.Name) != utils.Slugify(oldName) {
+			slug, err = clothesSlugs.uniqueSlug(ctx, tx, row.Name, id)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		// A blank status keeps the clothing's release as it is.
+		query := `UPDATE "clothes" SET name=$1, slug=$2, price=$3, category_id=$4, description=$5, quantity=$6, is_featured=$7, colour=$8, status=COALESCE(NULLIF($9, ''), status), publish_at=CASE WHEN $9 = '' THEN publish_at ELSE $10 END, compare_at_price=$12, sale_price=$13, sale_starts_at=$14, sale_ends_at=$15, updated_at=CURRENT_TIMESTAMP WHERE id=$11`
+		if _, err := tx.ExecContext(ctx, query, row.Name, slug, row.Price, categoryId, row.Description, row.Quantity, row.IsFeatured, row.Colour, row.Status, row.PublishAt, id, row.CompareAtPrice, row.SalePrice, row.SaleStartsAt, row.SaleEndsAt); err != nil {
+			return false, err
+		}
+
+		if err := clothesSlugs.renameSlug(ctx, tx, id, oldSlug, slug); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "clothes_sizes" WHERE clothes_id=$1`, id); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO "clothes_sizes" (clothes_id, size) SELECT $1, size FROM unnest($2::text[]) AS size`, id, pq.Array(row.Sizes)); err != nil {
+		return false, err
+	}
+
+	if len(row.Pictures) > 0 {
+		if err := syncImageUrls(ctx, tx, "image", "clothes_id", id, row.Pictures); err != nil {
+			return false, err
+		}
+	}
+
+	if err := ensurePrimaryImage(ctx, tx, "image", "clothes_id", id); err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
+
+// StreamCatalog calls fn with every clothing not in the trash, as a row of
+// the catalog CSV, in id order.
+func (s *ClothesStore) StreamCatalog(ctx context.Context, fn func(types.CatalogRow) error) error {
+	query := `SELECT cl.sku, cl.name, c.name, cl.description, COALESCE(cl.price, 0), cl.compare_at_price, cl.sale_price, cl.sale_starts_at, cl.sale_ends_at, COALESCE(cl.quantity, 0), cl.colour,
+		ARRAY(SELECT s.size FROM "clothes_sizes" AS s WHERE s.clothes_id = cl.id ORDER BY s.id) AS sizes,
+		ARRAY(SELECT i.url FROM "image" AS i WHERE i.clothes_id = cl.id ORDER BY i.position, i.id) AS pictures,
+		cl.is_featured, cl.status, cl.publish_at
+	FROM "clothes" AS cl JOIN "category" AS c ON c.id = cl.category_id
+	WHERE cl.deleted_at IS NULL
+	ORDER BY cl.id`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var row types.CatalogRow
+
+		if err := rows.Scan(
+			&row.Sku,
+			&row.Name,
+			&row.Category,
+			&row.Description,
+			&row.Price,
+			&row.CompareAtPrice,
+			&row.SalePrice,
+			&row.SaleStartsAt,
+			&row.SaleEndsAt,
+			&row.Quantity,
+			&row.Colour,
+			pq.Array(&row.Sizes),
+			pq.Array(&row.Pictures),
+			&row.IsFeatured,
+			&row.Status,
+			&row.PublishAt,
+		); err != nil {
+			return err
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
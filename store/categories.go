@@ -3,11 +3,13 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/lib/pq"
 	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
 )
 
 type CategoriesStore struct {
@@ -16,7 +18,7 @@ type CategoriesStore struct {
 
 func (s *CategoriesStore) GetAllCategories() ([]types.Category, error) {
 	categories := []types.Category{}
-	query := `SELECT c.id, c.name, c.description, c.is_featured, array_agg(ci.url) AS pictures FROM "category" AS c JOIN "category_image" AS "ci" ON ci.category_id = c.id GROUP BY c.id, c.name, c.description`
+	query := `SELECT c.id, c.name, c.slug, c.parent_id, c.description, c.is_featured, array_agg(ci.url) AS pictures FROM "category" AS c JOIN "category_image" AS "ci" ON ci.category_id = c.id WHERE c.deleted_at IS NULL GROUP BY c.id, c.name, c.description`
 
 	rows, err := s.db.Query(query)
 	if err != nil {
@@ -30,6 +32,8 @@ func (s *CategoriesStore) GetAllCategories() ([]types.Category, error) {
 		err := rows.Scan(
 			&category.Id,
 			&category.Name,
+			&category.Slug,
+			&category.ParentId,
 			&category.Description,
 			&category.IsFeatured,
 			pq.Array(&category.Pictures),
@@ -41,24 +45,60 @@ func (s *CategoriesStore) GetAllCategories() ([]types.Category, error) {
 		categories = append(categories, category)
 	}
 
+	ids := make([]int, len(categories))
+	for i, category := range categories {
+		ids[i] = category.Id
+	}
+
+	images, err := getImages(context.Background(), s.db, "category_image", "category_id", ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range categories {
+		categories[i].Images = images[categories[i].Id]
+	}
+
 	return categories, nil
 }
 
 func (s *CategoriesStore) CreateNewCategory(ctx context.Context, payload types.CategoryDTO) (*types.Category, error) {
 	var newCategory types.Category
 	var newImagePictures types.Pictures
-	query := `INSERT INTO "category" (name, description, is_featured) VALUES ($1, $2, $3) RETURNING id, name, description, is_featured`
-	imageQuery := `INSERT INTO "category_image" (category_id, url) VALUES ($1, $2) RETURNING id, url`
+	query := `INSERT INTO "category" (name, slug, parent_id, description, is_featured) VALUES ($1, $2, $3, $4, $5) RETURNING id, name, slug, parent_id, description, is_featured`
+	imageQuery := `INSERT INTO "category_image" (category_id, url, position) VALUES ($1, $2, $3) RETURNING id, url`
 
-	err := s.db.QueryRowContext(
+	// The category and its images go in together, so a bad media id doesn't
+	// leave a category without pictures behind.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	if err := checkCategoryParent(ctx, tx, 0, payload.ParentId); err != nil {
+		return nil, err
+	}
+
+	slug, err := categorySlugs.uniqueSlug(ctx, tx, payload.Name, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(
 		ctx,
 		query,
 		payload.Name,
+		slug,
+		payload.ParentId,
 		payload.Description,
 		payload.IsFeatured,
 	).Scan(
 		&newCategory.Id,
 		&newCategory.Name,
+		&newCategory.Slug,
+		&newCategory.ParentId,
 		&newCategory.Description,
 		&newCategory.IsFeatured,
 	)
@@ -66,12 +106,13 @@ func (s *CategoriesStore) CreateNewCategory(ctx context.Context, payload types.C
 		return nil, err
 	}
 
-	for _, picture := range payload.Pictures {
-		err := s.db.QueryRowContext(
+	for i, picture := range payload.Pictures {
+		err := tx.QueryRowContext(
 			ctx,
 			imageQuery,
 			newCategory.Id,
 			picture,
+			i,
 		).Scan(
 			&newImagePictures.Id,
 			&newImagePictures.Url,
@@ -81,25 +122,107 @@ func (s *CategoriesStore) CreateNewCategory(ctx context.Context, payload types.C
 		}
 	}
 
+	if err := attachMedia(ctx, tx, "category_image", "category_id", newCategory.Id, payload.MediaIds); err != nil {
+		return nil, err
+	}
+
+	if err := ensurePrimaryImage(ctx, tx, "category_image", "category_id", newCategory.Id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return &newCategory, nil
 }
 
-func (s *CategoriesStore) GetAllClothesReferenceToACategory(ctx context.Context, id int) ([]types.Clothes, error) {
-	var returnedId int
-	clothings := []types.Clothes{}
-	findQuery := `SELECT id FROM "category" WHERE id=$1`
-	if err := s.db.QueryRowContext(ctx, findQuery, id).Scan(&returnedId); err != nil {
-		if err == sql.ErrNoRows {
-			log.Print(err)
-			return nil, fmt.Errorf("No category like this!")
+// ErrCategoryCycle is returned when a category would end up inside itself.
+var ErrCategoryCycle = errors.New("A category cannot be moved under itself or one of its subcategories")
+
+// checkCategoryParent makes sure parentId exists and, when moving category
+// id, that it isn't id itself or one of id's descendants.
+func checkCategoryParent(ctx context.Context, q queryer, id int, parentId *int) error {
+	if parentId == nil {
+		return nil
+	}
+
+	var found, cycle bool
+	query := `WITH RECURSIVE ancestors AS (
+		SELECT id, parent_id FROM "category" WHERE id=$1 AND deleted_at IS NULL
+		UNION
+		SELECT c.id, c.parent_id FROM "category" AS c JOIN ancestors AS a ON c.id = a.parent_id
+	) SELECT COUNT(*) > 0, COALESCE(bool_or(id=$2), false) FROM ancestors`
+
+	if err := q.QueryRowContext(ctx, query, *parentId, id).Scan(&found, &cycle); err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("No parent category like this")
+	}
+
+	if cycle {
+		return ErrCategoryCycle
+	}
+
+	return nil
+}
+
+// GetCategoryTree returns every category nested under its parent, top level
+// categories first, each level sorted by name.
+func (s *CategoriesStore) GetCategoryTree(ctx context.Context) ([]types.CategoryNode, error) {
+	query := `SELECT id, name, slug, parent_id FROM "category" WHERE deleted_at IS NULL ORDER BY name`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	children := map[int][]types.CategoryNode{}
+	for rows.Next() {
+		var node types.CategoryNode
+		if err := rows.Scan(&node.Id, &node.Name, &node.Slug, &node.ParentId); err != nil {
+			return nil, err
 		}
 
+		parent := 0
+		if node.ParentId != nil {
+			parent = *node.ParentId
+		}
+		children[parent] = append(children[parent], node)
+	}
+
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	query := `SELECT cl.id, cl.name, cl.price, cl.description, cl.quantity, array_agg(DISTINCT i.url) AS "pictures", array_agg(DISTINCT s.size) AS "sizes" FROM "clothes" AS cl JOIN "category" AS c ON cl.category_id = c.id LEFT JOIN "image" AS i ON cl.id = i.clothes_id LEFT JOIN "clothes_sizes" as s ON cl.id = s.clothes_id WHERE c.id=$1 GROUP BY cl.id, cl.name, cl.price, cl.description, cl.quantity;`
+	return categorySubtree(children, 0), nil
+}
+
+func categorySubtree(children map[int][]types.CategoryNode, parent int) []types.CategoryNode {
+	nodes := []types.CategoryNode{}
+	for _, node := range children[parent] {
+		node.Children = categorySubtree(children, node.Id)
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
 
-	rows, err := s.db.Query(query, id)
+// GetCategoryBreadcrumbs returns the path from the top level category down
+// to the category with id, ending with the category itself.
+func (s *CategoriesStore) GetCategoryBreadcrumbs(ctx context.Context, id int) ([]types.Breadcrumb, error) {
+	breadcrumbs := []types.Breadcrumb{}
+	query := `WITH RECURSIVE ancestors AS (
+		SELECT id, name, slug, parent_id, 0 AS depth FROM "category" WHERE id=$1 AND deleted_at IS NULL
+		UNION
+		SELECT c.id, c.name, c.slug, c.parent_id, a.depth + 1 FROM "category" AS c JOIN ancestors AS a ON c.id = a.parent_id
+	) SELECT id, name, slug FROM ancestors ORDER BY depth DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, id)
 	if err != nil {
 		return nil, err
 	}
@@ -107,31 +230,48 @@ func (s *CategoriesStore) GetAllClothesReferenceToACategory(ctx context.Context,
 	defer rows.Close()
 
 	for rows.Next() {
-		var clothing types.Clothes
-		if err := rows.Scan(
-			&clothing.Id,
-			&clothing.Name,
-			&clothing.Price,
-			&clothing.Description,
-			&clothing.Quantity,
-			pq.Array(&clothing.Pictures),
-			pq.Array(&clothing.Sizes),
-		); err != nil {
+		var breadcrumb types.Breadcrumb
+		if err := rows.Scan(&breadcrumb.Id, &breadcrumb.Name, &breadcrumb.Slug); err != nil {
 			return nil, err
 		}
 
-		clothings = append(clothings, clothing)
+		breadcrumbs = append(breadcrumbs, breadcrumb)
+	}
+
+	if len(breadcrumbs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return breadcrumbs, nil
+}
+
+// GetAllClothesReferenceToACategory lists the clothes in the category and
+// all of its subcategories.
+func (s *CategoriesStore) GetAllClothesReferenceToACategory(ctx context.Context, id int, filter types.ClothesFilter) (*types.ClothesPage, error) {
+	var returnedId int
+	findQuery := `SELECT id FROM "category" WHERE id=$1 AND deleted_at IS NULL`
+	if err := s.db.QueryRowContext(ctx, findQuery, id).Scan(&returnedId); err != nil {
+		if err == sql.ErrNoRows {
+			log.Print(err)
+			return nil, fmt.Errorf("No category like this!")
+		}
+
+		return nil, err
 	}
-	return clothings, nil
+
+	filter.CategoryId = id
+	return listClothes(ctx, s.db, filter)
 }
 
 func (s *CategoriesStore) GetOneCategory(ctx context.Context, id int) (*types.Category, error) {
 	var category types.Category
-	query := `SELECT c.id, c.name, c.description, c.is_featured, array_agg(ci.url) AS pictures FROM "category" AS c JOIN "category_image" AS "ci" ON c.id = ci.category_id WHERE c.id=$1 GROUP BY c.id, c.name, c.description `
+	query := `SELECT c.id, c.name, c.slug, c.parent_id, c.description, c.is_featured, array_agg(ci.url) AS pictures FROM "category" AS c JOIN "category_image" AS "ci" ON c.id = ci.category_id WHERE c.id=$1 AND c.deleted_at IS NULL GROUP BY c.id, c.name, c.description `
 
 	if err := s.db.QueryRowContext(ctx, query, id).Scan(
 		&category.Id,
 		&category.Name,
+		&category.Slug,
+		&category.ParentId,
 		&category.Description,
 		&category.IsFeatured,
 		pq.Array(&category.Pictures),
@@ -139,25 +279,76 @@ func (s *CategoriesStore) GetOneCategory(ctx context.Context, id int) (*types.Ca
 		return nil, err
 	}
 
+	images, err := getImages(ctx, s.db, "category_image", "category_id", []int{category.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	category.Images = images[category.Id]
 	return &category, nil
 }
 
+// ResolveCategorySlug returns the id and current slug of the category slug
+// points to, which may be one it had before a rename.
+func (s *CategoriesStore) ResolveCategorySlug(ctx context.Context, slug string) (int, string, error) {
+	return categorySlugs.resolveSlug(ctx, s.db, slug)
+}
+
+func (s *CategoriesStore) ResyncCategorySlugs(ctx context.Context) (int, error) {
+	return categorySlugs.resyncSlugs(ctx, s.db)
+}
+
+// EditCategory updates the category and its images. Renaming it moves it to a
+// new slug and keeps the old one around to redirect from.
 func (s *CategoriesStore) EditCategory(ctx context.Context, id int, payload types.CategoryDTO) (*types.Category, error) {
 	var newCategory types.Category
-	var newImagePictures types.Pictures
-	query := `UPDATE "category" SET name=$1, description=$2, is_featured=$3 WHERE id=$4 RETURNING id, name, description, is_featured`
-	imageQuery := `UPDATE "category_image" SET url=$1 WHERE category_id=$2 RETURNING id, url `
+	var oldName, oldSlug string
+	query := `UPDATE "category" SET name=$1, slug=$2, parent_id=$3, description=$4, is_featured=$5 WHERE id=$6 RETURNING id, name, slug, parent_id, description, is_featured`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, `SELECT name, slug FROM "category" WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&oldName, &oldSlug); err != nil {
+		return nil, err
+	}
+
+	if payload.ParentId != nil {
+		// Serialise moves so two of them can't build a cycle between them.
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('category_tree'))`); err != nil {
+			return nil, err
+		}
+
+		if err := checkCategoryParent(ctx, tx, id, payload.ParentId); err != nil {
+			return nil, err
+		}
+	}
+
+	slug := oldSlug
+	if utils.Slugify(payload.Name) != utils.Slugify(oldName) {
+		slug, err = categorySlugs.uniqueSlug(ctx, tx, payload.Name, id)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	err := s.db.QueryRowContext(
+	err = tx.QueryRowContext(
 		ctx,
 		query,
 		payload.Name,
+		slug,
+		payload.ParentId,
 		payload.Description,
 		payload.IsFeatured,
 		id,
 	).Scan(
 		&newCategory.Id,
 		&newCategory.Name,
+		&newCategory.Slug,
+		&newCategory.ParentId,
 		&newCategory.Description,
 		&newCategory.IsFeatured,
 	)
@@ -165,25 +356,149 @@ func (s *CategoriesStore) EditCategory(ctx context.Context, id int, payload type
 		return nil, err
 	}
 
-	for _, picture := range payload.Pictures {
-		err := s.db.QueryRowContext(
-			ctx,
-			imageQuery,
-			picture,
-			id,
-		).Scan(
-			&newImagePictures.Id,
-			&newImagePictures.Url,
-		)
-		if err != nil {
-			return nil, err
-		}
+	if err := categorySlugs.renameSlug(ctx, tx, id, oldSlug, slug); err != nil {
+		return nil, err
+	}
+
+	if err := syncImages(ctx, tx, "category_image", "category_id", id, payload.Pictures, payload.MediaIds); err != nil {
+		return nil, err
+	}
+
+	if err := ensurePrimaryImage(ctx, tx, "category_image", "category_id", id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
 
 	return &newCategory, nil
 }
 
+// DeleteCategory moves a category to the trash. Only empty categories can
+// go, so no clothes or subcategories are left pointing at the trash.
 func (s *CategoriesStore) DeleteCategory(ctx context.Context, id int) (*types.Category, error) {
+	var category types.Category
+	var hasClothes, hasSubcategories bool
+	checkQuery := `SELECT EXISTS (SELECT 1 FROM "clothes" WHERE category_id=$1 AND deleted_at IS NULL), EXISTS (SELECT 1 FROM "category" WHERE parent_id=$1 AND deleted_at IS NULL)`
+	query := `UPDATE "category" SET deleted_at=CURRENT_TIMESTAMP WHERE id=$1 AND deleted_at IS NULL RETURNING id, name, slug, parent_id, description, is_featured, deleted_at`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	// Lock the category so nothing is added to it while we check.
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM "category" WHERE id=$1 FOR UPDATE`, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.QueryRowContext(ctx, checkQuery, id).Scan(&hasClothes, &hasSubcategories); err != nil {
+		return nil, err
+	}
+
+	if hasClothes {
+		return nil, ErrCategoryHasClothes
+	}
+
+	if hasSubcategories {
+		return nil, ErrCategoryHasSubcategories
+	}
+
+	if err := tx.QueryRowContext(ctx, query, id).Scan(
+		&category.Id,
+		&category.Name,
+		&category.Slug,
+		&category.ParentId,
+		&category.Description,
+		&category.IsFeatured,
+		&category.DeletedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &category, nil
+}
+
+// RestoreCategory takes a category back out of the trash, as long as its
+// parent isn't in the trash too.
+func (s *CategoriesStore) RestoreCategory(ctx context.Context, id int) (*types.Category, error) {
+	var category types.Category
+	var parentTrashed bool
+	findQuery := `SELECT COALESCE(p.deleted_at IS NOT NULL, false) FROM "category" AS c LEFT JOIN "category" AS p ON p.id = c.parent_id WHERE c.id=$1 AND c.deleted_at IS NOT NULL`
+	query := `UPDATE "category" SET deleted_at=NULL WHERE id=$1 AND deleted_at IS NOT NULL RETURNING id, name, slug, parent_id, description, is_featured`
+
+	if err := s.db.QueryRowContext(ctx, findQuery, id).Scan(&parentTrashed); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotInTrash
+		}
+
+		return nil, err
+	}
+
+	if parentTrashed {
+		return nil, ErrParentCategoryTrashed
+	}
+
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&category.Id,
+		&category.Name,
+		&category.Slug,
+		&category.ParentId,
+		&category.Description,
+		&category.IsFeatured,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotInTrash
+		}
+
+		return nil, err
+	}
+
+	return &category, nil
+}
+
+// GetTrashedCategories lists the trash, most recently deleted first.
+func (s *CategoriesStore) GetTrashedCategories(ctx context.Context) ([]types.Category, error) {
+	categories := []types.Category{}
+	query := `SELECT id, name, slug, parent_id, description, is_featured, deleted_at FROM "category" WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var category types.Category
+		if err := rows.Scan(
+			&category.Id,
+			&category.Name,
+			&category.Slug,
+			&category.ParentId,
+			&category.Description,
+			&category.IsFeatured,
+			&category.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, category)
+	}
+
+	return categories, nil
+}
+
+// PurgeCategory permanently deletes a category from the trash along with its
+// images. It refuses while any clothes, even trashed ones, still point at it.
+func (s *CategoriesStore) PurgeCategory(ctx context.Context, id int) (*types.Category, error) {
 	tx, err := s.db.BeginTx(ctx, nil) // Start a transaction
 	if err != nil {
 		return nil, err
@@ -192,18 +507,36 @@ func (s *CategoriesStore) DeleteCategory(ctx context.Context, id int) (*types.Ca
 	// 1️⃣ Get the clothes details before deleting
 	var category types.Category
 	images := []string{}
-	query := `SELECT id, name, description, is_featured FROM "category" WHERE id=$1`
+	query := `SELECT id, name, slug, description, is_featured, deleted_at FROM "category" WHERE id=$1 FOR UPDATE`
 	err = tx.QueryRowContext(ctx, query, id).Scan(
 		&category.Id,
 		&category.Name,
+		&category.Slug,
 		&category.Description,
 		&category.IsFeatured,
+		&category.DeletedAt,
 	)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
 	}
 
+	if category.DeletedAt == nil {
+		tx.Rollback()
+		return nil, ErrNotInTrash
+	}
+
+	var hasClothes bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM "clothes" WHERE category_id=$1)`, id).Scan(&hasClothes); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if hasClothes {
+		tx.Rollback()
+		return nil, ErrCategoryHasClothes
+	}
+
 	// 2️⃣ Get related images
 	imageQuery := `SELECT url FROM "category_image" WHERE category_id=$1`
 	rows, err := tx.QueryContext(ctx, imageQuery, id)
@@ -234,6 +567,9 @@ func (s *CategoriesStore) DeleteCategory(ctx context.Context, id int) (*types.Ca
 	_, err = tx.ExecContext(ctx, `DELETE FROM "category" WHERE id=$1`, id)
 	if err != nil {
 		tx.Rollback()
+		if isForeignKeyViolation(err) {
+			return nil, ErrCategoryHasClothes
+		}
 		return nil, err
 	}
 
@@ -244,3 +580,9 @@ func (s *CategoriesStore) DeleteCategory(ctx context.Context, id int) (*types.Ca
 
 	return &category, nil // Return deleted item details
 }
+
+// UpdateCategoryImage changes the alt text, position, focal point or primary
+// flag of one of the category's images.
+func (s *CategoriesStore) UpdateCategoryImage(ctx context.Context, id int, imageId int, payload types.ImageDTO) (*types.Image, error) {
+	return updateImage(ctx, s.db, "category_image", "category_id", id, imageId, payload)
+}
@@ -3,19 +3,280 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+
 	// "log"
 
 	"github.com/lib/pq"
 	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
 )
 
 type ClothesStore struct {
 	db *sql.DB
 }
 
+var ErrInvalidCursor = errors.New("This cursor is invalid, start again from the first page")
+
+// clothesSort describes how a sort order lines clothes up. Every order is
+// tie-broken on id so keyset pagination never skips or repeats a row.
+type clothesSort struct {
+	column     string
+	descending bool
+	cursorType string
+}
+
+var clothesSorts = map[string]clothesSort{
+	types.ClothesSortNewest:    {"COALESCE(cl.created_at, 'epoch'::timestamp)", true, "timestamp"},
+	types.ClothesSortPriceAsc:  {effectivePrice, false, "bigint"},
+	types.ClothesSortPriceDesc: {effectivePrice, true, "bigint"},
+	types.ClothesSortName:      {"cl.name", false, "text"},
+}
+
+// clothesCursor is what the opaque next_cursor decodes to: the sort it was
+// issued for and the sort value and id of the last row on the page.
+type clothesCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Id    int    `json:"i"`
+}
+
+func encodeClothesCursor(sort string, last types.Clothes) string {
+	cursor := clothesCursor{Sort: sort, Id: last.Id}
+	switch sort {
+	case types.ClothesSortNewest:
+		cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	case types.ClothesSortPriceAsc, types.ClothesSortPriceDesc:
+		cursor.Value = fmt.Sprint(last.EffectivePrice.Amount)
+	case types.ClothesSortName:
+		cursor.Value = last.Name
+	}
+
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeClothesCursor(sort string, value string) (*clothesCursor, error) {
+	var cursor clothesCursor
+
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(decoded, &cursor); err != nil || cursor.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// clothesQuery collects WHERE conditions and their numbered arguments.
+type clothesQuery struct {
+	conditions []string
+	args       []any
+}
+
+func (q *clothesQuery) arg(value any) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (q *clothesQuery) where(condition string) {
+	q.conditions = append(q.conditions, condition)
+}
+
+func (q *clothesQuery) clause() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+
+	return "WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+// Facet dimensions, also used to leave a dimension's own filter out when
+// counting its facet.
+const (
+	facetCategory = "category"
+	facetPrice    = "price"
+	facetSize     = "size"
+	facetColour   = "colour"
+)
+
+// publishedClothes matches what the shop shows: published clothes and
+// scheduled ones whose time has come, even if the scheduler hasn't flipped
+// them yet, leaving out the trash. Drops in their early access window show
+// too, though only waitlist members can order them.
+const publishedClothes = `(cl.deleted_at IS NULL AND (cl.status = 'published' OR (cl.status = 'scheduled' AND COALESCE(cl.early_access_at, cl.publish_at) <= CURRENT_TIMESTAMP)))`
+
+// filterClothes turns the filter into WHERE conditions on "clothes" AS cl,
+// skipping the filter for the except dimension.
+func filterClothes(q *clothesQuery, filter types.ClothesFilter, except string) {
+	if !filter.IncludeUnpublished {
+		q.where(publishedClothes)
+	} else {
+		q.where("cl.deleted_at IS NULL")
+		if filter.Status != "" {
+			q.where("cl.status = " + q.arg(filter.Status))
+		}
+	}
+
+	// A category takes in everything under its subcategories too.
+	if filter.CategoryId != 0 && except != facetCategory {
+		q.where(`cl.category_id IN (WITH RECURSIVE subtree AS (SELECT id FROM "category" WHERE id = ` + q.arg(filter.CategoryId) + ` UNION SELECT c.id FROM "category" AS c JOIN subtree ON c.parent_id = subtree.id) SELECT id FROM subtree)`)
+	}
+
+	if filter.MinPrice != nil && except != facetPrice {
+		q.where(effectivePrice + " >= " + q.arg(*filter.MinPrice))
+	}
+
+	if filter.MaxPrice != nil && except != facetPrice {
+		q.where(effectivePrice + " <= " + q.arg(*filter.MaxPrice))
+	}
+
+	if filter.Size != "" && except != facetSize {
+		q.where(`cl.quantity > 0 AND EXISTS (SELECT 1 FROM "clothes_sizes" AS fs WHERE fs.clothes_id = cl.id AND fs.size ILIKE ` + q.arg(escapeLike(filter.Size)) + ` ESCAPE '\')`)
+	}
+
+	if filter.Colour != "" && except != facetColour {
+		q.where("lower(cl.colour) = lower(" + q.arg(filter.Colour) + ")")
+	}
+
+	if filter.Featured != nil {
+		q.where("cl.is_featured = " + q.arg(*filter.Featured))
+	}
+
+	if tsquery := searchQuery(filter.Search); tsquery != "" {
+		q.where("cl.search_vector @@ to_tsquery('english', " + q.arg(tsquery) + ")")
+	}
+}
+
+// listClothes returns one page of clothes matching filter, fetching a row
+// past the limit to know whether there is a next page.
+func listClothes(ctx context.Context, db *sql.DB, filter types.ClothesFilter) (*types.ClothesPage, error) {
+	page := types.ClothesPage{Data: []types.Clothes{}}
+	q := &clothesQuery{}
+
+	if filter.Sort == "" {
+		filter.Sort = types.ClothesSortNewest
+	}
+
+	sort, ok := clothesSorts[filter.Sort]
+	if !ok {
+		return nil, fmt.Errorf("No sort like this")
+	}
+
+	filterClothes(q, filter, "")
+
+	direction, comparison := "ASC", ">"
+	if sort.descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeClothesCursor(filter.Sort, filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		q.where(fmt.Sprintf("(%s, cl.id) %s (%s::%s, %s)", sort.column, comparison, q.arg(cursor.Value), sort.cursorType, q.arg(cursor.Id)))
+	}
+
+	query := fmt.Sprintf(`SELECT cl.id, cl.name, cl.slug, cl.sku, COALESCE(cl.price, 0), cl.compare_at_price, cl.sale_price, cl.sale_starts_at, cl.sale_ends_at, %s, cl.description, COALESCE(cl.quantity, 0), cl.category_id, cl.is_featured, cl.colour, COALESCE(cl.created_at, 'epoch'::timestamp), cl.status, cl.publish_at, cl.early_access_at, cl.launch_campaign, COALESCE(array_agg(DISTINCT i.url) FILTER (WHERE i.url IS NOT NULL), '{}') AS pictures, COALESCE(array_agg(DISTINCT s.size) FILTER (WHERE s.size IS NOT NULL), '{}') AS sizes FROM "clothes" AS cl LEFT JOIN "image" AS i ON i.clothes_id = cl.id LEFT JOIN "clothes_sizes" AS s ON s.clothes_id = cl.id %s GROUP BY cl.id ORDER BY %s %s, cl.id %s LIMIT %s`, effectivePrice, q.clause(), sort.column, direction, direction, q.arg(filter.Limit+1))
+
+	rows, err := db.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var clothing types.Clothes
+
+		if err := rows.Scan(
+			&clothing.Id,
+			&clothing.Name,
+			&clothing.Slug,
+			&clothing.Sku,
+			&clothing.Price,
+			&clothing.CompareAtPrice,
+			&clothing.SalePrice,
+			&clothing.SaleStartsAt,
+			&clothing.SaleEndsAt,
+			&clothing.EffectivePrice,
+			&clothing.Description,
+			&clothing.Quantity,
+			&clothing.CategoryId,
+			&clothing.IsFeatured,
+			&clothing.Colour,
+			&clothing.CreatedAt,
+			&clothing.Status,
+			&clothing.PublishAt,
+			&clothing.EarlyAccessAt,
+			&clothing.LaunchCampaign,
+			pq.Array(&clothing.Pictures),
+			pq.Array(&clothing.Sizes),
+		); err != nil {
+			return nil, err
+		}
+
+		setSaleBadge(&clothing)
+		page.Data = append(page.Data, clothing)
+	}
+
+	if len(page.Data) > filter.Limit {
+		page.Data = page.Data[:filter.Limit]
+		page.NextCursor = encodeClothesCursor(filter.Sort, page.Data[filter.Limit-1])
+	}
+
+	if err := withClothesImages(ctx, db, page.Data); err != nil {
+		return nil, err
+	}
+
+	if filter.Facets {
+		page.Facets, err = getClothesFacets(ctx, db, filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &page, nil
+}
+
+// withClothesImages loads the images of every clothing in clothes.
+func withClothesImages(ctx context.Context, db *sql.DB, clothes []types.Clothes) error {
+	ids := make([]int, len(clothes))
+	for i, clothing := range clothes {
+		ids[i] = clothing.Id
+	}
+
+	images, err := getImages(ctx, db, "image", "clothes_id", ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range clothes {
+		clothes[i].Images = images[clothes[i].Id]
+	}
+
+	return nil
+}
+
+func (s *ClothesStore) ListClothes(ctx context.Context, filter types.ClothesFilter) (*types.ClothesPage, error) {
+	return listClothes(ctx, s.db, filter)
+}
+
 func (s *ClothesStore) GetAllClothes() ([]types.Clothes, error) {
 	clothings := []types.Clothes{}
-	query := `SELECT cl.id, cl.name, cl.price, cl.description, cl.quantity, cl.category_id, array_agg(DISTINCT i.url) AS urls, array_agg(DISTINCT s.size) AS sizes FROM "clothes" AS cl JOIN "image" AS "i" ON i.clothes_id = cl.id LEFT JOIN "clothes_sizes" AS "s" ON cl.id = s.clothes_id GROUP BY  cl.id, cl.name, cl.price, cl.description, cl.quantity, cl.category_id`
+	query := `SELECT cl.id, cl.name, cl.slug, cl.price, cl.description, cl.quantity, cl.category_id, array_agg(DISTINCT i.url) AS urls, array_agg(DISTINCT s.size) AS sizes FROM "clothes" AS cl JOIN "image" AS "i" ON i.clothes_id = cl.id LEFT JOIN "clothes_sizes" AS "s" ON cl.id = s.clothes_id GROUP BY  cl.id, cl.name, cl.price, cl.description, cl.quantity, cl.category_id`
 
 	rows, err := s.db.Query(query)
 	if err != nil {
@@ -30,6 +291,7 @@ func (s *ClothesStore) GetAllClothes() ([]types.Clothes, error) {
 		if err := rows.Scan(
 			&clothing.Id,
 			&clothing.Name,
+			&clothing.Slug,
 			&clothing.Price,
 			&clothing.Description,
 			&clothing.Quantity,
@@ -48,13 +310,20 @@ func (s *ClothesStore) GetAllClothes() ([]types.Clothes, error) {
 
 func (s *ClothesStore) GetOneClothes(ctx context.Context, id int) (*types.Clothes, error) {
 	var clothing types.Clothes
-	query := `SELECT cl.id, cl.name, cl.price, cl.description, cl.quantity, array_agg(DISTINCT i.url) AS "pictures", array_agg(DISTINCT s.size) AS "sizes" FROM "clothes" AS cl JOIN "image" AS i ON cl.id = i.clothes_id LEFT JOIN "clothes_sizes" as "s" ON s.clothes_id = cl.id WHERE cl.id=$1 GROUP BY cl.id, cl.name, cl.price, cl.description, cl.quantity;
+	query := `SELECT cl.id, cl.name, cl.slug, cl.sku, cl.price, cl.compare_at_price, cl.sale_price, cl.sale_starts_at, cl.sale_ends_at, ` + effectivePrice + `, cl.description, cl.quantity, array_agg(DISTINCT i.url) AS "pictures", array_agg(DISTINCT s.size) AS "sizes" FROM "clothes" AS cl JOIN "image" AS i ON cl.id = i.clothes_id LEFT JOIN "clothes_sizes" as "s" ON s.clothes_id = cl.id WHERE cl.id=$1 AND ` + publishedClothes + ` GROUP BY cl.id, cl.name, cl.price, cl.description, cl.quantity;
 `
 
 	if err := s.db.QueryRowContext(ctx, query, id).Scan(
 		&clothing.Id,
 		&clothing.Name,
+		&clothing.Slug,
+		&clothing.Sku,
 		&clothing.Price,
+		&clothing.CompareAtPrice,
+		&clothing.SalePrice,
+		&clothing.SaleStartsAt,
+		&clothing.SaleEndsAt,
+		&clothing.EffectivePrice,
 		&clothing.Description,
 		&clothing.Quantity,
 		pq.Array(&clothing.Pictures),
@@ -63,6 +332,13 @@ func (s *ClothesStore) GetOneClothes(ctx context.Context, id int) (*types.Clothe
 		return nil, err
 	}
 
+	images, err := getImages(ctx, s.db, "image", "clothes_id", []int{clothing.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	clothing.Images = images[clothing.Id]
+	setSaleBadge(&clothing)
 	return &clothing, nil
 }
 
@@ -72,32 +348,72 @@ func (s *ClothesStore) CreateNewClothes(ctx context.Context, payload types.Cloth
 	var newImageSize types.Sizes
 
 	// log.Print(payload)
-	query := `INSERT INTO "clothes" (name, price, category_id, description, quantity) VALUES ($1, $2, $3, $4, $5) RETURNING id, name,  price, category_id, description, quantity`
-	imageQuery := `INSERT INTO "image" (clothes_id, url) VALUES ($1, $2) RETURNING id, url`
+	query := `INSERT INTO "clothes" AS cl (name, slug, price, category_id, description, quantity, is_featured, colour, status, publish_at, early_access_at, launch_campaign, sku, compare_at_price, sale_price, sale_starts_at, sale_ends_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE(NULLIF($9, ''), 'draft'), $10, $11, $12, COALESCE(NULLIF($13, ''), upper($2)), $14, $15, $16, $17) RETURNING id, name, slug, sku, price, compare_at_price, sale_price, sale_starts_at, sale_ends_at, ` + effectivePrice + `, category_id, description, quantity, is_featured, colour, created_at, status, publish_at, early_access_at, launch_campaign`
+	imageQuery := `INSERT INTO "image" (clothes_id, url, position) VALUES ($1, $2, $3) RETURNING id, url`
 	sizeQuery := `INSERT INTO "clothes_sizes" (clothes_id, size) VALUES ($1, $2) RETURNING id, size`
 
-	err := s.db.QueryRowContext(
+	// The clothing, its sizes and images go in together, so a bad media id
+	// doesn't leave a clothing without pictures behind.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	slug, err := clothesSlugs.uniqueSlug(ctx, tx, payload.Name, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(
 		ctx,
 		query,
 		payload.Name,
+		slug,
 		payload.Price,
 		payload.CategoryId,
 		payload.Description,
 		payload.Quantity,
+		payload.IsFeatured,
+		payload.Colour,
+		payload.Status,
+		payload.PublishAt,
+		payload.EarlyAccessAt,
+		payload.LaunchCampaign,
+		payload.Sku,
+		payload.CompareAtPrice,
+		payload.SalePrice,
+		payload.SaleStartsAt,
+		payload.SaleEndsAt,
 	).Scan(
 		&newClothing.Id,
 		&newClothing.Name,
+		&newClothing.Slug,
+		&newClothing.Sku,
 		&newClothing.Price,
+		&newClothing.CompareAtPrice,
+		&newClothing.SalePrice,
+		&newClothing.SaleStartsAt,
+		&newClothing.SaleEndsAt,
+		&newClothing.EffectivePrice,
 		&newClothing.CategoryId,
 		&newClothing.Description,
 		&newClothing.Quantity,
+		&newClothing.IsFeatured,
+		&newClothing.Colour,
+		&newClothing.CreatedAt,
+		&newClothing.Status,
+		&newClothing.PublishAt,
+		&newClothing.EarlyAccessAt,
+		&newClothing.LaunchCampaign,
 	)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, size := range payload.Sizes {
-		err := s.db.QueryRowContext(
+		err := tx.QueryRowContext(
 			ctx,
 			sizeQuery,
 			newClothing.Id,
@@ -111,12 +427,13 @@ func (s *ClothesStore) CreateNewClothes(ctx context.Context, payload types.Cloth
 		}
 	}
 
-	for _, picture := range payload.Pictures {
-		err := s.db.QueryRowContext(
+	for i, picture := range payload.Pictures {
+		err := tx.QueryRowContext(
 			ctx,
 			imageQuery,
 			newClothing.Id,
 			picture,
+			i,
 		).Scan(
 			&newImagePictures.Id,
 			&newImagePictures.Url,
@@ -126,14 +443,82 @@ func (s *ClothesStore) CreateNewClothes(ctx context.Context, payload types.Cloth
 		}
 	}
 
+	if err := attachMedia(ctx, tx, "image", "clothes_id", newClothing.Id, payload.MediaIds); err != nil {
+		return nil, err
+	}
+
+	if err := ensurePrimaryImage(ctx, tx, "image", "clothes_id", newClothing.Id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	setSaleBadge(&newClothing)
 	return &newClothing, nil
 }
 
-func (s *ClothesStore) GetClothesThroughName(ctx context.Context, searchString string) ([]types.Clothes, error) {
-	clothes := []types.Clothes{}
-	query := `SELECT cl.id, cl.name, cl.price, cl.description, cl.quantity, array_agg(DISTINCT i.url) AS "pictures", array_agg(DISTINCT s.size) AS "sizes" FROM "clothes" AS cl JOIN "image" AS i ON cl.id = i.clothes_id LEFT JOIN "clothes_sizes" as "s" ON s.clothes_id = cl.id WHERE cl.name ILIKE $1 GROUP BY cl.id, cl.name, cl.price, cl.description, cl.quantity;`
+// searchQuery turns what a shopper typed into a prefix tsquery where every
+// word has to match, e.g. "green hood" becomes green:* & hood:*. Anything that
+// isn't a letter or digit is dropped so the input can't break to_tsquery.
+func searchQuery(searchString string) string {
+	terms := []string{}
+	for _, word := range strings.FieldsFunc(searchString, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		terms = append(terms, strings.ToLower(word)+":*")
+	}
 
-	rows, err := s.db.QueryContext(ctx, query, "%"+searchString+"%")
+	return strings.Join(terms, " & ")
+}
+
+// ts_headline marks matches with these private use characters instead of
+// <mark>, so the text around them can be escaped before it's served as HTML.
+const (
+	highlightStart = "\uE000"
+	highlightStop  = "\uE001"
+)
+
+var highlightMarks = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// highlight escapes a ts_headline result and turns its marks into <mark>.
+func highlight(headline string) string {
+	return highlightMarks.Replace(html.EscapeString(headline))
+}
+
+// GetClothesThroughName runs filter.Search as a full-text search over name,
+// description and category name, best matches first, with <mark> highlighted
+// name and snippet that are otherwise HTML escaped.
+func (s *ClothesStore) GetClothesThroughName(ctx context.Context, filter types.ClothesFilter) ([]types.ClothesSearchResult, error) {
+	clothes := []types.ClothesSearchResult{}
+	tsquery := searchQuery(filter.Search)
+	if tsquery == "" {
+		return clothes, nil
+	}
+
+	// The rest of the filter narrows the matches the same way it does the
+	// listing and its facets. The search itself is matched below, ranked.
+	q := &clothesQuery{}
+	search := q.arg(tsquery)
+	others := filter
+	others.Search = ""
+	filterClothes(q, others, "")
+	q.where("cl.search_vector @@ search.query")
+
+	query := `WITH "search" AS (SELECT to_tsquery('english', ` + search + `) AS query)
+	SELECT cl.id, cl.name, cl.slug, COALESCE(cl.price, 0), cl.compare_at_price, cl.sale_price, cl.sale_starts_at, cl.sale_ends_at, ` + effectivePrice + `, cl.description, COALESCE(cl.quantity, 0), cl.category_id, cl.is_featured, cl.colour, COALESCE(cl.created_at, 'epoch'::timestamp), cl.status, cl.publish_at, cl.early_access_at, cl.launch_campaign,
+		ARRAY(SELECT DISTINCT i.url FROM "image" AS i WHERE i.clothes_id = cl.id AND i.url IS NOT NULL) AS pictures,
+		ARRAY(SELECT DISTINCT s.size FROM "clothes_sizes" AS s WHERE s.clothes_id = cl.id AND s.size IS NOT NULL) AS sizes,
+		ts_rank_cd(cl.search_vector, search.query) AS rank,
+		ts_headline('english', cl.name, search.query, 'HighlightAll=true, StartSel="` + highlightStart + `", StopSel="` + highlightStop + `"'),
+		ts_headline('english', cl.description, search.query, 'StartSel="` + highlightStart + `", StopSel="` + highlightStop + `", MaxFragments=2, MaxWords=18, MinWords=6')
+	FROM "clothes" AS cl CROSS JOIN "search"
+	` + q.clause() + `
+	ORDER BY rank DESC, cl.id
+	LIMIT ` + q.arg(filter.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, q.args...)
 	if err != nil {
 		return nil, err
 	}
@@ -141,33 +526,458 @@ func (s *ClothesStore) GetClothesThroughName(ctx context.Context, searchString s
 	defer rows.Close()
 
 	for rows.Next() {
-		var clothing types.Clothes
+		var clothing types.ClothesSearchResult
 
 		if err := rows.Scan(
 			&clothing.Id,
 			&clothing.Name,
+			&clothing.Slug,
 			&clothing.Price,
+			&clothing.CompareAtPrice,
+			&clothing.SalePrice,
+			&clothing.SaleStartsAt,
+			&clothing.SaleEndsAt,
+			&clothing.EffectivePrice,
 			&clothing.Description,
 			&clothing.Quantity,
+			&clothing.CategoryId,
+			&clothing.IsFeatured,
+			&clothing.Colour,
+			&clothing.CreatedAt,
+			&clothing.Status,
+			&clothing.PublishAt,
+			&clothing.EarlyAccessAt,
+			&clothing.LaunchCampaign,
 			pq.Array(&clothing.Pictures),
 			pq.Array(&clothing.Sizes),
+			&clothing.Rank,
+			&clothing.NameHighlight,
+			&clothing.Snippet,
 		); err != nil {
 			return nil, err
 		}
 
+		clothing.NameHighlight = highlight(clothing.NameHighlight)
+		clothing.Snippet = highlight(clothing.Snippet)
+
+		setSaleBadge(&clothing.Clothes)
+		clothes = append(clothes, clothing)
+	}
+
+	images, err := getImages(ctx, s.db, "image", "clothes_id", searchResultIds(clothes))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range clothes {
+		clothes[i].Images = images[clothes[i].Id]
+	}
+
+	return clothes, nil
+}
+
+func searchResultIds(clothes []types.ClothesSearchResult) []int {
+	ids := make([]int, len(clothes))
+	for i, clothing := range clothes {
+		ids[i] = clothing.Id
+	}
+
+	return ids
+}
+
+// SuggestClothes returns clothes and category names that look like what the
+// shopper has typed so far, using trigram word similarity so that both
+// half-typed words and typos still match.
+func (s *ClothesStore) SuggestClothes(ctx context.Context, searchString string, limit int) ([]types.Suggestion, error) {
+	suggestions := []types.Suggestion{}
+	query := `SELECT type, id, name, slug, score FROM (
+		SELECT 'clothes' AS type, cl.id, cl.name, cl.slug, word_similarity($1, cl.name) AS score FROM "clothes" AS cl WHERE ($1 <% cl.name OR cl.name ILIKE $3 || '%' ESCAPE '\') AND ` + publishedClothes + `
+		UNION ALL
+		SELECT 'category' AS type, c.id, c.name, c.slug, word_similarity($1, c.name) AS score FROM "category" AS c WHERE ($1 <% c.name OR c.name ILIKE $3 || '%' ESCAPE '\') AND c.deleted_at IS NULL
+	) AS suggestions ORDER BY score DESC, name LIMIT $2`
+
+	searchString = strings.TrimSpace(searchString)
+	rows, err := s.db.QueryContext(ctx, query, searchString, limit, escapeLike(searchString))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var suggestion types.Suggestion
+		if err := rows.Scan(&suggestion.Type, &suggestion.Id, &suggestion.Name, &suggestion.Slug, &suggestion.Score); err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, suggestion)
+	}
+
+	return suggestions, nil
+}
+
+// DidYouMean corrects each word of searchString to the closest word used in
+// clothes or category names and descriptions, as of the last
+// RefreshSearchLexicon. It returns "" when nothing changes, so there is
+// nothing better to offer.
+func (s *ClothesStore) DidYouMean(ctx context.Context, searchString string) (string, error) {
+	query := `SELECT word FROM "search_lexicon" WHERE word % $1 ORDER BY similarity(word, $1) DESC, word LIMIT 1`
+
+	words := strings.Fields(strings.ToLower(searchString))
+	corrected := false
+	for i, word := range words {
+		var closest string
+		err := s.db.QueryRowContext(ctx, query, word).Scan(&closest)
+		if err == sql.ErrNoRows {
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if closest != word {
+			words[i] = closest
+			corrected = true
+		}
+	}
+
+	if !corrected {
+		return "", nil
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// RefreshSearchLexicon rebuilds the words DidYouMean picks from, without
+// blocking searches while it runs.
+func (s *ClothesStore) RefreshSearchLexicon(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY "search_lexicon"`)
+	return err
+}
+
+// escapeLike makes LIKE treat % and _ in user input as themselves, for
+// patterns with ESCAPE '\'.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// EditClothes updates the clothing's details, sizes and images. Renaming it
+// moves it to a new slug and keeps the old one around to redirect from.
+func (s *ClothesStore) EditClothes(ctx context.Context, id int, payload types.ClothesDTO) (*types.Clothes, error) {
+	var clothing types.Clothes
+	var oldName, oldSlug string
+	query := `UPDATE "clothes" AS cl SET name=$1, slug=$2, price=$3, category_id=$4, description=$5, quantity=$6, is_featured=$7, colour=$8, status=COALESCE(NULLIF($9, ''), status), publish_at=$10, early_access_at=$11, launch_campaign=$12, early_access_started=early_access_started AND early_access_at IS NOT DISTINCT FROM $11, sku=COALESCE(NULLIF($14, ''), sku), compare_at_price=$15, sale_price=$16, sale_starts_at=$17, sale_ends_at=$18, updated_at=CURRENT_TIMESTAMP WHERE id=$13 RETURNING id, name, slug, sku, price, compare_at_price, sale_price, sale_starts_at, sale_ends_at, ` + effectivePrice + `, category_id, description, quantity, is_featured, colour, created_at, status, publish_at, early_access_at, launch_campaign`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, `SELECT name, slug FROM "clothes" WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&oldName, &oldSlug); err != nil {
+		return nil, err
+	}
+
+	slug := oldSlug
+	if utils.Slugify(payload.Name) != utils.Slugify(oldName) {
+		slug, err = clothesSlugs.uniqueSlug(ctx, tx, payload.Name, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.QueryRowContext(
+		ctx,
+		query,
+		payload.Name,
+		slug,
+		payload.Price,
+		payload.CategoryId,
+		payload.Description,
+		payload.Quantity,
+		payload.IsFeatured,
+		payload.Colour,
+		payload.Status,
+		payload.PublishAt,
+		payload.EarlyAccessAt,
+		payload.LaunchCampaign,
+		id,
+		payload.Sku,
+		payload.CompareAtPrice,
+		payload.SalePrice,
+		payload.SaleStartsAt,
+		payload.SaleEndsAt,
+	).Scan(
+		&clothing.Id,
+		&clothing.Name,
+		&clothing.Slug,
+		&clothing.Sku,
+		&clothing.Price,
+		&clothing.CompareAtPrice,
+		&clothing.SalePrice,
+		&clothing.SaleStartsAt,
+		&clothing.SaleEndsAt,
+		&clothing.EffectivePrice,
+		&clothing.CategoryId,
+		&clothing.Description,
+		&clothing.Quantity,
+		&clothing.IsFeatured,
+		&clothing.Colour,
+		&clothing.CreatedAt,
+		&clothing.Status,
+		&clothing.PublishAt,
+		&clothing.EarlyAccessAt,
+		&clothing.LaunchCampaign,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := clothesSlugs.renameSlug(ctx, tx, id, oldSlug, slug); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "clothes_sizes" WHERE clothes_id=$1`, id); err != nil {
+		return nil, err
+	}
+
+	for _, size := range payload.Sizes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO "clothes_sizes" (clothes_id, size) VALUES ($1, $2)`, id, size); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := syncImages(ctx, tx, "image", "clothes_id", id, payload.Pictures, payload.MediaIds); err != nil {
+		return nil, err
+	}
+
+	if err := ensurePrimaryImage(ctx, tx, "image", "clothes_id", id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	clothing.Sizes = payload.Sizes
+	images := []types.Clothes{clothing}
+	if err := withClothesImages(ctx, s.db, images); err != nil {
+		return nil, err
+	}
+
+	clothing = images[0]
+	setSaleBadge(&clothing)
+	return &clothing, nil
+}
+
+// PublishDueClothes flips every scheduled clothing whose time has come to
+// published and returns them.
+func (s *ClothesStore) PublishDueClothes(ctx context.Context) ([]types.Clothes, error) {
+	clothes := []types.Clothes{}
+	query := `UPDATE "clothes" SET status='published', updated_at=CURRENT_TIMESTAMP WHERE status='scheduled' AND publish_at <= CURRENT_TIMESTAMP RETURNING id, name, slug, publish_at, launch_campaign`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		clothing := types.Clothes{Status: types.ClothesStatusPublished}
+		if err := rows.Scan(&clothing.Id, &clothing.Name, &clothing.Slug, &clothing.PublishAt, &clothing.LaunchCampaign); err != nil {
+			return nil, err
+		}
+
 		clothes = append(clothes, clothing)
 	}
 
 	return clothes, nil
 }
 
-func (s *ClothesStore) EditClothes(ctx context.Context, id int) (*types.Clothes, error) {
-	// query := `UPDATE "clothes" SET name=$1, price=$2, category_id=$3, description=$4, quantity=$5 WHERE id=$6 RETURNING id, price, category_id, description, quantity`
-	// imageQuery := `UPDATE "image" SET url`
-	return nil, nil
+// StartDueLaunches marks every live clothing that wants a launch campaign
+// and hasn't had one as sent and returns them, however it came to be live.
+func (s *ClothesStore) StartDueLaunches(ctx context.Context) ([]types.Clothes, error) {
+	clothes := []types.Clothes{}
+	query := `UPDATE "clothes" SET launch_campaign_sent=true WHERE status='published' AND launch_campaign AND NOT launch_campaign_sent AND deleted_at IS NULL RETURNING id, name, slug, publish_at, launch_campaign`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		clothing := types.Clothes{Status: types.ClothesStatusPublished}
+		if err := rows.Scan(&clothing.Id, &clothing.Name, &clothing.Slug, &clothing.PublishAt, &clothing.LaunchCampaign); err != nil {
+			return nil, err
+		}
+
+		clothes = append(clothes, clothing)
+	}
+
+	return clothes, nil
 }
 
+// StartDueEarlyAccess marks every scheduled clothing whose early access
+// window has opened as started and returns them, so each window only starts
+// once.
+func (s *ClothesStore) StartDueEarlyAccess(ctx context.Context) ([]types.Clothes, error) {
+	clothes := []types.Clothes{}
+	query := `UPDATE "clothes" SET early_access_started=true WHERE status='scheduled' AND NOT early_access_started AND early_access_at <= CURRENT_TIMESTAMP RETURNING id, name, slug, publish_at, early_access_at, launch_campaign`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		clothing := types.Clothes{Status: types.ClothesStatusScheduled}
+		if err := rows.Scan(&clothing.Id, &clothing.Name, &clothing.Slug, &clothing.PublishAt, &clothing.EarlyAccessAt, &clothing.LaunchCampaign); err != nil {
+			return nil, err
+		}
+
+		clothes = append(clothes, clothing)
+	}
+
+	return clothes, nil
+}
+
+// GetClothesRelease returns the clothing's status and release times, whether
+// or not it is out yet.
+func (s *ClothesStore) GetClothesRelease(ctx context.Context, id int) (*types.Clothes, error) {
+	var clothing types.Clothes
+	query := `SELECT id, name, status, publish_at, early_access_at FROM "clothes" WHERE id=$1`
+
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(&clothing.Id, &clothing.Name, &clothing.Status, &clothing.PublishAt, &clothing.EarlyAccessAt); err != nil {
+		return nil, err
+	}
+
+	return &clothing, nil
+}
+
+// NextPublishAt returns when the next scheduled clothing goes live or opens
+// for early access, or nil when nothing is scheduled.
+func (s *ClothesStore) NextPublishAt(ctx context.Context) (*time.Time, error) {
+	var next *time.Time
+	query := `SELECT MIN(LEAST(publish_at, CASE WHEN early_access_started THEN NULL ELSE early_access_at END)) FROM "clothes" WHERE status='scheduled'`
+
+	err := s.db.QueryRowContext(ctx, query).Scan(&next)
+	return next, err
+}
+
+// ResolveClothesSlug returns the id and current slug of the clothing slug
+// points to, which may be one it had before a rename.
+func (s *ClothesStore) ResolveClothesSlug(ctx context.Context, slug string) (int, string, error) {
+	return clothesSlugs.resolveSlug(ctx, s.db, slug)
+}
+
+func (s *ClothesStore) ResyncClothesSlugs(ctx context.Context) (int, error) {
+	return clothesSlugs.resyncSlugs(ctx, s.db)
+}
+
+// DeleteClothes moves a clothing to the trash. It disappears from the shop
+// but keeps its images, sizes and order history until it is purged.
 func (s *ClothesStore) DeleteClothes(ctx context.Context, id int) (*types.Clothes, error) {
+	var clothing types.Clothes
+	query := `UPDATE "clothes" SET deleted_at=CURRENT_TIMESTAMP WHERE id=$1 AND deleted_at IS NULL RETURNING id, name, slug, price, category_id, description, quantity, deleted_at`
+
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&clothing.Id,
+		&clothing.Name,
+		&clothing.Slug,
+		&clothing.Price,
+		&clothing.CategoryId,
+		&clothing.Description,
+		&clothing.Quantity,
+		&clothing.DeletedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &clothing, nil
+}
+
+// RestoreClothes takes a clothing back out of the trash, as long as its
+// category isn't in the trash too.
+func (s *ClothesStore) RestoreClothes(ctx context.Context, id int) (*types.Clothes, error) {
+	var clothing types.Clothes
+	var categoryTrashed bool
+	findQuery := `SELECT c.deleted_at IS NOT NULL FROM "clothes" AS cl JOIN "category" AS c ON c.id = cl.category_id WHERE cl.id=$1 AND cl.deleted_at IS NOT NULL`
+	query := `UPDATE "clothes" SET deleted_at=NULL WHERE id=$1 AND deleted_at IS NOT NULL RETURNING id, name, slug, price, category_id, description, quantity`
+
+	if err := s.db.QueryRowContext(ctx, findQuery, id).Scan(&categoryTrashed); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotInTrash
+		}
+
+		return nil, err
+	}
+
+	if categoryTrashed {
+		return nil, ErrCategoryTrashed
+	}
+
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&clothing.Id,
+		&clothing.Name,
+		&clothing.Slug,
+		&clothing.Price,
+		&clothing.CategoryId,
+		&clothing.Description,
+		&clothing.Quantity,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotInTrash
+		}
+
+		return nil, err
+	}
+
+	return &clothing, nil
+}
+
+// GetTrashedClothes lists the trash, most recently deleted first.
+func (s *ClothesStore) GetTrashedClothes(ctx context.Context) ([]types.Clothes, error) {
+	clothes := []types.Clothes{}
+	query := `SELECT id, name, slug, COALESCE(price, 0), category_id, description, COALESCE(quantity, 0), deleted_at FROM "clothes" WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var clothing types.Clothes
+		if err := rows.Scan(
+			&clothing.Id,
+			&clothing.Name,
+			&clothing.Slug,
+			&clothing.Price,
+			&clothing.CategoryId,
+			&clothing.Description,
+			&clothing.Quantity,
+			&clothing.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		clothes = append(clothes, clothing)
+	}
+
+	return clothes, nil
+}
+
+// PurgeClothes permanently deletes a clothing from the trash, along with its
+// images and sizes. Clothes that have been ordered have to stay for the
+// order history.
+func (s *ClothesStore) PurgeClothes(ctx context.Context, id int) (*types.Clothes, error) {
 	tx, err := s.db.BeginTx(ctx, nil) // Start a transaction
 	if err != nil {
 		return nil, err
@@ -176,20 +986,38 @@ func (s *ClothesStore) DeleteClothes(ctx context.Context, id int) (*types.Clothe
 	// 1️⃣ Get the clothes details before deleting
 	var clothing types.Clothes
 	images := []string{}
-	query := `SELECT id, name, price, category_id, description, quantity FROM "clothes" WHERE id=$1`
+	query := `SELECT id, name, slug, price, category_id, description, quantity, deleted_at FROM "clothes" WHERE id=$1 FOR UPDATE`
 	err = tx.QueryRowContext(ctx, query, id).Scan(
 		&clothing.Id,
 		&clothing.Name,
+		&clothing.Slug,
 		&clothing.Price,
 		&clothing.CategoryId,
 		&clothing.Description,
 		&clothing.Quantity,
+		&clothing.DeletedAt,
 	)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
 	}
 
+	if clothing.DeletedAt == nil {
+		tx.Rollback()
+		return nil, ErrNotInTrash
+	}
+
+	var ordered bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM "clothes_bought" WHERE clothe_id=$1)`, id).Scan(&ordered); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if ordered {
+		tx.Rollback()
+		return nil, ErrClothesOrdered
+	}
+
 	// 2️⃣ Get related images
 	imageQuery := `SELECT url FROM "image" WHERE clothes_id=$1`
 	rows, err := tx.QueryContext(ctx, imageQuery, id)
@@ -247,6 +1075,9 @@ func (s *ClothesStore) DeleteClothes(ctx context.Context, id int) (*types.Clothe
 	_, err = tx.ExecContext(ctx, `DELETE FROM "clothes" WHERE id=$1`, id)
 	if err != nil {
 		tx.Rollback()
+		if isForeignKeyViolation(err) {
+			return nil, ErrClothesOrdered
+		}
 		return nil, err
 	}
 
@@ -257,3 +1088,9 @@ func (s *ClothesStore) DeleteClothes(ctx context.Context, id int) (*types.Clothe
 
 	return &clothing, nil // Return deleted item details
 }
+
+// UpdateClothesImage changes the alt text, position, focal point or primary
+// flag of one of the clothing's images.
+func (s *ClothesStore) UpdateClothesImage(ctx context.Context, id int, imageId int, payload types.ImageDTO) (*types.Image, error) {
+	return updateImage(ctx, s.db, "image", "clothes_id", id, imageId, payload)
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/poohda-go/types"
+)
+
+func TestClothesCursorRoundTrip(t *testing.T) {
+	last := types.Clothes{
+		Id:             42,
+		Name:           "Café Crème Hoodie",
+		EffectivePrice: types.NewMoney(2500000),
+		CreatedAt:      time.Date(2024, 5, 17, 9, 30, 15, 123456789, time.UTC),
+	}
+
+	tests := []struct {
+		sort  string
+		value string
+	}{
+		{types.ClothesSortNewest, "2024-05-17T09:30:15.123456789Z"},
+		{types.ClothesSortPriceAsc, "2500000"},
+		{types.ClothesSortPriceDesc, "2500000"},
+		{types.ClothesSortName, "Café Crème Hoodie"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sort, func(t *testing.T) {
+			cursor, err := decodeClothesCursor(test.sort, encodeClothesCursor(test.sort, last))
+			if err != nil {
+				t.Fatalf("decodeClothesCursor returned %v", err)
+			}
+
+			want := clothesCursor{Sort: test.sort, Value: test.value, Id: last.Id}
+			if *cursor != want {
+				t.Errorf("cursor = %+v, want %+v", *cursor, want)
+			}
+		})
+	}
+}
+
+func TestClothesCursorZeroPrice(t *testing.T) {
+	last := types.Clothes{Id: 1, EffectivePrice: types.NewMoney(0)}
+
+	cursor, err := decodeClothesCursor(types.ClothesSortPriceAsc, encodeClothesCursor(types.ClothesSortPriceAsc, last))
+	if err != nil {
+		t.Fatalf("decodeClothesCursor returned %v", err)
+	}
+
+	if cursor.Value != "0" {
+		t.Errorf("cursor value = %q, want %q", cursor.Value, "0")
+	}
+}
+
+func TestClothesCursorInvalid(t *testing.T) {
+	last := types.Clothes{Id: 7, Name: "Agbada"}
+
+	tests := []struct {
+		name   string
+		sort   string
+		cursor string
+	}{
+		{"not base64", types.ClothesSortName, "not a cursor!"},
+		{"not json", types.ClothesSortName, base64.RawURLEncoding.EncodeToString([]byte("agbada"))},
+		{"issued for another sort", types.ClothesSortNewest, encodeClothesCursor(types.ClothesSortName, last)},
+		{"padded base64", types.ClothesSortName, base64.URLEncoding.EncodeToString([]byte(`{"s":"name","v":"Agbada","i":7}`))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeClothesCursor(test.sort, test.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeClothesCursor(%q, %q) returned %v, want ErrInvalidCursor", test.sort, test.cursor, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/poohda-go/types"
+)
+
+type CurrenciesStore struct {
+	db *sql.DB
+}
+
+// GetExchangeRates returns every rate the admins have set, by currency.
+func (s *CurrenciesStore) GetExchangeRates(ctx context.Context) ([]types.ExchangeRate, error) {
+	rates := []types.ExchangeRate{}
+	query := `SELECT currency, rate, updated_at FROM "exchange_rate" ORDER BY currency`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var rate types.ExchangeRate
+		if err := rows.Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+// GetExchangeRate returns the rate for currency, or sql.ErrNoRows when none
+// has been set.
+func (s *CurrenciesStore) GetExchangeRate(ctx context.Context, currency string) (*types.ExchangeRate, error) {
+	var rate types.ExchangeRate
+	query := `SELECT currency, rate, updated_at FROM "exchange_rate" WHERE currency=$1`
+
+	if err := s.db.QueryRowContext(ctx, query, currency).Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return &rate, nil
+}
+
+// SetExchangeRate creates or replaces the rate for currency.
+func (s *CurrenciesStore) SetExchangeRate(ctx context.Context, currency string, rate float64) (*types.ExchangeRate, error) {
+	exchangeRate := types.ExchangeRate{Currency: currency}
+	query := `INSERT INTO "exchange_rate" (currency, rate) VALUES ($1, $2) ON CONFLICT (currency) DO UPDATE SET rate=EXCLUDED.rate, updated_at=CURRENT_TIMESTAMP RETURNING rate, updated_at`
+
+	if err := s.db.QueryRowContext(ctx, query, currency, rate).Scan(&exchangeRate.Rate, &exchangeRate.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return &exchangeRate, nil
+}
+
+// DeleteExchangeRate stops prices being shown in currency.
+func (s *CurrenciesStore) DeleteExchangeRate(ctx context.Context, currency string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM "exchange_rate" WHERE currency=$1`, currency)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/poohda-go/types"
+)
+
+// priceBuckets are the lower bounds, in kobo, of the price ranges in the
+// filters sidebar, the last one is open ended.
+var priceBuckets = []int64{0, 1000000, 2500000, 5000000, 10000000}
+
+// GetClothesFacets counts the clothes matching filter along each facet. A
+// facet ignores its own filter, so picking a size still shows how many
+// clothes every other size has.
+func (s *ClothesStore) GetClothesFacets(ctx context.Context, filter types.ClothesFilter) (*types.ClothesFacets, error) {
+	return getClothesFacets(ctx, s.db, filter)
+}
+
+func getClothesFacets(ctx context.Context, db *sql.DB, filter types.ClothesFilter) (*types.ClothesFacets, error) {
+	var err error
+	facets := types.ClothesFacets{}
+
+	q := &clothesQuery{}
+	filterClothes(q, filter, facetCategory)
+	facets.Categories, err = countFacet(ctx, db, fmt.Sprintf(`SELECT c.id::text, c.name, COUNT(*) FROM "clothes" AS cl JOIN "category" AS c ON c.id = cl.category_id %s GROUP BY c.id, c.name ORDER BY c.name`, q.clause()), q.args)
+	if err != nil {
+		return nil, err
+	}
+
+	q = &clothesQuery{}
+	q.where("cl.quantity > 0")
+	filterClothes(q, filter, facetSize)
+	facets.Sizes, err = countFacet(ctx, db, fmt.Sprintf(`SELECT upper(s.size), upper(s.size), COUNT(DISTINCT cl.id) FROM "clothes" AS cl JOIN "clothes_sizes" AS s ON s.clothes_id = cl.id %s GROUP BY upper(s.size) ORDER BY upper(s.size)`, q.clause()), q.args)
+	if err != nil {
+		return nil, err
+	}
+
+	q = &clothesQuery{}
+	q.where("cl.colour <> ''")
+	filterClothes(q, filter, facetColour)
+	facets.Colours, err = countFacet(ctx, db, fmt.Sprintf(`SELECT lower(cl.colour), initcap(lower(cl.colour)), COUNT(*) FROM "clothes" AS cl %s GROUP BY lower(cl.colour) ORDER BY lower(cl.colour)`, q.clause()), q.args)
+	if err != nil {
+		return nil, err
+	}
+
+	facets.PriceBuckets, err = countPriceBuckets(ctx, db, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &facets, nil
+}
+
+func countFacet(ctx context.Context, db *sql.DB, query string, args []any) ([]types.FacetCount, error) {
+	counts := []types.FacetCount{}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var count types.FacetCount
+		if err := rows.Scan(&count.Value, &count.Label, &count.Count); err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, count)
+	}
+
+	return counts, nil
+}
+
+// countPriceBuckets counts every bucket in a single pass with one FILTER
+// aggregate per bucket.
+func countPriceBuckets(ctx context.Context, db *sql.DB, filter types.ClothesFilter) ([]types.PriceBucketCount, error) {
+	buckets := make([]types.PriceBucketCount, len(priceBuckets))
+	columns := make([]string, len(priceBuckets))
+	targets := make([]any, len(priceBuckets))
+
+	for i, min := range priceBuckets {
+		buckets[i].Min = types.NewMoney(min)
+		columns[i] = "COUNT(*) FILTER (WHERE " + effectivePrice + " >= " + strconv.FormatInt(min, 10)
+		if i+1 < len(priceBuckets) {
+			max := types.NewMoney(priceBuckets[i+1])
+			buckets[i].Max = &max
+			columns[i] += " AND " + effectivePrice + " < " + strconv.FormatInt(max.Amount, 10)
+		}
+		columns[i] += ")"
+		targets[i] = &buckets[i].Count
+	}
+
+	q := &clothesQuery{}
+	filterClothes(q, filter, facetPrice)
+	query := fmt.Sprintf(`SELECT %s FROM "clothes" AS cl %s`, strings.Join(columns, ", "), q.clause())
+
+	if err := db.QueryRowContext(ctx, query, q.args...).Scan(targets...); err != nil {
+		return nil, err
+	}
+
+	return buckets, nil
+}
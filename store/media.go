@@ -0,0 +1,345 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/poohda-go/types"
+)
+
+type MediaStore struct {
+	db *sql.DB
+}
+
+func (s *MediaStore) CreateMedia(ctx context.Context, media types.Media) (*types.Media, error) {
+	query := `INSERT INTO "media" (id, storage, storage_key, url, content_type, size, original_name, width, height, variants) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING created_at`
+
+	// Only local uploads have variants of their own, the rest stay NULL.
+	var variants []byte
+	if media.Variants != nil {
+		var err error
+		if variants, err = json.Marshal(media.Variants); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.db.QueryRowContext(
+		ctx,
+		query,
+		media.Id,
+		media.Storage,
+		media.StorageKey,
+		media.Url,
+		media.ContentType,
+		media.Size,
+		media.OriginalName,
+		media.Width,
+		media.Height,
+		variants,
+	).Scan(&media.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &media, nil
+}
+
+func (s *MediaStore) GetOneMedia(ctx context.Context, id string) (*types.Media, error) {
+	var media types.Media
+	var variants []byte
+	query := `SELECT id, storage, storage_key, url, content_type, size, original_name, width, height, variants, created_at FROM "media" WHERE id=$1`
+
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&media.Id,
+		&media.Storage,
+		&media.StorageKey,
+		&media.Url,
+		&media.ContentType,
+		&media.Size,
+		&media.OriginalName,
+		&media.Width,
+		&media.Height,
+		&variants,
+		&media.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if len(variants) > 0 {
+		if err := json.Unmarshal(variants, &media.Variants); err != nil {
+			return nil, err
+		}
+	}
+
+	return &media, nil
+}
+
+// attachMedia adds uploads to a clothing or category's images, in the order
+// given. table is "image" or "category_image" and column its owner column.
+func attachMedia(ctx context.Context, q queryer, table string, column string, ownerId int, mediaIds []string) error {
+	if len(mediaIds) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf(`INSERT INTO "%s" (%s, url, media_id, position) SELECT $1, m.url, m.id, (SELECT COUNT(*) FROM "%s" WHERE %s = $1) + ids.position - 1 FROM unnest($2::text[]) WITH ORDINALITY AS ids(id, position) JOIN "media" AS m ON m.id = ids.id ORDER BY ids.position`, table, column, table, column)
+
+	result, err := q.ExecContext(ctx, query, ownerId, pq.Array(mediaIds))
+	if err != nil {
+		return err
+	}
+
+	attached, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if attached != int64(len(mediaIds)) {
+		return fmt.Errorf("Some of the media ids don't exist, upload them to /media first")
+	}
+
+	return nil
+}
+
+// syncImages makes the owner's images exactly pictures followed by mediaIds,
+// in that order. Images that stay keep their alt text, focal point and
+// primary flag, those not listed any more are removed.
+func syncImages(ctx context.Context, q queryer, table string, column string, ownerId int, pictures []string, mediaIds []string) error {
+	if pictures == nil {
+		pictures = []string{}
+	}
+
+	if mediaIds == nil {
+		mediaIds = []string{}
+	}
+
+	var known int
+	if err := q.QueryRowContext(ctx, `SELECT COUNT(DISTINCT id) FROM "media" WHERE id = ANY($1)`, pq.Array(mediaIds)).Scan(&known); err != nil {
+		return err
+	}
+
+	distinct := map[string]bool{}
+	for _, id := range mediaIds {
+		distinct[id] = true
+	}
+
+	if known != len(distinct) {
+		return fmt.Errorf("Some of the media ids don't exist, upload them to /media first")
+	}
+
+	queries := []string{
+		`DELETE FROM "%[1]s" WHERE %[2]s = $1 AND NOT ((media_id IS NULL AND url = ANY($2)) OR media_id = ANY($3))`,
+		`INSERT INTO "%[1]s" (%[2]s, url, position) SELECT DISTINCT $1, p.url, 0 FROM unnest($2::text[]) AS p(url) WHERE NOT EXISTS (SELECT 1 FROM "%[1]s" WHERE %[2]s = $1 AND media_id IS NULL AND url = p.url)`,
+		`INSERT INTO "%[1]s" (%[2]s, url, media_id, position) SELECT $1, m.url, m.id, 0 FROM "media" AS m WHERE m.id = ANY($3) AND NOT EXISTS (SELECT 1 FROM "%[1]s" WHERE %[2]s = $1 AND media_id = m.id)`,
+		`UPDATE "%[1]s" AS i SET position = ordered.position FROM (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY CASE WHEN media_id IS NULL THEN array_position($2::text[], url) ELSE cardinality($2::text[]) + array_position($3::text[], media_id) END, id) - 1 AS position
+			FROM "%[1]s" WHERE %[2]s = $1
+		) AS ordered WHERE i.id = ordered.id`,
+	}
+
+	for _, query := range queries {
+		if _, err := q.ExecContext(ctx, fmt.Sprintf(query, table, column), ownerId, pq.Array(pictures), pq.Array(mediaIds)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensurePrimaryImage makes the first image primary when none of the owner's
+// images is yet.
+func ensurePrimaryImage(ctx context.Context, q queryer, table string, column string, ownerId int) error {
+	query := fmt.Sprintf(`UPDATE "%s" SET is_primary = true WHERE id = (SELECT id FROM "%s" WHERE %s = $1 ORDER BY position, id LIMIT 1) AND NOT EXISTS (SELECT 1 FROM "%s" WHERE %s = $1 AND is_primary)`, table, table, column, table, column)
+
+	_, err := q.ExecContext(ctx, query, ownerId)
+	return err
+}
+
+// getImages returns the images of each owner in ids, in position order.
+func getImages(ctx context.Context, db *sql.DB, table string, column string, ids []int) (map[int][]types.Image, error) {
+	images := map[int][]types.Image{}
+	if len(ids) == 0 {
+		return images, nil
+	}
+
+	query := fmt.Sprintf(`SELECT i.%s, i.id, i.media_id, i.url, i.position, i.alt_text, i.is_primary, i.focal_x, i.focal_y, COALESCE(m.width, 0), COALESCE(m.height, 0), m.variants FROM "%s" AS i LEFT JOIN "media" AS m ON m.id = i.media_id WHERE i.%s = ANY($1) ORDER BY i.%s, i.position, i.id`, column, table, column, column)
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var ownerId int
+		var image types.Image
+		var variants []byte
+
+		if err := rows.Scan(
+			&ownerId,
+			&image.Id,
+			&image.MediaId,
+			&image.Url,
+			&image.Position,
+			&image.AltText,
+			&image.IsPrimary,
+			&image.FocalX,
+			&image.FocalY,
+			&image.Width,
+			&image.Height,
+			&variants,
+		); err != nil {
+			return nil, err
+		}
+
+		if err := setImageVariants(&image, variants); err != nil {
+			return nil, err
+		}
+
+		images[ownerId] = append(images[ownerId], image)
+	}
+
+	return images, rows.Err()
+}
+
+// setImageVariants fills in the image's variants from the ones made when it
+// was uploaded to local disk, from Cloudinary transformations, or failing both
+// the original URL.
+func setImageVariants(image *types.Image, stored []byte) error {
+	if len(stored) > 0 {
+		return json.Unmarshal(stored, &image.Variants)
+	}
+
+	if strings.Contains(image.Url, cloudinaryUploadPath) {
+		gravity := cloudinaryGravity(*image)
+		image.Variants = types.ImageVariants{
+			Thumb: cloudinaryTransform(image.Url, "c_fill,"+gravity+",w_200,h_200,q_auto"),
+			Card:  cloudinaryTransform(image.Url, "c_fill,"+gravity+",w_600,h_800,q_auto"),
+			Zoom:  cloudinaryTransform(image.Url, "c_limit,w_1600,h_1600,q_auto"),
+			WebP:  cloudinaryTransform(image.Url, "c_limit,w_1600,h_1600,q_auto,f_webp"),
+		}
+		return nil
+	}
+
+	image.Variants = types.ImageVariants{Thumb: image.Url, Card: image.Url, Zoom: image.Url}
+	return nil
+}
+
+// cloudinaryGravity centres crops on the image's focal point. Cloudinary reads
+// x and y of 1 or more as pixels, so a focal point on the far edge would land
+// in the corner. When the size is known the point is given in pixels, and
+// when it isn't it's kept just inside the relative range.
+func cloudinaryGravity(image types.Image) string {
+	if image.Width > 0 && image.Height > 0 {
+		x := int(math.Round(image.FocalX * float64(image.Width-1)))
+		y := int(math.Round(image.FocalY * float64(image.Height-1)))
+		return fmt.Sprintf("g_xy_center,x_%d,y_%d", x, y)
+	}
+
+	return fmt.Sprintf("g_xy_center,x_%.2f,y_%.2f", min(image.FocalX, 0.99), min(image.FocalY, 0.99))
+}
+
+// cloudinaryUploadPath is where delivery URLs take their transformations.
+const cloudinaryUploadPath = "/image/upload/"
+
+// cloudinaryTransform adds a transformation to a Cloudinary delivery URL. With
+// f_webp the extension is swapped too, so the URL says what it serves.
+func cloudinaryTransform(url string, transformation string) string {
+	transformed := strings.Replace(url, cloudinaryUploadPath, cloudinaryUploadPath+transformation+"/", 1)
+	if strings.Contains(transformation, "f_webp") {
+		if dot := strings.LastIndex(transformed, "."); dot > strings.LastIndex(transformed, "/") {
+			transformed = transformed[:dot]
+		}
+		transformed += ".webp"
+	}
+
+	return transformed
+}
+
+// updateImage changes one of the owner's images. Moving an image shifts the
+// ones in between along, so positions stay 0 to n-1.
+func updateImage(ctx context.Context, db *sql.DB, table string, column string, ownerId int, imageId int, payload types.ImageDTO) (*types.Image, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	// Lock every image of the owner, positions are shuffled across all of them.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`SELECT id FROM "%s" WHERE %s = $1 FOR UPDATE`, table, column), ownerId); err != nil {
+		return nil, err
+	}
+
+	var position, count int
+	query := fmt.Sprintf(`SELECT position, (SELECT COUNT(*) FROM "%s" WHERE %s = $1) FROM "%s" WHERE %s = $1 AND id = $2`, table, column, table, column)
+	if err := tx.QueryRowContext(ctx, query, ownerId, imageId).Scan(&position, &count); err != nil {
+		return nil, err
+	}
+
+	if payload.Position != nil && *payload.Position != position {
+		target := min(*payload.Position, count-1)
+		shift := fmt.Sprintf(`UPDATE "%s" SET position = position + 1 WHERE %s = $1 AND position >= $2 AND position < $3`, table, column)
+		from, to := target, position
+		if target > position {
+			shift = fmt.Sprintf(`UPDATE "%s" SET position = position - 1 WHERE %s = $1 AND position > $2 AND position <= $3`, table, column)
+			from, to = position, target
+		}
+
+		if _, err := tx.ExecContext(ctx, shift, ownerId, from, to); err != nil {
+			return nil, err
+		}
+
+		position = target
+	}
+
+	if payload.IsPrimary {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE "%s" SET is_primary = false WHERE %s = $1 AND id <> $2`, table, column), ownerId, imageId); err != nil {
+			return nil, err
+		}
+	}
+
+	update := fmt.Sprintf(`UPDATE "%s" SET position = $1, alt_text = COALESCE($2, alt_text), focal_x = COALESCE($3, focal_x), focal_y = COALESCE($4, focal_y), is_primary = is_primary OR $5 WHERE id = $6`, table)
+	if _, err := tx.ExecContext(ctx, update, position, payload.AltText, payload.FocalX, payload.FocalY, payload.IsPrimary, imageId); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	images, err := getImages(ctx, db, table, column, []int{ownerId})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, image := range images[ownerId] {
+		if image.Id == imageId {
+			return &image, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// syncImageUrls makes the owner's images exactly urls, in that order. Images
+// already there keep their alt text, focal point and media.
+func syncImageUrls(ctx context.Context, q queryer, table string, column string, ownerId int, urls []string) error {
+	queries := []string{
+		fmt.Sprintf(`DELETE FROM "%s" WHERE %s = $1 AND NOT (url = ANY($2))`, table, column),
+		fmt.Sprintf(`UPDATE "%s" AS i SET position = u.position - 1 FROM unnest($2::text[]) WITH ORDINALITY AS u(url, position) WHERE i.%s = $1 AND i.url = u.url`, table, column),
+		fmt.Sprintf(`INSERT INTO "%s" (%s, url, position) SELECT $1, u.url, u.position - 1 FROM unnest($2::text[]) WITH ORDINALITY AS u(url, position) WHERE NOT EXISTS (SELECT 1 FROM "%s" WHERE %s = $1 AND url = u.url)`, table, column, table, column),
+	}
+
+	for _, query := range queries {
+		if _, err := q.ExecContext(ctx, query, ownerId, pq.Array(urls)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
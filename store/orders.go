@@ -3,18 +3,68 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
 	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
 )
 
 type OrdersStore struct {
 	db *sql.DB
 }
 
+// Order references are what buyers quote, like PDA-7K3QX. They're random so
+// they give nothing away about how many orders there are.
+const (
+	orderReferencePrefix = "PDA-"
+	orderReferenceLength = 5
+)
+
+func newOrderReference() (string, error) {
+	code, err := utils.RandomCode(orderReferenceLength)
+	if err != nil {
+		return "", err
+	}
+
+	return orderReferencePrefix + code, nil
+}
+
+// orderColumns are the columns scanOrder reads, in order.
+const orderColumns = `o.id, o.reference, o.name, o.email, o.quantity, o.address, o.country, o.phone, o.sms_opt_in, COALESCE(o.subtotal, o.price, 0), o.tax, o.tax_rate, o.tax_inclusive, COALESCE(o.price, 0), o.is_delivered, o.created_at, o.invoice_number, o.payment_reference, o.paid_at, o.tracking_number`
+
+func scanOrder(row interface{ Scan(...any) error }, order *types.Order, extra ...any) error {
+	return row.Scan(append([]any{
+		&order.Id,
+		&order.Reference,
+		&order.Name,
+		&order.Email,
+		&order.Quantity,
+		&order.Address,
+		&order.Country,
+		&order.Phone,
+		&order.SmsOptIn,
+		&order.Subtotal,
+		&order.Tax,
+		&order.TaxRate,
+		&order.TaxInclusive,
+		&order.Price,
+		&order.IsDelivered,
+		&order.CreatedAt,
+		&order.InvoiceNumber,
+		&order.PaymentReference,
+		&order.PaidAt,
+		&order.TrackingNumber,
+	}, extra...)...)
+}
+
 func (s *OrdersStore) GetAllOrders() ([]types.Order, error) {
 	orders := []types.Order{}
-	query := `SELECT o.id, o.name, o.quantity, o.address, o.price, o.is_delivered, array_agg(cb.clothe_id) AS "clothes_ordered" FROM "orders" AS o JOIN "clothes_bought" AS "cb" ON cb.order_id = o.id GROUP BY o.id, o.name, o.quantity, o.address, o.price, o.is_delivered`
+	query := `SELECT ` + orderColumns + `, array_agg(cb.clothe_id) AS "clothes_ordered" FROM "orders" AS o JOIN "clothes_bought" AS "cb" ON cb.order_id = o.id GROUP BY o.id`
 
 	rows, err := s.db.Query(query)
 	if err != nil {
@@ -23,22 +73,29 @@ func (s *OrdersStore) GetAllOrders() ([]types.Order, error) {
 
 	defer rows.Close()
 
+	ids := []int{}
 	for rows.Next() {
 		var order types.Order
 
-		if err := rows.Scan(
-			&order.Id,
-			&order.Name,
-			&order.Quantity,
-			&order.Address,
-			&order.Price,
-			&order.IsDelivered,
-			pq.Array(&order.ClothesBought),
-		); err != nil {
+		if err := scanOrder(rows, &order, pq.Array(&order.ClothesBought)); err != nil {
 			return nil, err
 		}
 
 		orders = append(orders, order)
+		ids = append(ids, order.Id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lines, err := getOrderLines(context.Background(), s.db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range orders {
+		orders[i].Lines = lines[orders[i].Id]
 	}
 
 	return orders, nil
@@ -46,61 +103,344 @@ func (s *OrdersStore) GetAllOrders() ([]types.Order, error) {
 
 func (s *OrdersStore) GetASingleOrder(ctx context.Context, id int) (*types.Order, error) {
 	var order types.Order
-	query := `SELECT o.id, o.name, o.quantity, o.address, o.price, o.is_delivered, array_agg(cb.clothe_id) AS "clothes_ordered" FROM "orders" AS o JOIN "clothes_bought" AS "cb" ON cb.order_id = o.id WHERE id=$1 GROUP BY o.id, o.name, o.quantity, o.address, o.price, o.is_delivered`
+	query := `SELECT ` + orderColumns + `, array_agg(cb.clothe_id) AS "clothes_ordered" FROM "orders" AS o JOIN "clothes_bought" AS "cb" ON cb.order_id = o.id WHERE o.id=$1 GROUP BY o.id`
 
-	err := s.db.QueryRowContext(
-		ctx,
-		query,
-		id,
-	).Scan(
-		&order.Id,
-		&order.Name,
-		&order.Quantity,
-		&order.Address,
-		&order.Price,
-		&order.IsDelivered,
-		pq.Array(&order.ClothesBought),
-	)
+	if err := scanOrder(s.db.QueryRowContext(ctx, query, id), &order, pq.Array(&order.ClothesBought)); err != nil {
+		return nil, err
+	}
+
+	lines, err := getOrderLines(ctx, s.db, []int{order.Id})
 	if err != nil {
 		return nil, err
 	}
 
+	order.Lines = lines[order.Id]
+
+	if order.History, err = getOrderHistory(ctx, s.db, order.Id); err != nil {
+		return nil, err
+	}
+
 	return &order, nil
 }
 
-func (s *OrdersStore) CreateANewOrder(ctx context.Context, payload types.OrderDTO) (*types.Order, error) {
+// GetOrderByReference finds an order the way GetASingleOrder does, by the
+// reference buyers are given. Case and a missing prefix are forgiven since
+// references get read out over the phone.
+func (s *OrdersStore) GetOrderByReference(ctx context.Context, reference string) (*types.Order, error) {
+	reference = strings.ToUpper(strings.TrimSpace(reference))
+	if !strings.HasPrefix(reference, orderReferencePrefix) {
+		reference = orderReferencePrefix + reference
+	}
+
+	var id int
+	if err := s.db.QueryRowContext(ctx, `SELECT id FROM "orders" WHERE reference=$1`, reference).Scan(&id); err != nil {
+		return nil, err
+	}
+
+	return s.GetASingleOrder(ctx, id)
+}
+
+// CreateANewOrder stores the order with the prices and tax of quote, which
+// the caller works out from the clothes' current prices.
+func (s *OrdersStore) CreateANewOrder(ctx context.Context, payload types.OrderDTO, quote types.OrderQuote) (*types.Order, error) {
 	var order types.Order
-	var clothesBought int
-	query := `INSERT INTO "orders" (name, quantity, address, price, is_delivered) VALUES ($1, $2, $3, $4, $5) RETURNING name, quantity, address, price, is_delivered`
-	clothesBoughtQuery := `INSERT INTO "clothes_bought" (order_id, clothe_id, quantity) VALUES ($1, $2, $3) RETURNING id`
-
-	err := s.db.QueryRowContext(
-		ctx,
-		query,
-		payload.Name,
-		payload.Quantity,
-		payload.Address,
-		payload.Price,
-		payload.IsDelivered,
-	).Scan(
-		&order.Name,
-		&order.Quantity,
-		&order.Address,
-		&order.Price,
-		&order.IsDelivered,
-	)
+	// A clashing reference inserts nothing rather than failing, which would
+	// spoil the transaction, so another one can be drawn.
+	query := `INSERT INTO "orders" AS o (reference, name, email, quantity, address, country, phone, sms_opt_in, subtotal, tax, tax_rate, tax_inclusive, price, is_delivered) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) ON CONFLICT (reference) DO NOTHING RETURNING ` + orderColumns
+	clothesBoughtQuery := `INSERT INTO "clothes_bought" (order_id, clothe_id, quantity, unit_price, tax, total) VALUES ($1, $2, $3, $4, $5, $6)`
+	stockQuery := `UPDATE "clothes" SET quantity = quantity - $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND quantity >= $2`
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, clotheBought := range payload.ClothesBought {
-		err := s.db.QueryRowContext(ctx, clothesBoughtQuery, &order.Id, clotheBought.Id, clotheBought.Quantity).Scan(
-			&clothesBought,
-		)
+	defer tx.Rollback()
+
+	for attempt := 0; ; attempt++ {
+		reference, err := newOrderReference()
 		if err != nil {
 			return nil, err
 		}
+
+		err = scanOrder(tx.QueryRowContext(
+			ctx,
+			query,
+			reference,
+			payload.Name,
+			payload.Email,
+			payload.Quantity,
+			payload.Address,
+			quote.Country,
+			payload.Phone,
+			payload.SmsOptIn,
+			quote.Subtotal,
+			quote.Tax,
+			quote.TaxRate,
+			quote.TaxInclusive,
+			quote.Total,
+			payload.IsDelivered,
+		), &order)
+		if err == nil {
+			break
+		}
+
+		if err != sql.ErrNoRows || attempt == 2 {
+			return nil, err
+		}
 	}
 
+	for _, line := range quote.Lines {
+		if _, err := tx.ExecContext(ctx, clothesBoughtQuery, order.Id, line.ClothesId, line.Quantity, line.UnitPrice, line.Tax, line.Total); err != nil {
+			return nil, err
+		}
+		order.ClothesBought = append(order.ClothesBought, strconv.Itoa(line.ClothesId))
+
+		// Received returns put stock back, so orders have to take it out.
+		result, err := tx.ExecContext(ctx, stockQuery, line.ClothesId, line.Quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		if taken, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if taken == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrOutOfStock, line.Name)
+		}
+	}
+
+	if err := recordOrderStatus(ctx, tx, order.Id, types.OrderStatusPlaced, ""); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	order.Lines = quote.Lines
 	return &order, nil
 }
+
+var (
+	ErrOrderPaid      = errors.New("This order has already been paid for")
+	ErrOrderDelivered = errors.New("This order has already been delivered")
+	ErrOutOfStock     = errors.New("There isn't enough left in stock")
+)
+
+// MarkOrderPaid records the payment for an order and gives it the next
+// invoice number. It returns sql.ErrNoRows when there's no such order.
+func (s *OrdersStore) MarkOrderPaid(ctx context.Context, id int, reference string) (*types.Order, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var paid bool
+	if err := tx.QueryRowContext(ctx, `SELECT paid_at IS NOT NULL FROM "orders" WHERE id=$1 FOR UPDATE`, id).Scan(&paid); err != nil {
+		return nil, err
+	}
+
+	if paid {
+		return nil, ErrOrderPaid
+	}
+
+	// Locking the counter row makes concurrent payments take turns, so the
+	// numbers stay in order with no gaps or repeats.
+	var number int
+	if err := tx.QueryRowContext(ctx, `UPDATE "invoice_counter" SET last_number = last_number + 1 RETURNING last_number`).Scan(&number); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE "orders" SET invoice_number=$1, payment_reference=$2, paid_at=CURRENT_TIMESTAMP WHERE id=$3`, number, reference, id); err != nil {
+		return nil, err
+	}
+
+	if err := recordOrderStatus(ctx, tx, id, types.OrderStatusPaid, reference); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetASingleOrder(ctx, id)
+}
+
+// MarkOrderShipped records that the order is on its way with the courier's
+// tracking number. Shipping again replaces the number, say when a parcel is
+// resent. It returns sql.ErrNoRows when there's no such order.
+func (s *OrdersStore) MarkOrderShipped(ctx context.Context, id int, trackingNumber string) (*types.Order, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var delivered bool
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(is_delivered, FALSE) FROM "orders" WHERE id=$1 FOR UPDATE`, id).Scan(&delivered); err != nil {
+		return nil, err
+	}
+
+	if delivered {
+		return nil, ErrOrderDelivered
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE "orders" SET tracking_number=$1 WHERE id=$2`, trackingNumber, id); err != nil {
+		return nil, err
+	}
+
+	if err := recordOrderStatus(ctx, tx, id, types.OrderStatusShipped, trackingNumber); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetASingleOrder(ctx, id)
+}
+
+// MarkOrderDelivered records that the order reached the buyer, which is when
+// it can be returned. It returns sql.ErrNoRows when there's no such order.
+func (s *OrdersStore) MarkOrderDelivered(ctx context.Context, id int) (*types.Order, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var delivered bool
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(is_delivered, FALSE) FROM "orders" WHERE id=$1 FOR UPDATE`, id).Scan(&delivered); err != nil {
+		return nil, err
+	}
+
+	if delivered {
+		return nil, ErrOrderDelivered
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE "orders" SET is_delivered=TRUE WHERE id=$1`, id); err != nil {
+		return nil, err
+	}
+
+	if err := recordOrderStatus(ctx, tx, id, types.OrderStatusDelivered, ""); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetASingleOrder(ctx, id)
+}
+
+// StreamOrders calls fn with every order placed between from and to, with
+// its lines, oldest first. Either bound may be nil.
+func (s *OrdersStore) StreamOrders(ctx context.Context, from *time.Time, to *time.Time, fn func(types.Order) error) error {
+	query := `SELECT ` + orderColumns + `, cb.clothe_id, COALESCE(cl.sku, ''), COALESCE(cl.name, ''), COALESCE(cb.quantity, 0), cb.unit_price, cb.tax, cb.total
+	FROM "orders" AS o
+	JOIN "clothes_bought" AS cb ON cb.order_id = o.id
+	LEFT JOIN "clothes" AS cl ON cl.id = cb.clothe_id
+	WHERE ($1::timestamp IS NULL OR o.created_at >= $1) AND ($2::timestamp IS NULL OR o.created_at < $2)
+	ORDER BY o.created_at, o.id, cb.id`
+
+	rows, err := s.db.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	var current *types.Order
+	for rows.Next() {
+		var order types.Order
+		var line types.OrderLine
+
+		if err := scanOrder(rows, &order, &line.ClothesId, &line.Sku, &line.Name, &line.Quantity, &line.UnitPrice, &line.Tax, &line.Total); err != nil {
+			return err
+		}
+
+		if current != nil && current.Id != order.Id {
+			if err := fn(*current); err != nil {
+				return err
+			}
+			current = nil
+		}
+
+		if current == nil {
+			current = &order
+		}
+
+		current.Lines = append(current.Lines, line)
+		current.ClothesBought = append(current.ClothesBought, strconv.Itoa(line.ClothesId))
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if current != nil {
+		return fn(*current)
+	}
+
+	return nil
+}
+
+// getOrderLines loads the lines of each order, keyed by order id.
+func getOrderLines(ctx context.Context, db *sql.DB, ids []int) (map[int][]types.OrderLine, error) {
+	lines := map[int][]types.OrderLine{}
+	query := `SELECT cb.order_id, cb.clothe_id, COALESCE(cl.sku, ''), COALESCE(cl.name, ''), COALESCE(cb.quantity, 0), cb.unit_price, cb.tax, cb.total FROM "clothes_bought" AS cb LEFT JOIN "clothes" AS cl ON cl.id = cb.clothe_id WHERE cb.order_id = ANY($1) ORDER BY cb.id`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var orderId int
+		var line types.OrderLine
+
+		if err := rows.Scan(&orderId, &line.ClothesId, &line.Sku, &line.Name, &line.Quantity, &line.UnitPrice, &line.Tax, &line.Total); err != nil {
+			return nil, err
+		}
+
+		lines[orderId] = append(lines[orderId], line)
+	}
+
+	return lines, rows.Err()
+}
+
+// recordOrderStatus adds an entry to the order's status history.
+func recordOrderStatus(ctx context.Context, q queryer, orderId int, status string, note string) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO "order_status_history" (order_id, status, note) VALUES ($1, $2, $3)`, orderId, status, note)
+	return err
+}
+
+// getOrderHistory returns the order's status history, oldest first.
+func getOrderHistory(ctx context.Context, db *sql.DB, orderId int) ([]types.OrderStatus, error) {
+	history := []types.OrderStatus{}
+	query := `SELECT status, note, created_at FROM "order_status_history" WHERE order_id=$1 ORDER BY created_at, id`
+
+	rows, err := db.QueryContext(ctx, query, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var status types.OrderStatus
+		if err := rows.Scan(&status.Status, &status.Note, &status.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		history = append(history, status)
+	}
+
+	return history, rows.Err()
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/poohda-go/types"
+)
+
+type PreferencesStore struct {
+	db *sql.DB
+}
+
+// preferenceColumns maps each mail category to its column, which also keeps
+// arbitrary input out of the SET clause.
+var preferenceColumns = map[string]string{
+	types.MailCategoryLaunchNews: "launch_news",
+	types.MailCategoryPromotions: "promotions",
+}
+
+// GetEmailPreferences returns the saved preferences for email, treating
+// anyone who has never changed them as opted in to everything.
+func (s *PreferencesStore) GetEmailPreferences(ctx context.Context, email string) (*types.EmailPreferences, error) {
+	preferences := types.EmailPreferences{
+		Email:      email,
+		LaunchNews: true,
+		Promotions: true,
+	}
+	query := `SELECT launch_news, promotions FROM "email_preferences" WHERE email=$1`
+
+	err := s.db.QueryRowContext(ctx, query, email).Scan(
+		&preferences.LaunchNews,
+		&preferences.Promotions,
+	)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &preferences, nil
+}
+
+// UpdateEmailPreferences changes the categories set in payload and leaves
+// the rest as they were, opted in for anyone without saved preferences.
+func (s *PreferencesStore) UpdateEmailPreferences(ctx context.Context, email string, payload types.EmailPreferencesDTO) (*types.EmailPreferences, error) {
+	preferences := types.EmailPreferences{Email: email}
+	query := `INSERT INTO "email_preferences" AS p (email, launch_news, promotions) VALUES ($1, COALESCE($2, TRUE), COALESCE($3, TRUE))
+	ON CONFLICT (email) DO UPDATE SET launch_news=COALESCE($2, p.launch_news), promotions=COALESCE($3, p.promotions), updated_at=CURRENT_TIMESTAMP
+	RETURNING launch_news, promotions`
+
+	if err := s.db.QueryRowContext(
+		ctx,
+		query,
+		email,
+		payload.LaunchNews,
+		payload.Promotions,
+	).Scan(
+		&preferences.LaunchNews,
+		&preferences.Promotions,
+	); err != nil {
+		return nil, err
+	}
+
+	return &preferences, nil
+}
+
+func (s *PreferencesStore) SetEmailPreference(ctx context.Context, email string, category string, subscribed bool) error {
+	column, ok := preferenceColumns[category]
+	if !ok {
+		return fmt.Errorf("No email category like this")
+	}
+
+	query := fmt.Sprintf(`INSERT INTO "email_preferences" (email, %[1]s) VALUES ($1, $2) ON CONFLICT (email) DO UPDATE SET %[1]s=EXCLUDED.%[1]s, updated_at=CURRENT_TIMESTAMP`, column)
+
+	_, err := s.db.ExecContext(ctx, query, email, subscribed)
+	return err
+}
+
+// IsSubscribed reports whether email still accepts mail in category. Mail
+// without a category is transactional and always allowed.
+func (s *PreferencesStore) IsSubscribed(ctx context.Context, email string, category string) (bool, error) {
+	if category == "" {
+		return true, nil
+	}
+
+	preferences, err := s.GetEmailPreferences(ctx, email)
+	if err != nil {
+		return false, err
+	}
+
+	switch category {
+	case types.MailCategoryLaunchNews:
+		return preferences.LaunchNews, nil
+	case types.MailCategoryPromotions:
+		return preferences.Promotions, nil
+	}
+
+	return false, fmt.Errorf("No email category like this")
+}
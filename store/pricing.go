@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+
+	"github.com/poohda-go/types"
+)
+
+// effectivePrice is what a clothing on "clothes" AS cl costs right now: its
+// sale price while the sale runs, otherwise its price. Sorting, price filters
+// and facets all go by it.
+const effectivePrice = `(CASE WHEN cl.sale_price IS NOT NULL AND (cl.sale_starts_at IS NULL OR cl.sale_starts_at <= CURRENT_TIMESTAMP) AND (cl.sale_ends_at IS NULL OR cl.sale_ends_at > CURRENT_TIMESTAMP) THEN cl.sale_price ELSE COALESCE(cl.price, 0) END)`
+
+// setSaleBadge works out the sale badge from the clothing's prices. The was
+// price is the compare-at price when there is one above the price, so a
+// sale can be shown against the original price too.
+func setSaleBadge(clothing *types.Clothes) {
+	clothing.Sale = nil
+
+	was := clothing.Price
+	if clothing.CompareAtPrice != nil && clothing.CompareAtPrice.Amount > was.Amount {
+		was = *clothing.CompareAtPrice
+	}
+
+	if was.Amount <= clothing.EffectivePrice.Amount || was.Amount <= 0 {
+		return
+	}
+
+	clothing.Sale = &types.SaleBadge{
+		WasPrice:   was,
+		PercentOff: int(((was.Amount-clothing.EffectivePrice.Amount)*100 + was.Amount/2) / was.Amount),
+	}
+
+	if clothing.EffectivePrice.Amount < clothing.Price.Amount {
+		clothing.Sale.EndsAt = clothing.SaleEndsAt
+	}
+}
+
+// GetPriceHistory returns every price the clothing has had, newest first.
+func (s *ClothesStore) GetPriceHistory(ctx context.Context, id int) ([]types.PriceChange, error) {
+	history := []types.PriceChange{}
+	query := `SELECT price, compare_at_price, sale_price, sale_starts_at, sale_ends_at, changed_at FROM "price_history" WHERE clothes_id=$1 ORDER BY changed_at DESC, id DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var change types.PriceChange
+
+		if err := rows.Scan(
+			&change.Price,
+			&change.CompareAtPrice,
+			&change.SalePrice,
+			&change.SaleStartsAt,
+			&change.SaleEndsAt,
+			&change.ChangedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
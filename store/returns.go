@@ -0,0 +1,290 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/poohda-go/types"
+)
+
+var (
+	ErrOrderNotDelivered = errors.New("Only delivered orders can be returned")
+	ErrReturnStage       = errors.New("This return isn't at the stage for that")
+)
+
+type ReturnsStore struct {
+	db *sql.DB
+}
+
+// returnColumns are the columns scanReturn reads, in order. The amount is
+// worked out from the lines.
+const returnColumns = `r.id, r.order_id, r.status, r.opened_by, r.note, r.refund_amount, r.refund_reference, r.created_at, r.updated_at`
+
+func scanReturn(row interface{ Scan(...any) error }, item *types.Return) error {
+	return row.Scan(
+		&item.Id,
+		&item.OrderId,
+		&item.Status,
+		&item.OpenedBy,
+		&item.Note,
+		&item.RefundAmount,
+		&item.RefundReference,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
+}
+
+// CreateReturn opens a return against lines of a delivered order. Each line
+// can only be returned as many times as it was bought, counting returns that
+// weren't rejected.
+func (s *ReturnsStore) CreateReturn(ctx context.Context, orderId int, payload types.ReturnDTO, openedBy string) (*types.Return, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	// Locking the order makes returns against it take turns, so two can't
+	// both claim the last of a line.
+	var delivered bool
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(is_delivered, FALSE) FROM "orders" WHERE id=$1 FOR UPDATE`, orderId).Scan(&delivered); err != nil {
+		return nil, err
+	}
+
+	if !delivered {
+		return nil, ErrOrderNotDelivered
+	}
+
+	var returnId int
+	query := `INSERT INTO "return_request" (order_id, opened_by, note) VALUES ($1, $2, $3) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, orderId, openedBy, payload.Note).Scan(&returnId); err != nil {
+		return nil, err
+	}
+
+	for _, line := range payload.Lines {
+		var clothesBoughtId, bought, returned int
+		var total int64
+		query := `SELECT cb.id, COALESCE(cb.quantity, 0), cb.total,
+			COALESCE((SELECT SUM(rl.quantity) FROM "return_line" AS rl JOIN "return_request" AS r ON r.id = rl.return_id WHERE rl.clothes_bought_id = cb.id AND r.status <> 'rejected'), 0)
+		FROM "clothes_bought" AS cb WHERE cb.order_id=$1 AND cb.clothe_id=$2 ORDER BY cb.id LIMIT 1`
+
+		err := tx.QueryRowContext(ctx, query, orderId, line.ClothesId).Scan(&clothesBoughtId, &bought, &total, &returned)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("Clothing %d isn't on this order", line.ClothesId)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if line.Quantity > bought-returned {
+			return nil, fmt.Errorf("Only %d of clothing %d can still be returned", max(bought-returned, 0), line.ClothesId)
+		}
+
+		// What was paid for this many, tax included.
+		amount := total * int64(line.Quantity) / int64(bought)
+		query = `INSERT INTO "return_line" (return_id, clothes_bought_id, quantity, reason, amount) VALUES ($1, $2, $3, $4, $5)`
+		if _, err := tx.ExecContext(ctx, query, returnId, clothesBoughtId, line.Quantity, line.Reason, amount); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := recordOrderStatus(ctx, tx, orderId, types.OrderStatusReturnRequested, fmt.Sprintf("Return #%d", returnId)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetOneReturn(ctx, returnId)
+}
+
+// GetOneReturn returns the return with its lines, or sql.ErrNoRows.
+func (s *ReturnsStore) GetOneReturn(ctx context.Context, id int) (*types.Return, error) {
+	var item types.Return
+	query := `SELECT ` + returnColumns + ` FROM "return_request" AS r WHERE r.id=$1`
+
+	if err := scanReturn(s.db.QueryRowContext(ctx, query, id), &item); err != nil {
+		return nil, err
+	}
+
+	returns := []types.Return{item}
+	if err := s.withReturnLines(ctx, returns); err != nil {
+		return nil, err
+	}
+
+	return &returns[0], nil
+}
+
+// GetReturns lists returns newest first, for one order when orderId isn't 0
+// and at one stage when status isn't blank.
+func (s *ReturnsStore) GetReturns(ctx context.Context, orderId int, status string) ([]types.Return, error) {
+	returns := []types.Return{}
+	query := `SELECT ` + returnColumns + ` FROM "return_request" AS r WHERE ($1 = 0 OR r.order_id = $1) AND ($2 = '' OR r.status = $2) ORDER BY r.created_at DESC, r.id DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, orderId, status)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var item types.Return
+		if err := scanReturn(rows, &item); err != nil {
+			return nil, err
+		}
+
+		returns = append(returns, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.withReturnLines(ctx, returns); err != nil {
+		return nil, err
+	}
+
+	return returns, nil
+}
+
+// withReturnLines loads the lines of each return and adds up its amount.
+func (s *ReturnsStore) withReturnLines(ctx context.Context, returns []types.Return) error {
+	ids := []int{}
+	for i := range returns {
+		ids = append(ids, returns[i].Id)
+		returns[i].Lines = []types.ReturnLine{}
+		returns[i].Amount = types.NewMoney(0)
+	}
+
+	query := `SELECT rl.return_id, cb.clothe_id, COALESCE(cl.name, ''), rl.quantity, rl.reason, rl.amount FROM "return_line" AS rl JOIN "clothes_bought" AS cb ON cb.id = rl.clothes_bought_id LEFT JOIN "clothes" AS cl ON cl.id = cb.clothe_id WHERE rl.return_id = ANY($1) ORDER BY rl.id`
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	index := map[int]int{}
+	for i, item := range returns {
+		index[item.Id] = i
+	}
+
+	for rows.Next() {
+		var returnId int
+		var line types.ReturnLine
+		if err := rows.Scan(&returnId, &line.ClothesId, &line.Name, &line.Quantity, &line.Reason, &line.Amount); err != nil {
+			return err
+		}
+
+		item := &returns[index[returnId]]
+		item.Lines = append(item.Lines, line)
+		item.Amount.Amount += line.Amount.Amount
+	}
+
+	return rows.Err()
+}
+
+// moveReturn takes a return from one stage to the next, recording it in the
+// order's history, and runs then in the same transaction. It returns
+// ErrReturnStage when the return isn't at from, and sql.ErrNoRows when there's
+// no such return.
+func (s *ReturnsStore) moveReturn(ctx context.Context, id int, from string, to string, history string, note string, then func(*sql.Tx) error) (*types.Return, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var orderId int
+	var status string
+	if err := tx.QueryRowContext(ctx, `SELECT order_id, status FROM "return_request" WHERE id=$1 FOR UPDATE`, id).Scan(&orderId, &status); err != nil {
+		return nil, err
+	}
+
+	if status != from {
+		return nil, ErrReturnStage
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE "return_request" SET status=$1, updated_at=CURRENT_TIMESTAMP WHERE id=$2`, to, id); err != nil {
+		return nil, err
+	}
+
+	if then != nil {
+		if err := then(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	if history != "" {
+		entry := fmt.Sprintf("Return #%d", id)
+		if note != "" {
+			entry += ": " + note
+		}
+
+		if err := recordOrderStatus(ctx, tx, orderId, history, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetOneReturn(ctx, id)
+}
+
+func (s *ReturnsStore) ApproveReturn(ctx context.Context, id int, note string) (*types.Return, error) {
+	return s.moveReturn(ctx, id, types.ReturnStatusRequested, types.ReturnStatusApproved, types.OrderStatusReturnApproved, note, nil)
+}
+
+func (s *ReturnsStore) RejectReturn(ctx context.Context, id int, note string) (*types.Return, error) {
+	return s.moveReturn(ctx, id, types.ReturnStatusRequested, types.ReturnStatusRejected, types.OrderStatusReturnRejected, note, nil)
+}
+
+// ReceiveReturn records the clothes coming back and puts them back in stock.
+// Sizes share their clothing's stock, so that's what goes up.
+func (s *ReturnsStore) ReceiveReturn(ctx context.Context, id int, note string) (*types.Return, error) {
+	return s.moveReturn(ctx, id, types.ReturnStatusApproved, types.ReturnStatusReceived, types.OrderStatusReturnReceived, note, func(tx *sql.Tx) error {
+		query := `UPDATE "clothes" AS cl SET quantity = COALESCE(cl.quantity, 0) + returned.quantity, updated_at = CURRENT_TIMESTAMP
+		FROM (SELECT cb.clothe_id, SUM(rl.quantity) AS quantity FROM "return_line" AS rl JOIN "clothes_bought" AS cb ON cb.id = rl.clothes_bought_id WHERE rl.return_id = $1 GROUP BY cb.clothe_id) AS returned
+		WHERE cl.id = returned.clothe_id`
+
+		_, err := tx.ExecContext(ctx, query, id)
+		return err
+	})
+}
+
+// StartRefund claims a received return for refunding amount, so a second
+// refund can't go to the payment provider while the first is out.
+func (s *ReturnsStore) StartRefund(ctx context.Context, id int, amount types.Money) (*types.Return, error) {
+	return s.moveReturn(ctx, id, types.ReturnStatusReceived, types.ReturnStatusRefunding, "", "", func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE "return_request" SET refund_amount=$1 WHERE id=$2`, amount, id)
+		return err
+	})
+}
+
+// FinishRefund records the payment provider's reference for the refund.
+func (s *ReturnsStore) FinishRefund(ctx context.Context, id int, reference string, note string) (*types.Return, error) {
+	return s.moveReturn(ctx, id, types.ReturnStatusRefunding, types.ReturnStatusRefunded, types.OrderStatusRefunded, note, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE "return_request" SET refund_reference=$1 WHERE id=$2`, reference, id)
+		return err
+	})
+}
+
+// CancelRefund puts a return back to received when the payment provider
+// turned the refund down or never got it, so it can be tried again.
+func (s *ReturnsStore) CancelRefund(ctx context.Context, id int) (*types.Return, error) {
+	return s.moveReturn(ctx, id, types.ReturnStatusRefunding, types.ReturnStatusReceived, "", "", func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE "return_request" SET refund_amount=NULL WHERE id=$1`, id)
+		return err
+	})
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/poohda-go/utils"
+)
+
+// slugTable describes where a kind of record keeps its slug and the slugs it
+// used to have, which keep working as redirects.
+type slugTable struct {
+	table    string
+	history  string
+	column   string
+	fallback string
+}
+
+var (
+	clothesSlugs  = slugTable{table: "clothes", history: "clothes_slug_history", column: "clothes_id", fallback: "clothes"}
+	categorySlugs = slugTable{table: "category", history: "category_slug_history", column: "category_id", fallback: "category"}
+)
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// uniqueSlug picks a slug for name that no other record has now or had
+// before, adding -2, -3 and so on until it is free. id is the record being
+// renamed, or 0 for a new one, so a record can take back its own old slug.
+func (t slugTable) uniqueSlug(ctx context.Context, q queryer, name string, id int) (string, error) {
+	base := t.baseSlug(name)
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s" WHERE slug=$1 AND id<>$2) OR EXISTS (SELECT 1 FROM "%s" WHERE slug=$1 AND %s<>$2)`, t.table, t.history, t.column)
+
+	for n := 1; ; n++ {
+		slug := base
+		if n > 1 {
+			slug = fmt.Sprintf("%s-%d", base, n)
+		}
+
+		var taken bool
+		if err := q.QueryRowContext(ctx, query, slug, id).Scan(&taken); err != nil {
+			return "", err
+		}
+
+		if !taken {
+			return slug, nil
+		}
+	}
+}
+
+// baseSlug is the slug name gets before any -2, -3 is added to tell it apart.
+func (t slugTable) baseSlug(name string) string {
+	base := utils.Slugify(name)
+	if base == "" {
+		base = t.fallback
+	}
+
+	// An all digit slug would be mistaken for an id.
+	if _, err := strconv.Atoi(base); err == nil {
+		base = t.fallback + "-" + base
+	}
+
+	return base
+}
+
+// resyncSlugs gives every record whose slug doesn't come from its name the
+// way Slugify would make it a new one, keeping the old as a redirect. Slugs
+// backfilled in SQL when slugs were introduced followed slightly different
+// rules. It's safe to run again, and returns how many slugs changed.
+func (t slugTable) resyncSlugs(ctx context.Context, db *sql.DB) (int, error) {
+	type record struct {
+		id         int
+		name, slug string
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, name, slug FROM "%s" ORDER BY id`, t.table))
+	if err != nil {
+		return 0, err
+	}
+
+	stale := []record{}
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.id, &r.name, &r.slug); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		base := t.baseSlug(r.name)
+		suffix, numbered := strings.CutPrefix(r.slug, base+"-")
+		if _, err := strconv.Atoi(suffix); r.slug == base || (numbered && err == nil) {
+			continue
+		}
+
+		stale = append(stale, r)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range stale {
+		err := func() error {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+
+			defer tx.Rollback()
+
+			slug, err := t.uniqueSlug(ctx, tx, r.name, r.id)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE "%s" SET slug=$1 WHERE id=$2`, t.table), slug, r.id); err != nil {
+				return err
+			}
+
+			if err := t.renameSlug(ctx, tx, r.id, r.slug, slug); err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		}()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(stale), nil
+}
+
+// renameSlug remembers oldSlug so links to it can be redirected, and drops
+// newSlug from the history in case the record is taking it back.
+func (t slugTable) renameSlug(ctx context.Context, q queryer, id int, oldSlug string, newSlug string) error {
+	if oldSlug == newSlug {
+		return nil
+	}
+
+	query := fmt.Sprintf(`INSERT INTO "%s" (slug, %s) VALUES ($1, $2) ON CONFLICT (slug) DO UPDATE SET %s=EXCLUDED.%s, created_at=CURRENT_TIMESTAMP`, t.history, t.column, t.column, t.column)
+	if _, err := q.ExecContext(ctx, query, oldSlug, id); err != nil {
+		return err
+	}
+
+	_, err := q.ExecContext(ctx, fmt.Sprintf(`DELETE FROM "%s" WHERE slug=$1`, t.history), newSlug)
+	return err
+}
+
+// resolveSlug finds the record a slug, current or old, points to and returns
+// its id along with its current slug.
+func (t slugTable) resolveSlug(ctx context.Context, db *sql.DB, slug string) (int, string, error) {
+	var id int
+	var current string
+	query := fmt.Sprintf(`SELECT id, slug FROM "%s" WHERE slug=$1 UNION ALL SELECT t.id, t.slug FROM "%s" AS h JOIN "%s" AS t ON t.id = h.%s WHERE h.slug=$1 LIMIT 1`, t.table, t.history, t.table, t.column)
+
+	err := db.QueryRowContext(ctx, query, slug).Scan(&id, &current)
+	return id, current, err
+}
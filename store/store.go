@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/poohda-go/types"
 )
@@ -12,38 +13,145 @@ type Store struct {
 		Login()
 	}
 	Waitlist interface {
-		AddToWaitlist(ctx context.Context, payload types.SubscribePayload) error
+		AddToWaitlist(ctx context.Context, payload types.SubscribePayload) (*types.Waitlist, error)
 		GetAllWaitlistParticipants() ([]types.Waitlist, error)
+		ConfirmWaitlistEntry(ctx context.Context, email string) (*types.Waitlist, error)
+		PurgeUnconfirmedWaitlist(ctx context.Context, olderThan time.Duration) (int64, error)
+		GetWaitlistStanding(ctx context.Context, code string, boost int) (*types.WaitlistStanding, error)
+		GetWaitlistLeaderboard(ctx context.Context, boost int, limit int) ([]types.WaitlistStanding, error)
+		StreamWaitlist(ctx context.Context, from *time.Time, to *time.Time, fn func(types.Waitlist) error) error
+		GetExistingWaitlistEmails(ctx context.Context, emails []string) (map[string]bool, error)
+		ImportWaitlist(ctx context.Context, rows []types.WaitlistImportRow) (int64, error)
+		IssueAccessCode(ctx context.Context, email string) (string, error)
+		GetEarlyAccessMember(ctx context.Context, code string, email string) (*types.Waitlist, error)
+	}
+	Preferences interface {
+		GetEmailPreferences(ctx context.Context, email string) (*types.EmailPreferences, error)
+		UpdateEmailPreferences(ctx context.Context, email string, payload types.EmailPreferencesDTO) (*types.EmailPreferences, error)
+		SetEmailPreference(ctx context.Context, email string, category string, subscribed bool) error
+		IsSubscribed(ctx context.Context, email string, category string) (bool, error)
+	}
+	Antispam interface {
+		UseFormToken(ctx context.Context, nonce string, expiresAt time.Time) (bool, error)
+		IsDomainBlocked(ctx context.Context, domain string) (bool, error)
+		GetBlockedDomains(ctx context.Context) ([]types.BlockedDomain, error)
+		AddBlockedDomain(ctx context.Context, payload types.BlockedDomainDTO) (*types.BlockedDomain, error)
+		RemoveBlockedDomain(ctx context.Context, domain string) error
+		RecordRejection(ctx context.Context, rejection types.SubscribeRejection) error
+		GetRejections(ctx context.Context, reason string, since time.Time, limit int) ([]types.SubscribeRejection, error)
+		GetRejectionSummary(ctx context.Context, since time.Time) (map[string]int, error)
+	}
+	Campaigns interface {
+		CreateCampaign(ctx context.Context, payload types.CampaignDTO) (*types.Campaign, error)
+		GetAllCampaigns(ctx context.Context) ([]types.Campaign, error)
+		GetOneCampaign(ctx context.Context, id int) (*types.Campaign, error)
+		GetCampaignRecipients(ctx context.Context, id int, status string) ([]types.CampaignRecipient, error)
+		StopCampaign(ctx context.Context, id int) (*types.Campaign, error)
+		ClaimDueCampaigns(ctx context.Context) ([]types.Campaign, error)
+		GetSendingCampaigns(ctx context.Context) ([]types.Campaign, error)
+		PopulateCampaignRecipients(ctx context.Context, campaign types.Campaign) (int64, error)
+		GetPendingCampaignRecipients(ctx context.Context, id int, limit int) ([]types.CampaignRecipient, error)
+		MarkCampaignRecipient(ctx context.Context, id int, status string, reason string) error
+		RetryCampaignRecipient(ctx context.Context, id int, reason string, delay time.Duration) error
+		HasPendingCampaignRecipients(ctx context.Context, id int) (bool, error)
+		GetCampaignStatus(ctx context.Context, id int) (string, error)
+		FinishCampaign(ctx context.Context, id int) error
 	}
 	Categories interface {
 		GetAllCategories() ([]types.Category, error)
 		CreateNewCategory(context.Context, types.CategoryDTO) (*types.Category, error)
 		GetOneCategory(context.Context, int) (*types.Category, error)
-		GetAllClothesReferenceToACategory(context.Context, int) ([]types.Clothes, error)
+		ResolveCategorySlug(context.Context, string) (int, string, error)
+		ResyncCategorySlugs(ctx context.Context) (int, error)
+		GetCategoryTree(context.Context) ([]types.CategoryNode, error)
+		GetCategoryBreadcrumbs(context.Context, int) ([]types.Breadcrumb, error)
+		GetAllClothesReferenceToACategory(context.Context, int, types.ClothesFilter) (*types.ClothesPage, error)
 		EditCategory(context.Context, int, types.CategoryDTO) (*types.Category, error)
+		UpdateCategoryImage(context.Context, int, int, types.ImageDTO) (*types.Image, error)
 		DeleteCategory(context.Context, int) (*types.Category, error)
+		RestoreCategory(context.Context, int) (*types.Category, error)
+		GetTrashedCategories(context.Context) ([]types.Category, error)
+		PurgeCategory(context.Context, int) (*types.Category, error)
 	}
 	Clothes interface {
 		CreateNewClothes(ctx context.Context, payload types.ClothesDTO) (*types.Clothes, error)
 		GetAllClothes() ([]types.Clothes, error)
+		ListClothes(context.Context, types.ClothesFilter) (*types.ClothesPage, error)
+		GetClothesFacets(context.Context, types.ClothesFilter) (*types.ClothesFacets, error)
 		GetOneClothes(context.Context, int) (*types.Clothes, error)
-		GetClothesThroughName(context.Context, string) ([]types.Clothes, error)
-		EditClothes(context.Context, int) (*types.Clothes, error)
+		GetClothesThroughName(context.Context, types.ClothesFilter) ([]types.ClothesSearchResult, error)
+		SuggestClothes(context.Context, string, int) ([]types.Suggestion, error)
+		DidYouMean(context.Context, string) (string, error)
+		RefreshSearchLexicon(ctx context.Context) error
+		ResolveClothesSlug(context.Context, string) (int, string, error)
+		ResyncClothesSlugs(ctx context.Context) (int, error)
+		PublishDueClothes(context.Context) ([]types.Clothes, error)
+		StartDueEarlyAccess(context.Context) ([]types.Clothes, error)
+		StartDueLaunches(context.Context) ([]types.Clothes, error)
+		GetClothesRelease(context.Context, int) (*types.Clothes, error)
+		NextPublishAt(context.Context) (*time.Time, error)
+		EditClothes(context.Context, int, types.ClothesDTO) (*types.Clothes, error)
+		UpdateClothesImage(context.Context, int, int, types.ImageDTO) (*types.Image, error)
+		ImportCatalog(context.Context, []types.CatalogRow, bool) (*types.CatalogImportResult, error)
+		StreamCatalog(context.Context, func(types.CatalogRow) error) error
+		GetPriceHistory(context.Context, int) ([]types.PriceChange, error)
 		DeleteClothes(context.Context, int) (*types.Clothes, error)
+		RestoreClothes(context.Context, int) (*types.Clothes, error)
+		GetTrashedClothes(context.Context) ([]types.Clothes, error)
+		PurgeClothes(context.Context, int) (*types.Clothes, error)
 	}
 	Orders interface {
 		GetAllOrders() ([]types.Order, error)
 		GetASingleOrder(context.Context, int) (*types.Order, error)
-		CreateANewOrder(context.Context, types.OrderDTO) (*types.Order, error)
+		GetOrderByReference(ctx context.Context, reference string) (*types.Order, error)
+		CreateANewOrder(context.Context, types.OrderDTO, types.OrderQuote) (*types.Order, error)
+		StreamOrders(ctx context.Context, from *time.Time, to *time.Time, fn func(types.Order) error) error
+		MarkOrderPaid(ctx context.Context, id int, reference string) (*types.Order, error)
+		MarkOrderShipped(ctx context.Context, id int, trackingNumber string) (*types.Order, error)
+		MarkOrderDelivered(ctx context.Context, id int) (*types.Order, error)
+	}
+	Returns interface {
+		CreateReturn(ctx context.Context, orderId int, payload types.ReturnDTO, openedBy string) (*types.Return, error)
+		GetOneReturn(ctx context.Context, id int) (*types.Return, error)
+		GetReturns(ctx context.Context, orderId int, status string) ([]types.Return, error)
+		ApproveReturn(ctx context.Context, id int, note string) (*types.Return, error)
+		RejectReturn(ctx context.Context, id int, note string) (*types.Return, error)
+		ReceiveReturn(ctx context.Context, id int, note string) (*types.Return, error)
+		StartRefund(ctx context.Context, id int, amount types.Money) (*types.Return, error)
+		FinishRefund(ctx context.Context, id int, reference string, note string) (*types.Return, error)
+		CancelRefund(ctx context.Context, id int) (*types.Return, error)
+	}
+	Taxes interface {
+		GetTaxRates(context.Context) ([]types.TaxRate, error)
+		GetTaxRate(context.Context, string) (*types.TaxRate, error)
+		SetTaxRate(context.Context, string, types.TaxRateDTO) (*types.TaxRate, error)
+		DeleteTaxRate(context.Context, string) error
+	}
+	Currencies interface {
+		GetExchangeRates(context.Context) ([]types.ExchangeRate, error)
+		GetExchangeRate(context.Context, string) (*types.ExchangeRate, error)
+		SetExchangeRate(context.Context, string, float64) (*types.ExchangeRate, error)
+		DeleteExchangeRate(context.Context, string) error
+	}
+	Media interface {
+		CreateMedia(context.Context, types.Media) (*types.Media, error)
+		GetOneMedia(context.Context, string) (*types.Media, error)
 	}
 }
 
 func NewStore(db *sql.DB) *Store {
 	return &Store{
 		// Auth:       &AuthStore{db},
-		Waitlist:   &WaitlistStore{db},
-		Categories: &CategoriesStore{db},
-		Clothes:    &ClothesStore{db},
-		Orders:     &OrdersStore{db},
+		Waitlist:    &WaitlistStore{db},
+		Preferences: &PreferencesStore{db},
+		Campaigns:   &CampaignsStore{db},
+		Antispam:    &AntispamStore{db},
+		Categories:  &CategoriesStore{db},
+		Clothes:     &ClothesStore{db},
+		Orders:      &OrdersStore{db},
+		Media:       &MediaStore{db},
+		Currencies:  &CurrenciesStore{db},
+		Taxes:       &TaxesStore{db},
+		Returns:     &ReturnsStore{db},
 	}
 }
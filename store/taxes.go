@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/poohda-go/types"
+)
+
+type TaxesStore struct {
+	db *sql.DB
+}
+
+// GetTaxRates returns every country's tax rate, by country.
+func (s *TaxesStore) GetTaxRates(ctx context.Context) ([]types.TaxRate, error) {
+	rates := []types.TaxRate{}
+	query := `SELECT country, name, rate, inclusive, updated_at FROM "tax_rate" ORDER BY country`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var rate types.TaxRate
+		if err := rows.Scan(&rate.Country, &rate.Name, &rate.Rate, &rate.Inclusive, &rate.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+// GetTaxRate returns the rate for country, or sql.ErrNoRows when orders
+// shipping there aren't taxed.
+func (s *TaxesStore) GetTaxRate(ctx context.Context, country string) (*types.TaxRate, error) {
+	var rate types.TaxRate
+	query := `SELECT country, name, rate, inclusive, updated_at FROM "tax_rate" WHERE country=$1`
+
+	if err := s.db.QueryRowContext(ctx, query, country).Scan(&rate.Country, &rate.Name, &rate.Rate, &rate.Inclusive, &rate.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return &rate, nil
+}
+
+// SetTaxRate creates or replaces the rate for country. Orders already placed
+// keep the rate they were taxed at.
+func (s *TaxesStore) SetTaxRate(ctx context.Context, country string, payload types.TaxRateDTO) (*types.TaxRate, error) {
+	rate := types.TaxRate{Country: country}
+	query := `INSERT INTO "tax_rate" (country, name, rate, inclusive) VALUES ($1, $2, $3, $4) ON CONFLICT (country) DO UPDATE SET name=EXCLUDED.name, rate=EXCLUDED.rate, inclusive=EXCLUDED.inclusive, updated_at=CURRENT_TIMESTAMP RETURNING name, rate, inclusive, updated_at`
+
+	if err := s.db.QueryRowContext(ctx, query, country, payload.Name, payload.Rate, payload.Inclusive).Scan(&rate.Name, &rate.Rate, &rate.Inclusive, &rate.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return &rate, nil
+}
+
+// DeleteTaxRate stops taxing orders shipping to country.
+func (s *TaxesStore) DeleteTaxRate(ctx context.Context, country string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM "tax_rate" WHERE country=$1`, country)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
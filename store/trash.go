@@ -0,0 +1,22 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Errors for trash operations that would break referential integrity.
+var (
+	ErrNotInTrash               = errors.New("Only items in the trash can be restored or purged")
+	ErrClothesOrdered           = errors.New("This clothing has been ordered, so it has to stay in the trash for the order history")
+	ErrCategoryTrashed          = errors.New("Its category is in the trash, restore the category first")
+	ErrParentCategoryTrashed    = errors.New("Its parent category is in the trash, restore the parent first")
+	ErrCategoryHasClothes       = errors.New("This category still has clothes, move or delete them first")
+	ErrCategoryHasSubcategories = errors.New("This category still has subcategories, move or delete them first")
+)
+
+func isForeignKeyViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23503"
+}
@@ -4,53 +4,121 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/poohda-go/types"
+	"github.com/poohda-go/utils"
 )
 
+const (
+	referralCodeLength = 8
+	accessCodeLength   = 10
+)
+
+// standingsQuery ranks confirmed members by signup order, moving each one up
+// $1 places for every confirmed friend they referred.
+const standingsQuery = `WITH "referrals" AS (
+	SELECT w.id, w.name, w.email, w.referral_code,
+		(SELECT COUNT(*) FROM "waitlist" AS r WHERE r.referred_by = w.id AND r.confirmed_at IS NOT NULL) AS referrals,
+		ROW_NUMBER() OVER (ORDER BY w.created_at, w.id) AS signup_rank
+	FROM "waitlist" AS w WHERE w.confirmed_at IS NOT NULL
+), "standings" AS (
+	SELECT name, email, referral_code, referrals,
+		ROW_NUMBER() OVER (ORDER BY signup_rank - referrals * $1, signup_rank) AS position,
+		COUNT(*) OVER () AS total
+	FROM "referrals"
+)`
+
 type WaitlistStore struct {
 	db *sql.DB
 }
 
-func (s *WaitlistStore) AddToWaitlist(ctx context.Context, payload types.SubscribePayload) error {
-	var waitlist types.SubscribePayload
-	var id int
-	findUserQuery := `SELECT id FROM "waitlist" WHERE email=$1`
+// AddToWaitlist signs someone up unconfirmed. Signing up again before
+// confirming hands back the existing entry, so the confirmation mail can be
+// sent again.
+func (s *WaitlistStore) AddToWaitlist(ctx context.Context, payload types.SubscribePayload) (*types.Waitlist, error) {
+	var waitlist types.Waitlist
+	var referredBy sql.NullInt64
+	// Emails are matched ignoring case, the same as imports.
+	findUserQuery := `SELECT name, email, number, sms_opt_in, referral_code, confirmed_at FROM "waitlist" WHERE lower(email)=lower($1)`
 	if err := s.db.QueryRowContext(
 		ctx,
 		findUserQuery,
 		payload.Email,
-	).Scan(&id); err == nil {
-		return fmt.Errorf("You have already joined the circle")
+	).Scan(
+		&waitlist.Name,
+		&waitlist.Email,
+		&waitlist.Number,
+		&waitlist.SmsOptIn,
+		&waitlist.ReferralCode,
+		&waitlist.ConfirmedAt,
+	); err == nil {
+		if waitlist.ConfirmedAt != nil {
+			return nil, fmt.Errorf("You have already joined the circle")
+		}
+
+		// Unconfirmed entries are purged by age, so the clock starts again
+		// with the new link.
+		if _, err := s.db.ExecContext(ctx, `UPDATE "waitlist" SET created_at = CURRENT_TIMESTAMP WHERE email=$1 AND confirmed_at IS NULL`, waitlist.Email); err != nil {
+			return nil, fmt.Errorf("Database error: %v", err)
+		}
+
+		return &waitlist, nil
 	} else {
 		if err != sql.ErrNoRows {
 			// Handle unexpected database errors
-			return fmt.Errorf("Database error: %v", err)
+			return nil, fmt.Errorf("Database error: %v", err)
 		}
 	}
-	// fmt.Print(&id)
-
-	query := `INSERT INTO "waitlist" (name, email, number) VALUES ($1, $2, $3) RETURNING name, email`
 
-	if err := s.db.QueryRowContext(
-		ctx,
-		query,
-		payload.Name,
-		payload.Email,
-		payload.Number,
-	).Scan(
-		&waitlist.Name,
-		&waitlist.Email,
-	); err != nil {
-		return err
+	// An unknown referral code shouldn't cost us the signup, so it is ignored.
+	if payload.Ref != "" {
+		findReferrerQuery := `SELECT id FROM "waitlist" WHERE referral_code=$1`
+		if err := s.db.QueryRowContext(ctx, findReferrerQuery, strings.ToUpper(payload.Ref)).Scan(&referredBy); err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("Database error: %v", err)
+		}
 	}
 
-	return nil
+	query := `INSERT INTO "waitlist" (name, email, number, sms_opt_in, referral_code, referred_by) VALUES ($1, $2, $3, $4, $5, $6) RETURNING name, email, number, sms_opt_in, referral_code`
+
+	for attempt := 0; ; attempt++ {
+		code, err := utils.RandomCode(referralCodeLength)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.db.QueryRowContext(
+			ctx,
+			query,
+			payload.Name,
+			payload.Email,
+			payload.Number,
+			payload.SmsOptIn,
+			code,
+			referredBy,
+		).Scan(
+			&waitlist.Name,
+			&waitlist.Email,
+			&waitlist.Number,
+			&waitlist.SmsOptIn,
+			&waitlist.ReferralCode,
+		)
+		if err == nil {
+			return &waitlist, nil
+		}
+
+		// Retry the rare referral code collision, anything else is fatal.
+		if pqErr, ok := err.(*pq.Error); !ok || pqErr.Constraint != "waitlist_referral_code_key" || attempt == 2 {
+			return nil, err
+		}
+	}
 }
 
 func (s *WaitlistStore) GetAllWaitlistParticipants() ([]types.Waitlist, error) {
 	waitlst := []types.Waitlist{}
-	query := `SELECT name, email, number FROM "waitlist"`
+	query := `SELECT name, email, number, referral_code, confirmed_at, created_at FROM "waitlist" WHERE confirmed_at IS NOT NULL`
 
 	rows, err := s.db.Query(query)
 	if err != nil {
@@ -66,6 +134,9 @@ func (s *WaitlistStore) GetAllWaitlistParticipants() ([]types.Waitlist, error) {
 			&individualInWaiting.Name,
 			&individualInWaiting.Email,
 			&individualInWaiting.Number,
+			&individualInWaiting.ReferralCode,
+			&individualInWaiting.ConfirmedAt,
+			&individualInWaiting.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -75,3 +146,236 @@ func (s *WaitlistStore) GetAllWaitlistParticipants() ([]types.Waitlist, error) {
 
 	return waitlst, nil
 }
+
+func (s *WaitlistStore) ConfirmWaitlistEntry(ctx context.Context, email string) (*types.Waitlist, error) {
+	var individualInWaiting types.Waitlist
+	query := `UPDATE "waitlist" SET confirmed_at = COALESCE(confirmed_at, CURRENT_TIMESTAMP) WHERE email=$1 RETURNING name, email, number, referral_code, confirmed_at, created_at`
+
+	if err := s.db.QueryRowContext(ctx, query, email).Scan(
+		&individualInWaiting.Name,
+		&individualInWaiting.Email,
+		&individualInWaiting.Number,
+		&individualInWaiting.ReferralCode,
+		&individualInWaiting.ConfirmedAt,
+		&individualInWaiting.CreatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("This signup has expired, please join the waitlist again")
+		}
+
+		return nil, err
+	}
+
+	return &individualInWaiting, nil
+}
+
+func (s *WaitlistStore) PurgeUnconfirmedWaitlist(ctx context.Context, olderThan time.Duration) (int64, error) {
+	query := `DELETE FROM "waitlist" WHERE confirmed_at IS NULL AND created_at < $1`
+
+	result, err := s.db.ExecContext(ctx, query, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *WaitlistStore) GetWaitlistStanding(ctx context.Context, code string, boost int) (*types.WaitlistStanding, error) {
+	var standing types.WaitlistStanding
+	query := standingsQuery + ` SELECT name, referral_code, referrals, position, total FROM "standings" WHERE referral_code=$2`
+
+	if err := s.db.QueryRowContext(ctx, query, boost, strings.ToUpper(code)).Scan(
+		&standing.Name,
+		&standing.ReferralCode,
+		&standing.Referrals,
+		&standing.Position,
+		&standing.Total,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("No confirmed member with this code")
+		}
+
+		return nil, err
+	}
+
+	return &standing, nil
+}
+
+func (s *WaitlistStore) GetWaitlistLeaderboard(ctx context.Context, boost int, limit int) ([]types.WaitlistStanding, error) {
+	leaderboard := []types.WaitlistStanding{}
+	query := standingsQuery + ` SELECT name, email, referral_code, referrals, position, total FROM "standings" WHERE referrals > 0 ORDER BY referrals DESC, position LIMIT $2`
+
+	rows, err := s.db.QueryContext(ctx, query, boost, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var standing types.WaitlistStanding
+
+		if err := rows.Scan(
+			&standing.Name,
+			&standing.Email,
+			&standing.ReferralCode,
+			&standing.Referrals,
+			&standing.Position,
+			&standing.Total,
+		); err != nil {
+			return nil, err
+		}
+
+		leaderboard = append(leaderboard, standing)
+	}
+
+	return leaderboard, nil
+}
+
+// StreamWaitlist hands each confirmed participant who signed up in [from, to)
+// to fn without holding the whole list in memory. Nil bounds are open.
+func (s *WaitlistStore) StreamWaitlist(ctx context.Context, from *time.Time, to *time.Time, fn func(types.Waitlist) error) error {
+	query := `SELECT name, email, number, referral_code, confirmed_at, created_at FROM "waitlist" WHERE confirmed_at IS NOT NULL AND ($1::timestamp IS NULL OR created_at >= $1) AND ($2::timestamp IS NULL OR created_at < $2) ORDER BY created_at, id`
+
+	rows, err := s.db.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var individualInWaiting types.Waitlist
+
+		if err := rows.Scan(
+			&individualInWaiting.Name,
+			&individualInWaiting.Email,
+			&individualInWaiting.Number,
+			&individualInWaiting.ReferralCode,
+			&individualInWaiting.ConfirmedAt,
+			&individualInWaiting.CreatedAt,
+		); err != nil {
+			return err
+		}
+
+		if err := fn(individualInWaiting); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetExistingWaitlistEmails returns which of emails are already on the list.
+func (s *WaitlistStore) GetExistingWaitlistEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	existing := map[string]bool{}
+	query := `SELECT lower(email) FROM "waitlist" WHERE lower(email) = ANY($1)`
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(emails))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+
+		existing[email] = true
+	}
+
+	return existing, rows.Err()
+}
+
+// ImportWaitlist bulk loads contacts in one transaction. Imported contacts
+// have recorded consent from elsewhere, so they count as confirmed and are
+// never purged.
+func (s *WaitlistStore) ImportWaitlist(ctx context.Context, rows []types.WaitlistImportRow) (int64, error) {
+	var imported int64
+	query := `INSERT INTO "waitlist" (name, email, number, referral_code, confirmed_at) VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP) ON CONFLICT DO NOTHING`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, row := range rows {
+		code, err := utils.RandomCode(referralCodeLength)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		result, err := tx.ExecContext(ctx, query, row.Name, row.Email, row.Number, code)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		affected, _ := result.RowsAffected()
+		imported += affected
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return imported, nil
+}
+
+// IssueAccessCode returns the member's early access code, creating one the
+// first time. Only confirmed members get a code.
+func (s *WaitlistStore) IssueAccessCode(ctx context.Context, email string) (string, error) {
+	var code string
+	findQuery := `SELECT COALESCE(access_code, '') FROM "waitlist" WHERE email=$1 AND confirmed_at IS NOT NULL`
+	query := `UPDATE "waitlist" SET access_code=COALESCE(access_code, $2) WHERE email=$1 RETURNING access_code`
+
+	if err := s.db.QueryRowContext(ctx, findQuery, email).Scan(&code); err != nil {
+		return "", err
+	}
+
+	if code != "" {
+		return code, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		newCode, err := utils.RandomCode(accessCodeLength)
+		if err != nil {
+			return "", err
+		}
+
+		err = s.db.QueryRowContext(ctx, query, email, newCode).Scan(&code)
+		if err == nil {
+			return code, nil
+		}
+
+		// Retry the rare access code collision, anything else is fatal.
+		if pqErr, ok := err.(*pq.Error); !ok || pqErr.Constraint != "waitlist_access_code_key" || attempt == 2 {
+			return "", err
+		}
+	}
+}
+
+// GetEarlyAccessMember finds the confirmed member holding the access code,
+// or with the email from a magic link.
+func (s *WaitlistStore) GetEarlyAccessMember(ctx context.Context, code string, email string) (*types.Waitlist, error) {
+	var waitlist types.Waitlist
+	query := `SELECT name, email, number, sms_opt_in, referral_code, confirmed_at, created_at FROM "waitlist" WHERE confirmed_at IS NOT NULL AND (($1 <> '' AND access_code=upper($1)) OR ($2 <> '' AND email=$2)) LIMIT 1`
+
+	if err := s.db.QueryRowContext(ctx, query, code, email).Scan(
+		&waitlist.Name,
+		&waitlist.Email,
+		&waitlist.Number,
+		&waitlist.SmsOptIn,
+		&waitlist.ReferralCode,
+		&waitlist.ConfirmedAt,
+		&waitlist.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &waitlist, nil
+}
@@ -0,0 +1,124 @@
+package types
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BaseCurrency is what every price is stored in and every order settles in.
+const BaseCurrency = "NGN"
+
+// Currencies the storefront can show prices in, with how many digits their
+// minor unit has (kobo, cents and pence are all hundredths).
+var CurrencyExponents = map[string]int{
+	"NGN": 2,
+	"USD": 2,
+	"GBP": 2,
+}
+
+var currencySymbols = map[string]string{
+	"NGN": "₦",
+	"USD": "$",
+	"GBP": "£",
+}
+
+// Money is an amount in the minor unit of its currency, so 2500000 NGN is
+// ₦25,000.00. In the database amounts are plain integers in BaseCurrency.
+type Money struct {
+	Amount   int64  `json:"amount" validate:"min=0"`
+	Currency string `json:"currency" validate:"omitempty,iso4217"`
+}
+
+// NewMoney returns amount minor units of BaseCurrency.
+func NewMoney(amount int64) Money {
+	return Money{Amount: amount, Currency: BaseCurrency}
+}
+
+// Scan reads a stored amount, which is always in BaseCurrency.
+func (m *Money) Scan(src any) error {
+	switch value := src.(type) {
+	case nil:
+		*m = NewMoney(0)
+	case int64:
+		*m = NewMoney(value)
+	case []byte:
+		amount, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return err
+		}
+		*m = NewMoney(amount)
+	default:
+		return fmt.Errorf("Cannot read %T as money", src)
+	}
+
+	return nil
+}
+
+// Value stores the amount. Only BaseCurrency can be stored, a blank
+// currency is taken to be it.
+func (m Money) Value() (driver.Value, error) {
+	if !m.IsBaseCurrency() {
+		return nil, fmt.Errorf("Amounts are stored in %s, not %s", BaseCurrency, m.Currency)
+	}
+
+	return m.Amount, nil
+}
+
+// Convert turns a BaseCurrency amount into currency, where rate is how many
+// BaseCurrency units one unit of currency costs. It rounds to the nearest
+// minor unit.
+func (m Money) Convert(currency string, rate float64) Money {
+	if currency == m.Currency || rate <= 0 {
+		return m
+	}
+
+	shift := math.Pow10(CurrencyExponents[currency] - CurrencyExponents[m.Currency])
+	return Money{Amount: int64(math.Round(float64(m.Amount) * shift / rate)), Currency: currency}
+}
+
+// String formats the amount for people, like ₦25,000.00.
+func (m Money) String() string {
+	symbol, ok := currencySymbols[m.Currency]
+	if !ok {
+		symbol = m.Currency + " "
+	}
+
+	if m.Amount < 0 {
+		return "-" + symbol + Money{Amount: -m.Amount, Currency: m.Currency}.Decimal()
+	}
+
+	return symbol + m.Decimal()
+}
+
+// Decimal formats the amount without its currency, like 25,000.00, for
+// places that can't show the symbol.
+func (m Money) Decimal() string {
+	exponent := CurrencyExponents[m.Currency]
+	divisor := int64(math.Pow10(exponent))
+
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	whole := strconv.FormatInt(amount/divisor, 10)
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+
+	if exponent == 0 {
+		return sign + whole
+	}
+
+	return fmt.Sprintf("%s%s.%0*d", sign, whole, exponent, amount%divisor)
+}
+
+// IsBaseCurrency reports whether the amount is in BaseCurrency, a blank
+// currency counting as it.
+func (m Money) IsBaseCurrency() bool {
+	return m.Currency == "" || strings.EqualFold(m.Currency, BaseCurrency)
+}
@@ -0,0 +1,70 @@
+package types
+
+import "testing"
+
+func TestMoneyConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		money    Money
+		currency string
+		rate     float64
+		want     Money
+	}{
+		{"whole dollars", NewMoney(150000), "USD", 1500, Money{Amount: 100, Currency: "USD"}},
+		{"rounds up to a cent", NewMoney(1000), "USD", 1500, Money{Amount: 1, Currency: "USD"}},
+		{"rounds down to nothing", NewMoney(100), "USD", 1500, Money{Amount: 0, Currency: "USD"}},
+		{"zero amount", NewMoney(0), "GBP", 2000, Money{Amount: 0, Currency: "GBP"}},
+		{"negative amount", NewMoney(-150000), "USD", 1500, Money{Amount: -100, Currency: "USD"}},
+		{"same currency", NewMoney(150000), BaseCurrency, 1500, NewMoney(150000)},
+		{"zero rate", NewMoney(150000), "USD", 0, NewMoney(150000)},
+		{"negative rate", NewMoney(150000), "USD", -1500, NewMoney(150000)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.money.Convert(test.currency, test.rate); got != test.want {
+				t.Errorf("%v.Convert(%q, %v) = %+v, want %+v", test.money.Amount, test.currency, test.rate, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMoneyDecimal(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{NewMoney(2500000), "25,000.00"},
+		{NewMoney(123456789), "1,234,567.89"},
+		{NewMoney(99), "0.99"},
+		{NewMoney(0), "0.00"},
+		{NewMoney(-5), "-0.05"},
+		{NewMoney(-2500000), "-25,000.00"},
+		{Money{Amount: 1000, Currency: "XYZ"}, "1,000"},
+	}
+
+	for _, test := range tests {
+		if got := test.money.Decimal(); got != test.want {
+			t.Errorf("%+v.Decimal() = %q, want %q", test.money, got, test.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{NewMoney(2500000), "₦25,000.00"},
+		{NewMoney(-2500000), "-₦25,000.00"},
+		{NewMoney(0), "₦0.00"},
+		{Money{Amount: 1999, Currency: "USD"}, "$19.99"},
+		{Money{Amount: 1000, Currency: "XYZ"}, "XYZ 1,000"},
+	}
+
+	for _, test := range tests {
+		if got := test.money.String(); got != test.want {
+			t.Errorf("%+v.String() = %q, want %q", test.money, got, test.want)
+		}
+	}
+}
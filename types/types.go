@@ -1,45 +1,349 @@
 package types
 
+import "time"
+
 type SubscribePayload struct {
-	Name   string `json:"name" validate:"required"`
-	Email  string `json:"email" validate:"required,email"`
-	Number string `json:"number" validate:"required"`
+	Name     string `json:"name" validate:"required"`
+	Email    string `json:"email" validate:"required,email"`
+	Number   string `json:"number" validate:"required,phone"`
+	SmsOptIn bool   `json:"sms_opt_in"`
+	Ref      string `json:"ref"`
+	// Website is a honeypot hidden from people, only bots fill it in.
+	Website   string `json:"website"`
+	FormToken string `json:"form_token"`
 }
 
 type Category struct {
 	Id          int      `json:"id"`
 	Name        string   `json:"name"`
+	Slug        string   `json:"slug"`
+	ParentId    *int     `json:"parent_id"`
 	Description string   `json:"description"`
 	IsFeatured  bool     `json:"is_featured"`
 	Pictures    []string `json:"pictures"`
+	Images      []Image  `json:"images"`
+	// DeletedAt is only set on categories in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type CategoryDTO struct {
 	Name        string   `json:"name" validate:"required,min=3"`
+	ParentId    *int     `json:"parent_id" validate:"omitempty,min=1"`
 	Description string   `json:"description" validate:"required"`
 	IsFeatured  bool     `json:"is_featured"`
-	Pictures    []string `json:"pictures" validate:"required"`
+	Pictures    []string `json:"pictures" validate:"required_without=MediaIds"`
+	// MediaIds are uploads from POST /media, added after Pictures.
+	MediaIds []string `json:"media_ids"`
+}
+
+// CategoryNode is a category in the tree along with its subcategories.
+type CategoryNode struct {
+	Id       int            `json:"id"`
+	Name     string         `json:"name"`
+	Slug     string         `json:"slug"`
+	ParentId *int           `json:"parent_id"`
+	Children []CategoryNode `json:"children"`
+}
+
+// Breadcrumb is one step on the path from a top level category down.
+type Breadcrumb struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
 }
 
 type Clothes struct {
-	Id          int      `json:"id" `
-	Name        string   `json:"name"`
-	Price       int      `json:"price"`
-	Description string   `json:"description"`
-	Quantity    int      `json:"quantity"`
-	CategoryId  int      `json:"category_id"`
-	Pictures    []string `json:"pictures"`
-	Sizes       []string `json:"sizes"`
+	Id    int    `json:"id" `
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Sku   string `json:"sku"`
+	Price Money  `json:"price"`
+	// CompareAtPrice is the "was" price shown struck through. SalePrice takes
+	// over from Price between SaleStartsAt and SaleEndsAt, either of which
+	// may be left open. EffectivePrice is what the clothing costs right now.
+	CompareAtPrice *Money     `json:"compare_at_price"`
+	SalePrice      *Money     `json:"sale_price"`
+	SaleStartsAt   *time.Time `json:"sale_starts_at"`
+	SaleEndsAt     *time.Time `json:"sale_ends_at"`
+	EffectivePrice Money      `json:"effective_price"`
+	// DisplayPrice is EffectivePrice in the currency asked for with ?currency=.
+	DisplayPrice   *Money     `json:"display_price,omitempty"`
+	Sale           *SaleBadge `json:"sale,omitempty"`
+	Description    string     `json:"description"`
+	Quantity       int        `json:"quantity"`
+	CategoryId     int        `json:"category_id"`
+	IsFeatured     bool       `json:"is_featured"`
+	Colour         string     `json:"colour"`
+	Pictures       []string   `json:"pictures"`
+	Images         []Image    `json:"images"`
+	Sizes          []string   `json:"sizes"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Status         string     `json:"status"`
+	PublishAt      *time.Time `json:"publish_at"`
+	EarlyAccessAt  *time.Time `json:"early_access_at"`
+	LaunchCampaign bool       `json:"launch_campaign"`
+	// DeletedAt is only set on clothes in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// SaleBadge is set on clothes selling below their was price, from a running
+// sale or a compare-at price. EndsAt is when a running sale finishes.
+type SaleBadge struct {
+	WasPrice Money `json:"was_price"`
+	// DisplayWasPrice is WasPrice in the currency asked for with ?currency=.
+	DisplayWasPrice *Money     `json:"display_was_price,omitempty"`
+	PercentOff      int        `json:"percent_off"`
+	EndsAt          *time.Time `json:"ends_at,omitempty"`
+}
+
+// ExchangeRate is how many BaseCurrency units one unit of Currency costs,
+// e.g. 1550 for USD. It is only used to show prices, orders always settle
+// in BaseCurrency.
+type ExchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      float64   `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ExchangeRates struct {
+	Base  string         `json:"base"`
+	Rates []ExchangeRate `json:"rates"`
+}
+
+type ExchangeRateDTO struct {
+	Rate float64 `json:"rate" validate:"required,gt=0"`
+}
+
+// PriceChange is one entry in a clothing's price history.
+type PriceChange struct {
+	Price          Money      `json:"price"`
+	CompareAtPrice *Money     `json:"compare_at_price"`
+	SalePrice      *Money     `json:"sale_price"`
+	SaleStartsAt   *time.Time `json:"sale_starts_at"`
+	SaleEndsAt     *time.Time `json:"sale_ends_at"`
+	ChangedAt      time.Time  `json:"changed_at"`
+}
+
+// Clothes statuses. Only published clothes, and scheduled ones whose time
+// has come, are shown in the shop.
+const (
+	ClothesStatusDraft     = "draft"
+	ClothesStatusScheduled = "scheduled"
+	ClothesStatusPublished = "published"
+	ClothesStatusArchived  = "archived"
+)
+
+// Sort orders for clothes listings.
+const (
+	ClothesSortNewest    = "newest"
+	ClothesSortPriceAsc  = "price_asc"
+	ClothesSortPriceDesc = "price_desc"
+	ClothesSortName      = "name"
+)
+
+type ClothesFilter struct {
+	CategoryId int
+	MinPrice   *int64
+	MaxPrice   *int64
+	Size       string
+	Colour     string
+	Featured   *bool
+	// Search is what the shopper typed, every word has to match.
+	Search string
+	Sort   string
+	Cursor string
+	Limit  int
+	// Facets asks for facet counts alongside the page.
+	Facets bool
+	// Status limits admin listings to one status. Without IncludeUnpublished
+	// only published clothes are listed whatever Status says.
+	Status             string
+	IncludeUnpublished bool
+}
+
+type ClothesSearchResult struct {
+	Clothes
+	Rank          float64 `json:"rank"`
+	NameHighlight string  `json:"name_highlight"`
+	Snippet       string  `json:"snippet"`
+}
+
+type ClothesSearchPage struct {
+	Data       []ClothesSearchResult `json:"data"`
+	DidYouMean string                `json:"did_you_mean,omitempty"`
+	Facets     *ClothesFacets        `json:"facets,omitempty"`
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+type PriceBucketCount struct {
+	Min   Money  `json:"min"`
+	Max   *Money `json:"max"`
+	Count int    `json:"count"`
+}
+
+// ClothesFacets counts what each filter option would match given the other
+// filters currently applied.
+type ClothesFacets struct {
+	Categories   []FacetCount       `json:"categories"`
+	Sizes        []FacetCount       `json:"sizes"`
+	PriceBuckets []PriceBucketCount `json:"price_buckets"`
+	Colours      []FacetCount       `json:"colours"`
+}
+
+type Suggestion struct {
+	Type  string  `json:"type"`
+	Id    int     `json:"id"`
+	Name  string  `json:"name"`
+	Slug  string  `json:"slug"`
+	Score float64 `json:"score"`
+}
+
+type ClothesPage struct {
+	Data       []Clothes      `json:"data"`
+	NextCursor string         `json:"next_cursor"`
+	Facets     *ClothesFacets `json:"facets,omitempty"`
 }
 
 type ClothesDTO struct {
-	CategoryId  int      `json:"category_id" validate:"required"`
-	Name        string   `json:"name" validate:"required,min=3"`
-	Price       int      `json:"price" validate:"required"`
-	Description string   `json:"description" validate:"required"`
-	Quantity    int      `json:"quantity" validate:"required"`
-	Pictures    []string `json:"pictures" validate:"required"`
-	Sizes       []string `json:"sizes" validate:"required"`
+	CategoryId int    `json:"category_id" validate:"required"`
+	Name       string `json:"name" validate:"required,min=3"`
+	// Sku defaults to the upper cased slug when left out.
+	Sku   string `json:"sku" validate:"max=64"`
+	Price Money  `json:"price"`
+	// CompareAtPrice has to be above Price and SalePrice below it. Leaving
+	// SaleStartsAt or SaleEndsAt out starts the sale now or runs it until
+	// it's taken off.
+	CompareAtPrice *Money     `json:"compare_at_price"`
+	SalePrice      *Money     `json:"sale_price"`
+	SaleStartsAt   *time.Time `json:"sale_starts_at"`
+	SaleEndsAt     *time.Time `json:"sale_ends_at"`
+	Description    string     `json:"description" validate:"required"`
+	Quantity       int        `json:"quantity" validate:"required"`
+	IsFeatured     bool       `json:"is_featured"`
+	Colour         string     `json:"colour" validate:"max=50"`
+	Pictures       []string   `json:"pictures" validate:"required_without=MediaIds"`
+	// MediaIds are uploads from POST /media, added after Pictures.
+	MediaIds []string `json:"media_ids"`
+	Sizes    []string `json:"sizes" validate:"required"`
+	// Status defaults to draft. Scheduled clothes go live at PublishAt, and
+	// LaunchCampaign mails the waitlist when they do. From EarlyAccessAt until
+	// then only waitlist members with an access code can order them.
+	Status         string     `json:"status" validate:"omitempty,oneof=draft scheduled published archived"`
+	PublishAt      *time.Time `json:"publish_at" validate:"required_if=Status scheduled"`
+	EarlyAccessAt  *time.Time `json:"early_access_at" validate:"excluded_unless=Status scheduled"`
+	LaunchCampaign bool       `json:"launch_campaign"`
+}
+
+// Countdown is the answer to ordering a drop that isn't out yet.
+type Countdown struct {
+	Error            string     `json:"error"`
+	ClothesId        int        `json:"clothes_id"`
+	Name             string     `json:"name"`
+	ReleaseAt        time.Time  `json:"release_at"`
+	SecondsRemaining int64      `json:"seconds_remaining"`
+	EarlyAccessAt    *time.Time `json:"early_access_at,omitempty"`
+}
+
+// EarlyAccessDTO carries either the access code from the early access email
+// or the token from its magic link.
+type EarlyAccessDTO struct {
+	Code  string `json:"code" validate:"required_without=Token"`
+	Token string `json:"token" validate:"required_without=Code"`
+}
+
+// Media is an uploaded file, referenced from clothes and categories by Id.
+// Width and Height are 0 when the image couldn't be decoded.
+type Media struct {
+	Id           string         `json:"id"`
+	Storage      string         `json:"storage"`
+	StorageKey   string         `json:"-"`
+	Url          string         `json:"url"`
+	ContentType  string         `json:"content_type"`
+	Size         int64          `json:"size"`
+	OriginalName string         `json:"original_name"`
+	Width        int            `json:"width"`
+	Height       int            `json:"height"`
+	Variants     *ImageVariants `json:"variants,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+// Image is one picture of a clothing or category. Images come in Position
+// order and exactly one of them is primary. The focal point is where crops
+// centre on, as fractions of the width and height.
+type Image struct {
+	Id        int           `json:"id"`
+	MediaId   *string       `json:"media_id"`
+	Url       string        `json:"url"`
+	Position  int           `json:"position"`
+	AltText   string        `json:"alt_text"`
+	IsPrimary bool          `json:"is_primary"`
+	Width     int           `json:"width"`
+	Height    int           `json:"height"`
+	FocalX    float64       `json:"focal_x"`
+	FocalY    float64       `json:"focal_y"`
+	Variants  ImageVariants `json:"variants"`
+}
+
+// ImageVariants are the sizes an image is served at. WebP is left out when
+// the image is stored somewhere that can't produce it.
+type ImageVariants struct {
+	Thumb string `json:"thumb"`
+	Card  string `json:"card"`
+	Zoom  string `json:"zoom"`
+	WebP  string `json:"webp,omitempty"`
+}
+
+// ImageDTO changes an image's details, fields left out stay as they are.
+// Setting IsPrimary to true moves the primary flag over from the other
+// images, Position moves the image and shifts the rest along.
+type ImageDTO struct {
+	AltText   *string  `json:"alt_text" validate:"omitempty,max=255"`
+	Position  *int     `json:"position" validate:"omitempty,min=0"`
+	IsPrimary bool     `json:"is_primary"`
+	FocalX    *float64 `json:"focal_x" validate:"omitempty,min=0,max=1"`
+	FocalY    *float64 `json:"focal_y" validate:"omitempty,min=0,max=1"`
+}
+
+// CatalogRow is one line of the catalog CSV, identified by Sku. In the file
+// Sizes and Pictures are separated by "|" and the category goes by name.
+type CatalogRow struct {
+	Line        int    `json:"-"`
+	Sku         string `json:"sku" validate:"required,max=64"`
+	Name        string `json:"name" validate:"required,min=3"`
+	Category    string `json:"category" validate:"required"`
+	Description string `json:"description" validate:"required"`
+	Price       Money  `json:"price"`
+	// The sale columns are optional, blank cells take a sale off.
+	CompareAtPrice *Money     `json:"compare_at_price"`
+	SalePrice      *Money     `json:"sale_price"`
+	SaleStartsAt   *time.Time `json:"sale_starts_at"`
+	SaleEndsAt     *time.Time `json:"sale_ends_at"`
+	Quantity       int        `json:"quantity" validate:"min=0"`
+	Colour         string     `json:"colour" validate:"max=50"`
+	Sizes          []string   `json:"sizes" validate:"required,min=1,dive,required,max=20"`
+	Pictures       []string   `json:"pictures" validate:"dive,url,max=500"`
+	IsFeatured     bool       `json:"is_featured"`
+	Status         string     `json:"status" validate:"omitempty,oneof=draft scheduled published archived"`
+	PublishAt      *time.Time `json:"publish_at" validate:"required_if=Status scheduled"`
+}
+
+type CatalogImportError struct {
+	Row   int    `json:"row"`
+	Sku   string `json:"sku"`
+	Error string `json:"error"`
+}
+
+type CatalogImportResult struct {
+	DryRun  bool                 `json:"dry_run"`
+	Total   int                  `json:"total"`
+	Created int                  `json:"created"`
+	Updated int                  `json:"updated"`
+	Errors  []CatalogImportError `json:"errors"`
 }
 
 type Sizes struct {
@@ -58,31 +362,356 @@ type LoginDto struct {
 }
 
 type Waitlist struct {
-	Name   string `json:"name"`
-	Email  string `json:"email"`
-	Number string `json:"number"`
+	Name         string     `json:"name"`
+	Email        string     `json:"email"`
+	Number       string     `json:"number"`
+	SmsOptIn     bool       `json:"sms_opt_in"`
+	ReferralCode string     `json:"referral_code"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
 }
 
+type WaitlistImportRow struct {
+	Name   string `json:"name" validate:"required"`
+	Email  string `json:"email" validate:"required,email"`
+	Number string `json:"number" validate:"omitempty,phone"`
+}
+
+type WaitlistImportError struct {
+	Row   int    `json:"row"`
+	Email string `json:"email"`
+	Error string `json:"error"`
+}
+
+type WaitlistImportResult struct {
+	DryRun     bool                  `json:"dry_run"`
+	Total      int                   `json:"total"`
+	Imported   int                   `json:"imported"`
+	Duplicates int                   `json:"duplicates"`
+	Errors     []WaitlistImportError `json:"errors"`
+}
+
+type WaitlistStanding struct {
+	Name         string `json:"name"`
+	Email        string `json:"email,omitempty"`
+	ReferralCode string `json:"referral_code"`
+	Referrals    int    `json:"referrals"`
+	Position     int    `json:"position"`
+	Total        int    `json:"total"`
+}
+
+// Order.Price is what the buyer pays, tax included. Subtotal is the same
+// before tax. Reference is what buyers are given to quote and track the order
+// with, the id stays internal.
 type Order struct {
-	Id            int      `json:"id"`
-	Name          string   `json:"name"`
-	Address       string   `json:"address"`
-	IsDelivered   bool     `json:"is_delivered"`
-	Quantity      int      `json:"quantity"`
-	Price         int      `json:"price"`
-	ClothesBought []string `json:"clothes_bought"`
+	Id            int         `json:"id"`
+	Reference     string      `json:"reference"`
+	Name          string      `json:"name"`
+	Email         string      `json:"email"`
+	Address       string      `json:"address"`
+	Country       string      `json:"country"`
+	Phone         string      `json:"phone"`
+	SmsOptIn      bool        `json:"sms_opt_in"`
+	IsDelivered   bool        `json:"is_delivered"`
+	Quantity      int         `json:"quantity"`
+	Subtotal      Money       `json:"subtotal"`
+	Tax           Money       `json:"tax"`
+	TaxRate       float64     `json:"tax_rate"`
+	TaxInclusive  bool        `json:"tax_inclusive"`
+	Price         Money       `json:"price"`
+	ClothesBought []string    `json:"clothes_bought"`
+	Lines         []OrderLine `json:"lines"`
+	CreatedAt     time.Time   `json:"created_at"`
+	// InvoiceNumber is handed out in order when the payment comes in.
+	InvoiceNumber    *int       `json:"invoice_number"`
+	PaymentReference string     `json:"payment_reference"`
+	PaidAt           *time.Time `json:"paid_at"`
+	TrackingNumber   string     `json:"tracking_number"`
+	// History is only loaded for a single order.
+	History []OrderStatus `json:"history,omitempty"`
+}
+
+// Statuses in an order's history.
+const (
+	OrderStatusPlaced          = "placed"
+	OrderStatusPaid            = "paid"
+	OrderStatusShipped         = "shipped"
+	OrderStatusDelivered       = "delivered"
+	OrderStatusReturnRequested = "return_requested"
+	OrderStatusReturnApproved  = "return_approved"
+	OrderStatusReturnRejected  = "return_rejected"
+	OrderStatusReturnReceived  = "return_received"
+	OrderStatusRefunded        = "refunded"
+)
+
+type OrderStatus struct {
+	Status    string    `json:"status"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrderLine is one clothing on an order, priced when the order was placed.
+// Total includes Tax.
+type OrderLine struct {
+	ClothesId int    `json:"clothes_id"`
+	Sku       string `json:"sku"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice Money  `json:"unit_price"`
+	Tax       Money  `json:"tax"`
+	Total     Money  `json:"total"`
 }
 
 type OrderDTO struct {
-	Name          string          `json:"name" validate:"required,min=3"`
-	Address       string          `json:"address" validate:"required,min=3"`
-	IsDelivered   bool            `json:"is_delivered"`
-	Quantity      int             `json:"quantity" validate:"required"`
-	Price         int             `json:"price" validate:"required"`
-	ClothesBought []ClothesBought `json:"clothes_bought" validate:"required"`
+	Name    string `json:"name" validate:"required,min=3"`
+	Email   string `json:"email" validate:"omitempty,email"`
+	Address string `json:"address" validate:"required,min=3"`
+	// Country is where the order ships, HomeCountry when left out.
+	Country     string `json:"country" validate:"omitempty,iso3166_1_alpha2"`
+	Phone       string `json:"phone" validate:"required_if=SmsOptIn true,omitempty,phone"`
+	SmsOptIn    bool   `json:"sms_opt_in"`
+	IsDelivered bool   `json:"is_delivered"`
+	Quantity    int    `json:"quantity" validate:"required"`
+	// Price is the total the buyer was shown. It's optional, but when sent
+	// the order is refused if the total has changed since.
+	Price         Money           `json:"price"`
+	ClothesBought []ClothesBought `json:"clothes_bought" validate:"required,min=1,dive"`
+	// AccessCode or AccessToken lets waitlist members order early access drops.
+	AccessCode  string `json:"access_code"`
+	AccessToken string `json:"access_token"`
 }
 
 type ClothesBought struct {
-	Id       int `json:"id"`
-	Quantity int `json:"quantity"`
+	Id       int `json:"id" validate:"required"`
+	Quantity int `json:"quantity" validate:"min=1"`
+}
+
+type OrderShipmentDTO struct {
+	TrackingNumber string `json:"tracking_number" validate:"required,max=100"`
+}
+
+// OrderTracking is what anyone with an order's reference and its buyer's
+// email or phone gets to see, so it leaves out the buyer's details.
+type OrderTracking struct {
+	Reference      string        `json:"reference"`
+	Status         string        `json:"status"`
+	TrackingNumber string        `json:"tracking_number"`
+	Lines          []OrderLine   `json:"lines"`
+	Total          Money         `json:"total"`
+	PlacedAt       time.Time     `json:"placed_at"`
+	Timeline       []OrderStatus `json:"timeline"`
+}
+
+type OrderPaymentDTO struct {
+	Reference string `json:"reference" validate:"required,max=100"`
+}
+
+// Return stages. A return is requested, then approved or rejected, received
+// back into stock, and finally refunded. Refunding is the short stretch while
+// the payment provider is being asked for the money back.
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusReceived  = "received"
+	ReturnStatusRefunding = "refunding"
+	ReturnStatusRefunded  = "refunded"
+)
+
+// Why a line is being returned.
+const (
+	ReturnReasonWrongSize      = "wrong_size"
+	ReturnReasonDamaged        = "damaged"
+	ReturnReasonNotAsDescribed = "not_as_described"
+	ReturnReasonChangedMind    = "changed_mind"
+	ReturnReasonOther          = "other"
+)
+
+// Who opened a return.
+const (
+	ReturnOpenedByCustomer = "customer"
+	ReturnOpenedByAdmin    = "admin"
+)
+
+type Return struct {
+	Id              int          `json:"id"`
+	OrderId         int          `json:"order_id"`
+	Status          string       `json:"status"`
+	OpenedBy        string       `json:"opened_by"`
+	Note            string       `json:"note"`
+	Lines           []ReturnLine `json:"lines"`
+	Amount          Money        `json:"amount"`
+	RefundAmount    *Money       `json:"refund_amount"`
+	RefundReference string       `json:"refund_reference"`
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+}
+
+// ReturnLine is part of an order line coming back. Amount is what was paid
+// for that many, tax included, and the most its share of a refund can be.
+type ReturnLine struct {
+	ClothesId int    `json:"clothes_id"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+	Reason    string `json:"reason"`
+	Amount    Money  `json:"amount"`
+}
+
+// ReturnDTO opens a return. Customers prove the order is theirs with the
+// email or phone it was placed with, admins don't need to.
+type ReturnDTO struct {
+	Email string          `json:"email" validate:"omitempty,email"`
+	Phone string          `json:"phone" validate:"omitempty,phone"`
+	Note  string          `json:"note" validate:"max=500"`
+	Lines []ReturnLineDTO `json:"lines" validate:"required,min=1,dive"`
+}
+
+type ReturnLineDTO struct {
+	ClothesId int    `json:"clothes_id" validate:"required"`
+	Quantity  int    `json:"quantity" validate:"min=1"`
+	Reason    string `json:"reason" validate:"required,oneof=wrong_size damaged not_as_described changed_mind other"`
+}
+
+type ReturnDecisionDTO struct {
+	Note string `json:"note" validate:"max=500"`
+}
+
+// RefundDTO refunds a received return. Leaving Amount out refunds the whole
+// of it.
+type RefundDTO struct {
+	Amount *Money `json:"amount"`
+	Note   string `json:"note" validate:"max=500"`
+}
+
+// OrderQuote is what an order comes to before it's placed.
+type OrderQuote struct {
+	Country      string      `json:"country"`
+	TaxRate      float64     `json:"tax_rate"`
+	TaxInclusive bool        `json:"tax_inclusive"`
+	Lines        []OrderLine `json:"lines"`
+	Subtotal     Money       `json:"subtotal"`
+	Tax          Money       `json:"tax"`
+	Total        Money       `json:"total"`
+}
+
+// HomeCountry is where the shop is, and where orders ship unless told
+// otherwise.
+const HomeCountry = "NG"
+
+// TaxRate is the tax charged on orders shipping to Country, as a percentage.
+// When Inclusive the clothes' prices already contain it, otherwise it's added
+// on top.
+type TaxRate struct {
+	Country   string    `json:"country"`
+	Name      string    `json:"name"`
+	Rate      float64   `json:"rate"`
+	Inclusive bool      `json:"inclusive"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type TaxRateDTO struct {
+	Name      string   `json:"name" validate:"required,max=50"`
+	Rate      *float64 `json:"rate" validate:"required,min=0,lt=100"`
+	Inclusive bool     `json:"inclusive"`
+}
+
+// Mail categories a recipient can opt out of from the preference centre.
+const (
+	MailCategoryLaunchNews = "launch_news"
+	MailCategoryPromotions = "promotions"
+)
+
+type EmailPreferences struct {
+	Email      string `json:"email"`
+	LaunchNews bool   `json:"launch_news"`
+	Promotions bool   `json:"promotions"`
+}
+
+// EmailPreferencesDTO only changes the categories that are sent.
+type EmailPreferencesDTO struct {
+	LaunchNews *bool `json:"launch_news"`
+	Promotions *bool `json:"promotions"`
+}
+
+// Campaign templates, segments and statuses.
+const (
+	CampaignTemplateLaunch       = "launch"
+	CampaignTemplateAnnouncement = "announcement"
+	CampaignTemplateEarlyAccess  = "early_access"
+
+	CampaignSegmentAll           = "all"
+	CampaignSegmentConfirmed     = "confirmed"
+	CampaignSegmentTopReferrers  = "top_referrers"
+	CampaignSegmentSignedUpAfter = "signed_up_after"
+
+	CampaignStatusScheduled = "scheduled"
+	CampaignStatusSending   = "sending"
+	CampaignStatusSent      = "sent"
+	CampaignStatusStopped   = "stopped"
+
+	RecipientStatusPending = "pending"
+	RecipientStatusSent    = "sent"
+	RecipientStatusSkipped = "skipped"
+	RecipientStatusFailed  = "failed"
+)
+
+type Campaign struct {
+	Id            int            `json:"id"`
+	Name          string         `json:"name"`
+	Template      string         `json:"template"`
+	Subject       string         `json:"subject"`
+	Headline      string         `json:"headline"`
+	Message       string         `json:"message"`
+	Segment       string         `json:"segment"`
+	SignedUpAfter *time.Time     `json:"signed_up_after"`
+	TopReferrers  int            `json:"top_referrers"`
+	Status        string         `json:"status"`
+	ScheduledAt   time.Time      `json:"scheduled_at"`
+	StartedAt     *time.Time     `json:"started_at"`
+	FinishedAt    *time.Time     `json:"finished_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Recipients    map[string]int `json:"recipients,omitempty"`
+}
+
+type CampaignDTO struct {
+	Name          string     `json:"name" validate:"required,min=3"`
+	Template      string     `json:"template" validate:"required,oneof=launch announcement early_access"`
+	Subject       string     `json:"subject" validate:"required"`
+	Headline      string     `json:"headline" validate:"required_if=Template announcement"`
+	Message       string     `json:"message" validate:"required_if=Template announcement"`
+	Segment       string     `json:"segment" validate:"required,oneof=all confirmed top_referrers signed_up_after"`
+	SignedUpAfter *time.Time `json:"signed_up_after" validate:"required_if=Segment signed_up_after"`
+	TopReferrers  int        `json:"top_referrers" validate:"required_if=Segment top_referrers,gte=0"`
+	ScheduledAt   *time.Time `json:"scheduled_at"`
+}
+
+type CampaignRecipient struct {
+	Id       int        `json:"id"`
+	Name     string     `json:"name"`
+	Email    string     `json:"email"`
+	Number   string     `json:"number"`
+	Status   string     `json:"status"`
+	Error    string     `json:"error"`
+	Attempts int        `json:"attempts"`
+	SentAt   *time.Time `json:"sent_at"`
+}
+
+type BlockedDomain struct {
+	Domain    string    `json:"domain"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BlockedDomainDTO struct {
+	Domain string `json:"domain" validate:"required,fqdn"`
+	Reason string `json:"reason"`
+}
+
+type SubscribeRejection struct {
+	Id        int       `json:"id"`
+	Reason    string    `json:"reason"`
+	Ip        string    `json:"ip"`
+	Email     string    `json:"email"`
+	Domain    string    `json:"domain"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+)
+
+// A4 page size in points, PDF's unit of 1/72 inch.
+const (
+	PDFPageWidth  = 595.28
+	PDFPageHeight = 841.89
+)
+
+// PDF builds a simple document of text, lines, filled boxes and images using
+// the built-in Helvetica fonts, so no font files need embedding. Coordinates
+// are in points from the top left of the page, unlike PDF itself which
+// starts at the bottom left.
+type PDF struct {
+	pages  []*bytes.Buffer
+	images []pdfImage
+}
+
+type pdfImage struct {
+	width, height int
+	rgb, alpha    []byte
+}
+
+// Helvetica and Helvetica-Bold advance widths for ASCII 32 to 126, in
+// thousandths of the font size, from the standard 14 font metrics.
+var pdfFontWidths = [2][95]int{
+	{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	},
+	{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	},
+}
+
+// pdfWinAnsi covers the characters outside Latin-1 that WinAnsiEncoding has.
+var pdfWinAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+func NewPDF() *PDF {
+	return &PDF{}
+}
+
+// AddPage starts a new page, everything drawn after goes on it.
+func (p *PDF) AddPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+}
+
+func (p *PDF) page() *bytes.Buffer {
+	if len(p.pages) == 0 {
+		p.AddPage()
+	}
+
+	return p.pages[len(p.pages)-1]
+}
+
+// Text draws text with its baseline at y. Characters the built-in fonts
+// don't have come out as question marks.
+func (p *PDF) Text(x float64, y float64, size float64, bold bool, colour color.Color, text string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+
+	fmt.Fprintf(p.page(), "BT %s rg /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", pdfColour(colour), font, size, x, PDFPageHeight-y, pdfString(text))
+}
+
+// TextRight draws text so that it ends at x.
+func (p *PDF) TextRight(x float64, y float64, size float64, bold bool, colour color.Color, text string) {
+	p.Text(x-TextWidth(text, size, bold), y, size, bold, colour, text)
+}
+
+// TextWidth measures text set in Helvetica at size.
+func TextWidth(text string, size float64, bold bool) float64 {
+	font := 0
+	if bold {
+		font = 1
+	}
+
+	width := 0
+	for _, char := range text {
+		if char >= 32 && char <= 126 {
+			width += pdfFontWidths[font][char-32]
+		} else {
+			width += 556
+		}
+	}
+
+	return float64(width) * size / 1000
+}
+
+// Line draws a straight line of the given thickness.
+func (p *PDF) Line(x1 float64, y1 float64, x2 float64, y2 float64, thickness float64, colour color.Color) {
+	fmt.Fprintf(p.page(), "%s RG %.2f w %.2f %.2f m %.2f %.2f l S\n", pdfColour(colour), thickness, x1, PDFPageHeight-y1, x2, PDFPageHeight-y2)
+}
+
+// Rect fills a box whose top left corner is at x, y.
+func (p *PDF) Rect(x float64, y float64, width float64, height float64, colour color.Color) {
+	fmt.Fprintf(p.page(), "%s rg %.2f %.2f %.2f %.2f re f\n", pdfColour(colour), x, PDFPageHeight-y-height, width, height)
+}
+
+// Image draws img scaled to width by height with its top left corner at x, y.
+// Transparent pixels stay transparent.
+func (p *PDF) Image(img image.Image, x float64, y float64, width float64, height float64) {
+	bounds := img.Bounds()
+	embedded := pdfImage{width: bounds.Dx(), height: bounds.Dy()}
+
+	opaque := true
+	for py := bounds.Min.Y; py < bounds.Max.Y; py++ {
+		for px := bounds.Min.X; px < bounds.Max.X; px++ {
+			pixel := color.NRGBAModel.Convert(img.At(px, py)).(color.NRGBA)
+			embedded.rgb = append(embedded.rgb, pixel.R, pixel.G, pixel.B)
+			embedded.alpha = append(embedded.alpha, pixel.A)
+			opaque = opaque && pixel.A == 0xff
+		}
+	}
+
+	if opaque {
+		embedded.alpha = nil
+	}
+
+	p.images = append(p.images, embedded)
+	fmt.Fprintf(p.page(), "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", width, height, x, PDFPageHeight-y-height, len(p.images))
+}
+
+// WriteTo writes out the finished document.
+func (p *PDF) WriteTo(w io.Writer) (int64, error) {
+	if len(p.pages) == 0 {
+		p.AddPage()
+	}
+
+	var out bytes.Buffer
+	offsets := []int{}
+	object := func(body string, stream []byte) int {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\n", len(offsets), body)
+		if stream != nil {
+			out.WriteString("stream\n")
+			out.Write(stream)
+			out.WriteString("\nendstream\n")
+		}
+		out.WriteString("endobj\n")
+		return len(offsets)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects are numbered in the order they're written: the catalog, the
+	// page tree, both fonts, the images with their masks, then each page and
+	// its content stream.
+	firstPage := 5
+	for _, img := range p.images {
+		firstPage++
+		if img.alpha != nil {
+			firstPage++
+		}
+	}
+
+	kids := []string{}
+	for i := range p.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+i*2))
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>", nil)
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)), nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>", nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>", nil)
+
+	xobjects := []string{}
+	for i, img := range p.images {
+		mask := ""
+		if img.alpha != nil {
+			alpha, err := pdfDeflate(img.alpha)
+			if err != nil {
+				return 0, err
+			}
+			id := object(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>", img.width, img.height, len(alpha)), alpha)
+			mask = fmt.Sprintf(" /SMask %d 0 R", id)
+		}
+
+		rgb, err := pdfDeflate(img.rgb)
+		if err != nil {
+			return 0, err
+		}
+		id := object(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode%s /Length %d >>", img.width, img.height, mask, len(rgb)), rgb)
+		xobjects = append(xobjects, fmt.Sprintf("/Im%d %d 0 R", i+1, id))
+	}
+
+	resources := fmt.Sprintf("<< /Font << /F1 3 0 R /F2 4 0 R >> /XObject << %s >> >>", strings.Join(xobjects, " "))
+	for _, page := range p.pages {
+		content, err := pdfDeflate(page.Bytes())
+		if err != nil {
+			return 0, err
+		}
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources %s /Contents %d 0 R >>", PDFPageWidth, PDFPageHeight, resources, len(offsets)+2), nil)
+		object(fmt.Sprintf("<< /Filter /FlateDecode /Length %d >>", len(content)), content)
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.WriteTo(w)
+}
+
+func pdfDeflate(data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return compressed.Bytes(), nil
+}
+
+func pdfColour(colour color.Color) string {
+	r, g, b, _ := colour.RGBA()
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff)
+}
+
+// pdfString encodes text in WinAnsi and escapes it for a PDF string literal.
+func pdfString(text string) string {
+	var encoded strings.Builder
+	for _, char := range text {
+		var code byte
+		switch {
+		case char < 0x80 || (char >= 0xa0 && char <= 0xff):
+			code = byte(char)
+		case pdfWinAnsi[char] != 0:
+			code = pdfWinAnsi[char]
+		default:
+			code = '?'
+		}
+
+		if code == '(' || code == ')' || code == '\\' {
+			encoded.WriteByte('\\')
+		}
+		encoded.WriteByte(code)
+	}
+
+	return encoded.String()
+}
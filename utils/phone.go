@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var (
+	e164Pattern     = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+	phoneSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+)
+
+func init() {
+	Validator.RegisterValidation("phone", func(fl validator.FieldLevel) bool {
+		_, err := NormalizePhone(fl.Field().String())
+		return err == nil
+	})
+}
+
+// NormalizePhone turns a phone number into E.164, reading numbers without a
+// country code as Nigerian, so 0803 123 4567 becomes +2348031234567.
+func NormalizePhone(number string) (string, error) {
+	number = phoneSeparators.Replace(strings.TrimSpace(number))
+
+	switch {
+	case strings.HasPrefix(number, "+"):
+	case strings.HasPrefix(number, "00"):
+		number = "+" + number[2:]
+	case strings.HasPrefix(number, "234") && len(number) == 13:
+		number = "+" + number
+	case strings.HasPrefix(number, "0") && len(number) == 11:
+		number = "+234" + number[1:]
+	case len(number) == 10 && strings.ContainsAny(number[:1], "789"):
+		number = "+234" + number
+	}
+
+	if !e164Pattern.MatchString(number) {
+		return "", fmt.Errorf("%q is not a valid phone number", number)
+	}
+
+	return number, nil
+}
@@ -0,0 +1,47 @@
+package utils
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		number string
+		want   string
+	}{
+		{"0803 123 4567", "+2348031234567"},
+		{"08031234567", "+2348031234567"},
+		{"2348031234567", "+2348031234567"},
+		{"+234 803 123 4567", "+2348031234567"},
+		{"002348031234567", "+2348031234567"},
+		{"8031234567", "+2348031234567"},
+		{"(0803) 123-4567", "+2348031234567"},
+		{"+44 20 7946 0958", "+442079460958"},
+		{" +1.415.555.2671 ", "+14155552671"},
+	}
+
+	for _, test := range tests {
+		got, err := NormalizePhone(test.number)
+		if err != nil {
+			t.Errorf("NormalizePhone(%q) returned %v", test.number, err)
+		} else if got != test.want {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", test.number, got, test.want)
+		}
+	}
+}
+
+func TestNormalizePhoneInvalid(t *testing.T) {
+	numbers := []string{
+		"",
+		"12345",
+		"0803123456",
+		"6031234567",
+		"+0 803 123 4567",
+		"+2348031234567890",
+		"0803-CALL-NOW",
+	}
+
+	for _, number := range numbers {
+		if got, err := NormalizePhone(number); err == nil {
+			t.Errorf("NormalizePhone(%q) = %q, want an error", number, got)
+		}
+	}
+}
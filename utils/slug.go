@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// slugLetters covers the letters that don't decompose into an ASCII letter
+// plus accents.
+var slugLetters = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "ae", 'œ': "oe", 'Œ': "oe", 'ø': "o", 'Ø': "o",
+	'đ': "d", 'Đ': "d", 'ð': "d", 'Ð': "d", 'þ': "th", 'Þ': "th", 'ł': "l",
+	'Ł': "l", 'ı': "i", 'ħ': "h", 'Ħ': "h", 'ŋ': "n", 'Ŋ': "n", '&': " and ",
+}
+
+const slugMaxLength = 120
+
+// Slugify turns a name into a lowercase, hyphenated ASCII slug, e.g.
+// "Café Crème Hoodie" becomes "cafe-creme-hoodie". Accents are stripped and
+// anything else that isn't a letter or digit separates words.
+func Slugify(name string) string {
+	var slug strings.Builder
+	hyphen := false
+
+	for _, r := range norm.NFKD.String(name) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+
+		text := string(unicode.ToLower(r))
+		if letters, ok := slugLetters[r]; ok {
+			text = letters
+		}
+
+		for _, c := range text {
+			if c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+				if hyphen && slug.Len() > 0 {
+					slug.WriteByte('-')
+				}
+				slug.WriteRune(c)
+				hyphen = false
+			} else {
+				hyphen = true
+			}
+		}
+	}
+
+	result := slug.String()
+	if len(result) > slugMaxLength {
+		result = strings.TrimRight(result[:slugMaxLength], "-")
+	}
+
+	return result
+}
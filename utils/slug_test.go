@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Café Crème Hoodie", "cafe-creme-hoodie"},
+		{"  Ankara  Shirt  ", "ankara-shirt"},
+		{"Straße Jacket", "strasse-jacket"},
+		{"Black & White Tee", "black-and-white-tee"},
+		{"Łódź Øresund Þing", "lodz-oresund-thing"},
+		{"Agbada (2024 Edition)!", "agbada-2024-edition"},
+		{"--Dashiki--", "dashiki"},
+		{"ＴＳＨＩＲＴ ２", "tshirt-2"},
+		{"", ""},
+		{"!!!", ""},
+	}
+
+	for _, test := range tests {
+		if got := Slugify(test.name); got != test.want {
+			t.Errorf("Slugify(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSlugifyLength(t *testing.T) {
+	slug := Slugify(strings.Repeat("a", slugMaxLength-1) + " b")
+	if slug != strings.Repeat("a", slugMaxLength-1) {
+		t.Errorf("Slugify kept a trailing hyphen or went over %d characters: %q", slugMaxLength, slug)
+	}
+
+	if slug := Slugify(strings.Repeat("ab ", 100)); len(slug) > slugMaxLength {
+		t.Errorf("Slugify returned %d characters, want at most %d", len(slug), slugMaxLength)
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -118,6 +119,40 @@ func VerifyToken(token string) (string, error) {
 	return email, nil
 }
 
+// SignPurposeToken issues a token for subject that is only accepted back by
+// VerifyPurposeToken for the same purpose. A zero ttl never expires.
+func SignPurposeToken(subject string, purpose string, ttl time.Duration) (string, error) {
+	secretKey := []byte(os.Getenv("SECRET_KEY"))
+	claims := jwt.MapClaims{
+		"sub": subject,
+		"iss": "poohda",
+		"aud": purpose,
+		"iat": time.Now().Unix(),
+	}
+	if ttl > 0 {
+		claims["exp"] = time.Now().Add(ttl).Unix()
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secretKey)
+}
+
+func VerifyPurposeToken(token string, purpose string) (string, error) {
+	secretKey := []byte(os.Getenv("SECRET_KEY"))
+	verifiedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		return secretKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(purpose), jwt.WithIssuer("poohda"))
+	if err != nil {
+		return "", fmt.Errorf("Invalid or expired link")
+	}
+
+	subject, err := verifiedToken.Claims.GetSubject()
+	if err != nil || subject == "" {
+		return "", fmt.Errorf("Invalid or expired link")
+	}
+
+	return subject, nil
+}
+
 func HashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -127,6 +162,23 @@ func HashPassword(password string) (string, error) {
 	return string(hash), nil
 }
 
+// codeAlphabet leaves out 0/O and 1/I so codes survive being read aloud.
+const codeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// RandomCode returns a random, human-friendly code of the given length.
+func RandomCode(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	for i, b := range buf {
+		buf[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+
+	return string(buf), nil
+}
+
 func InitializeCloudinary() (*cloudinary.Cloudinary, error) {
 	fmt.Print(CLOUDINARY_API_KEY)
 	fmt.Print(CLOUDINARY_SECRET)